@@ -25,11 +25,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/dlmiddlecote/sqlstats"
@@ -40,6 +43,7 @@ import (
 	"github.com/sapcc/go-bits/httpee"
 	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/limes"
 	"github.com/sapcc/limes/pkg/api"
 	"github.com/sapcc/limes/pkg/collector"
 	"github.com/sapcc/limes/pkg/core"
@@ -58,6 +62,13 @@ func main() {
 	}
 	taskName, configPath := os.Args[1], os.Args[2]
 
+	//this task works offline, so it does not need a valid configuration or a
+	//database connection
+	if taskName == "validate-config" {
+		taskValidateConfig(configPath, os.Args[3:])
+		return
+	}
+
 	//load configuration
 	config := core.NewConfiguration(configPath)
 
@@ -110,6 +121,11 @@ func main() {
 		printUsageAndExit()
 	}
 
+	//long-running tasks can reload parts of their configuration on SIGHUP
+	if taskName == "collect" || taskName == "serve" {
+		go reloadConfigurationOnSIGHUP(config, configPath)
+	}
+
 	//run task
 	err = task(config, cluster, remainingArgs)
 	if err != nil {
@@ -120,6 +136,7 @@ func main() {
 var usageMessage = strings.Replace(strings.TrimSpace(`
 Usage:
 \t%s (collect|serve) <config-file> <cluster-id>
+\t%s validate-config <config-file> [<resource-cache-file>]
 \t%s test-get-quota <config-file> <cluster-id> <project-id> <service-type>
 \t%s test-get-rates <config-file> <cluster-id> <project-id> <service-type> [<prev-serialized-state>]
 \t%s test-set-quota <config-file> <cluster-id> <project-id> <service-type> <resource-name>=<integer-value>...
@@ -131,6 +148,16 @@ func printUsageAndExit() {
 	os.Exit(1)
 }
 
+func reloadConfigurationOnSIGHUP(config core.Configuration, configPath string) {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGHUP)
+	for range signalChan {
+		logg.Info("received SIGHUP, reloading configuration from %s", configPath)
+		//errors are logged and counted by Reload() itself
+		_ = config.Reload(configPath)
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // task: collect
 
@@ -233,6 +260,49 @@ func taskServe(config core.Configuration, cluster *core.Cluster, args []string)
 	return httpee.ListenAndServeContext(httpee.ContextWithSIGINT(context.Background(), 10*time.Second), config.API.ListenAddress, nil)
 }
 
+////////////////////////////////////////////////////////////////////////////////
+// task: validate-config
+
+func taskValidateConfig(configPath string, args []string) {
+	var resourceCache struct {
+		Clusters []limes.ClusterReport `json:"clusters"`
+	}
+	switch len(args) {
+	case 0:
+		break
+	case 1:
+		buf, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			logg.Fatal("read resource cache: %s", err.Error())
+		}
+		err = json.Unmarshal(buf, &resourceCache)
+		if err != nil {
+			logg.Fatal("parse resource cache: %s", err.Error())
+		}
+	default:
+		printUsageAndExit()
+	}
+
+	diagnostics := core.ValidateConfigurationOffline(configPath, resourceCache.Clusters)
+	if diagnostics == nil {
+		diagnostics = []core.ConfigDiagnostic{} //render as [] instead of null
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(struct {
+		Diagnostics []core.ConfigDiagnostic `json:"diagnostics"`
+	}{diagnostics})
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+
+	for _, d := range diagnostics {
+		if d.Severity == "error" {
+			os.Exit(1)
+		}
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // tasks: test quota plugin
 
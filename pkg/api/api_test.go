@@ -21,9 +21,11 @@ package api
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"math"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"regexp"
 	"strings"
@@ -37,12 +39,6 @@ import (
 	"github.com/sapcc/limes/pkg/test"
 )
 
-func init() {
-	//This is required for core.GetServiceTypesForArea() to work.
-	core.RegisterQuotaPlugin(test.NewPluginFactory("shared"))
-	core.RegisterQuotaPlugin(test.NewPluginFactory("unshared"))
-}
-
 func setupTest(t *testing.T, clusterName, startData string) (*core.Cluster, http.Handler, *TestPolicyEnforcer) {
 	//load test database
 	t.Helper()
@@ -176,14 +172,13 @@ func setupTest(t *testing.T, clusterName, startData string) (*core.Cluster, http
 	config := core.Configuration{
 		Clusters: map[string]*core.Cluster{
 			"west": {
-				ID:               "west",
-				ServiceTypes:     serviceTypes,
-				IsServiceShared:  isServiceShared,
-				DiscoveryPlugin:  test.NewDiscoveryPlugin(),
-				QuotaPlugins:     quotaPlugins,
-				CapacityPlugins:  map[string]core.CapacityPlugin{},
-				QuotaConstraints: &westConstraintSet,
-				Config:           westClusterConfig,
+				ID:              "west",
+				ServiceTypes:    serviceTypes,
+				IsServiceShared: isServiceShared,
+				DiscoveryPlugin: test.NewDiscoveryPlugin(),
+				QuotaPlugins:    quotaPlugins,
+				CapacityPlugins: map[string]core.CapacityPlugin{},
+				Config:          westClusterConfig,
 			},
 			"east": {
 				ID:              "east",
@@ -213,6 +208,11 @@ func setupTest(t *testing.T, clusterName, startData string) (*core.Cluster, http
 	}
 	config.API.PolicyEnforcer = enforcer
 
+	config.Clusters["west"].SetSettings(core.ClusterSettings{
+		Config:           westClusterConfig,
+		QuotaConstraints: &westConstraintSet,
+	})
+
 	config.Clusters["west"].Config.ResourceBehaviors = []*core.ResourceBehaviorConfiguration{
 		//check minimum non-zero project quota constraint
 		{
@@ -360,6 +360,14 @@ func Test_ClusterOperations(t *testing.T) {
 		ExpectStatus: 200,
 		ExpectBody:   assert.JSONFixtureFile("fixtures/cluster-list-local.json"),
 	}.Check(t, router)
+
+	//check GetAggregateCluster
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v1/clusters/aggregate",
+		ExpectStatus: 200,
+		ExpectBody:   assert.JSONFixtureFile("fixtures/cluster-get-aggregate.json"),
+	}.Check(t, router)
 	assert.HTTPRequest{
 		Method:       "GET",
 		Path:         "/v1/clusters?service=unknown",
@@ -1012,6 +1020,33 @@ func Test_ProjectOperations(t *testing.T) {
 		ExpectStatus: 200,
 		ExpectBody:   assert.JSONFixtureFile("./fixtures/project-list-filtered.json"),
 	}.Check(t, router)
+	//check CSV output (with and without pagination)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v1/domains/uuid-for-germany/projects?service=shared&resource=things&format=csv",
+		ExpectStatus: 200,
+		ExpectBody: assert.StringData(
+			"domain_id,domain_name,project_id,project_name,area,service,resource,unit,quota,usage,burst_usage,physical_usage\n" +
+				"uuid-for-germany,germany,uuid-for-berlin,berlin,shared,shared,things,,10,2,0,\n" +
+				"uuid-for-germany,germany,uuid-for-dresden,dresden,shared,shared,things,,10,2,0,\n",
+		),
+	}.Check(t, router)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v1/domains/uuid-for-germany/projects/uuid-for-berlin?service=shared&resource=things",
+		Header:       map[string]string{"Accept": "text/csv"},
+		ExpectStatus: 200,
+		ExpectBody: assert.StringData(
+			"domain_id,domain_name,project_id,project_name,area,service,resource,unit,quota,usage,burst_usage,physical_usage\n" +
+				"uuid-for-germany,germany,uuid-for-berlin,berlin,shared,shared,things,,10,2,0,\n",
+		),
+	}.Check(t, router)
+	assert.HTTPRequest{
+		Method:       "GET",
+		Path:         "/v1/domains/uuid-for-germany/projects?format=xml",
+		ExpectStatus: 400,
+		ExpectBody:   assert.StringData("invalid value for format (expected \"json\" or \"csv\")\n"),
+	}.Check(t, router)
 
 	//check ?area= filter (esp. interaction with ?service= filter)
 	assert.HTTPRequest{
@@ -1552,7 +1587,7 @@ func Test_RaiseLowerPermissions(t *testing.T) {
 	cluster, router, enforcer := setupTest(t, clusterName, pathtoData)
 
 	//we're not testing this right now
-	cluster.QuotaConstraints = nil
+	cluster.SetSettings(core.ClusterSettings{Config: cluster.Config})
 
 	//test that the correct 403 errors are generated for missing permissions
 	//(the other testcases cover the happy paths for raising and lowering)
@@ -1711,12 +1746,14 @@ func Test_RaiseLowerPermissions(t *testing.T) {
 	enforcer.AllowLower = true
 	enforcer.RejectServiceType = ""
 
-	cluster.LowPrivilegeRaise.LimitsForDomains = map[string]map[string]core.LowPrivilegeRaiseLimit{
+	settings := *cluster.Settings()
+	settings.LowPrivilegeRaise.LimitsForDomains = map[string]map[string]core.LowPrivilegeRaiseLimit{
 		"shared": {"capacity": {AbsoluteValue: 29}, "things": {AbsoluteValue: 35}},
 	}
-	cluster.LowPrivilegeRaise.LimitsForProjects = map[string]map[string]core.LowPrivilegeRaiseLimit{
+	settings.LowPrivilegeRaise.LimitsForProjects = map[string]map[string]core.LowPrivilegeRaiseLimit{
 		"shared": {"capacity": {AbsoluteValue: 10}, "things": {AbsoluteValue: 25}},
 	}
+	cluster.SetSettings(settings)
 
 	assert.HTTPRequest{
 		Method:       "PUT",
@@ -1788,14 +1825,16 @@ func Test_RaiseLowerPermissions(t *testing.T) {
 
 	//test low-privilege raise limits that are specified as percent of cluster capacity
 	cluster.Config.LowPrivilegeRaise.ExcludeProjectDomainRx = nil
-	cluster.LowPrivilegeRaise.LimitsForDomains = map[string]map[string]core.LowPrivilegeRaiseLimit{
+	settings = *cluster.Settings()
+	settings.LowPrivilegeRaise.LimitsForDomains = map[string]map[string]core.LowPrivilegeRaiseLimit{
 		// shared/things capacity is 246, so 13% is 31.98 which rounds down to 31
 		"shared": {"things": {PercentOfClusterCapacity: 13}},
 	}
-	cluster.LowPrivilegeRaise.LimitsForProjects = map[string]map[string]core.LowPrivilegeRaiseLimit{
+	settings.LowPrivilegeRaise.LimitsForProjects = map[string]map[string]core.LowPrivilegeRaiseLimit{
 		// shared/things capacity is 246, so 5% is 12.3 which rounds down to 12
 		"shared": {"things": {PercentOfClusterCapacity: 5}},
 	}
+	cluster.SetSettings(settings)
 
 	assert.HTTPRequest{
 		Method:       "PUT",
@@ -1839,12 +1878,14 @@ func Test_RaiseLowerPermissions(t *testing.T) {
 	}.Check(t, router)
 
 	//test low-privilege raise limits that are specified as percentage of assigned cluster capacity over all domains
-	cluster.LowPrivilegeRaise.LimitsForDomains = map[string]map[string]core.LowPrivilegeRaiseLimit{
+	settings = *cluster.Settings()
+	settings.LowPrivilegeRaise.LimitsForDomains = map[string]map[string]core.LowPrivilegeRaiseLimit{
 		// - shared/things capacity is 246, 45% thereof is 110.7 which rounds down to 110
 		// - all shared/things domain quotas sum up to 90, of which germany has 30
 		// -> germany should be able to go up to 50 before sum(domain quotas) exceeds 110
 		"shared": {"things": {UntilPercentOfClusterCapacityAssigned: 45}},
 	}
+	cluster.SetSettings(settings)
 
 	assert.HTTPRequest{
 		Method:       "PUT",
@@ -2131,3 +2172,222 @@ func Test_QuotaBursting(t *testing.T) {
 		Body:         body,
 	}.Check(t, router)
 }
+
+func Test_ListPagination(t *testing.T) {
+	_, router, _ := setupTest(t, "west", "fixtures/start-data.sql")
+
+	//without ?limit=, all objects are on one page
+	expectListedUUIDs(t, router, "/v1/domains", "domains", "",
+		"uuid-for-france", "uuid-for-germany")
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects", "projects", "",
+		"uuid-for-berlin", "uuid-for-dresden")
+
+	//paginate through the project list one by one
+	cursor := expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?limit=1", "projects", "<non-empty>",
+		"uuid-for-berlin")
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?limit=1&cursor="+cursor, "projects", "",
+		"uuid-for-dresden")
+
+	//same with sorting
+	cursor = expectListedUUIDs(t, router, "/v1/domains?sort=-name&limit=1", "domains", "<non-empty>",
+		"uuid-for-germany")
+	expectListedUUIDs(t, router, "/v1/domains?sort=-name&limit=1&cursor="+cursor, "domains", "",
+		"uuid-for-france")
+	cursor = expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?service=shared&resource=things&sort=usage&limit=1", "projects", "<non-empty>",
+		"uuid-for-berlin")
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?service=shared&resource=things&sort=usage&limit=1&cursor="+cursor, "projects", "",
+		"uuid-for-dresden")
+	expectListedUUIDs(t, router, "/v1/domains?service=shared&resource=things&sort=-quota", "domains", "",
+		"uuid-for-germany", "uuid-for-france")
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?sort=scraped_at", "projects", "",
+		"uuid-for-berlin", "uuid-for-dresden")
+
+	//filter by name pattern and parent
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?name=dres*", "projects", "",
+		"uuid-for-dresden")
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?name=b_rlin", "projects", "")
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?parent_id=uuid-for-berlin", "projects", "",
+		"uuid-for-dresden")
+	expectListedUUIDs(t, router, "/v1/domains?name=*an*", "domains", "",
+		"uuid-for-france", "uuid-for-germany")
+
+	//filter by usage conditions
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?usage_gt_quota", "projects", "")
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?has_bursted", "projects", "")
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?service=shared&resource=things&quota_utilization_above=0.1", "projects", "",
+		"uuid-for-berlin", "uuid-for-dresden")
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?service=shared&resource=things&quota_utilization_above=0.5", "projects", "")
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?scraped_before=12", "projects", "",
+		"uuid-for-berlin")
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?service=shared&scraped_before=30", "projects", "",
+		"uuid-for-berlin")
+	expectListedUUIDs(t, router, "/v1/domains/uuid-for-germany/projects?service=shared&scraped_before=50", "projects", "",
+		"uuid-for-berlin", "uuid-for-dresden")
+	expectListedUUIDs(t, router, "/v1/domains?scraped_before=30", "domains", "",
+		"uuid-for-germany")
+
+	//error cases
+	for _, path := range []string{
+		"/v1/domains?quota_utilization_above=foo",
+		"/v1/domains?quota_utilization_above=-1",
+		"/v1/domains?scraped_before=yesterday",
+		"/v1/domains?limit=0",
+		"/v1/domains?limit=foo",
+		"/v1/domains?limit=10001",
+		"/v1/domains?limit=18446744073709551615",
+		"/v1/domains?sort=foo",
+		"/v1/domains?sort=usage",
+		"/v1/domains?parent_id=uuid-for-germany",
+		"/v1/domains?cursor=foo",
+		"/v1/domains/uuid-for-germany/projects?sort=name&cursor=" + cursor,
+	} {
+		assert.HTTPRequest{
+			Method:       "GET",
+			Path:         path,
+			ExpectStatus: 400,
+		}.Check(t, router)
+	}
+}
+
+//expectListedUUIDs checks the order of objects in a GET /v1/domains or GET
+///v1/domains/:id/projects response, and returns the next_cursor.
+func expectListedUUIDs(t *testing.T, router http.Handler, path, key, expectedCursor string, expectedUUIDs ...string) string {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Errorf("GET %s: expected status 200, got %d (%s)", path, rec.Code, rec.Body.String())
+		return ""
+	}
+
+	var data map[string]json.RawMessage
+	err := json.Unmarshal(rec.Body.Bytes(), &data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var objects []struct {
+		UUID string `json:"id"`
+	}
+	err = json.Unmarshal(data[key], &objects)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	actualUUIDs := []string{}
+	for _, obj := range objects {
+		actualUUIDs = append(actualUUIDs, obj.UUID)
+	}
+	if expectedUUIDs == nil {
+		expectedUUIDs = []string{}
+	}
+	if !reflect.DeepEqual(actualUUIDs, expectedUUIDs) {
+		t.Errorf("GET %s: expected %s = %v, got %v", path, key, expectedUUIDs, actualUUIDs)
+	}
+
+	var cursor string
+	if raw, exists := data["next_cursor"]; exists {
+		err = json.Unmarshal(raw, &cursor)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if (expectedCursor == "") != (cursor == "") {
+		t.Errorf("GET %s: expected next_cursor %q, got %q", path, expectedCursor, cursor)
+	}
+	return cursor
+}
+
+func Test_ConditionalRequests(t *testing.T) {
+	_, router, _ := setupTest(t, "west", "fixtures/start-data.sql")
+
+	paths := []string{
+		"/v1/clusters",
+		"/v1/clusters/current",
+		"/v1/clusters/aggregate",
+		"/v1/domains",
+		"/v1/domains/uuid-for-germany",
+		"/v1/domains/uuid-for-germany/projects",
+		"/v1/domains/uuid-for-germany/projects/uuid-for-berlin",
+	}
+
+	//repeated requests yield the same ETag, and a matching If-None-Match yields 304
+	etags := make(map[string]string)
+	for _, path := range paths {
+		etag := expectETag(t, router, path, "", 200)
+		if etag == "" {
+			t.Errorf("GET %s: expected ETag, got none", path)
+		}
+		etags[path] = etag
+		expectETag(t, router, path, etag, 304)
+		expectETag(t, router, path, `"something-else", `+etag, 304)
+		expectETag(t, router, path, "*", 304)
+		expectETag(t, router, path, `"something-else"`, 200)
+	}
+
+	//different query parameters yield a different ETag
+	etag := expectETag(t, router, "/v1/domains/uuid-for-germany?service=shared", etags["/v1/domains/uuid-for-germany"], 200)
+	if etag == etags["/v1/domains/uuid-for-germany"] {
+		t.Error("expected ETag to depend on query parameters")
+	}
+
+	//changing a project quota invalidates the ETags of all reports containing
+	//this project, but not those of other projects
+	otherProjectPath := "/v1/domains/uuid-for-germany/projects/uuid-for-dresden"
+	etags[otherProjectPath] = expectETag(t, router, otherProjectPath, "", 200)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/v1/domains/uuid-for-germany/projects/uuid-for-berlin",
+		ExpectStatus: 202,
+		ExpectBody:   assert.StringData(""),
+		Body: assert.JSONObject{
+			"project": assert.JSONObject{
+				"services": []assert.JSONObject{{
+					"type":      "shared",
+					"resources": []assert.JSONObject{{"name": "things", "quota": 8}},
+				}},
+			},
+		},
+	}.Check(t, router)
+	for _, path := range paths {
+		expectETag(t, router, path, etags[path], 200)
+	}
+	expectETag(t, router, otherProjectPath, etags[otherProjectPath], 304)
+
+	//same for a domain quota
+	etag = expectETag(t, router, "/v1/domains/uuid-for-germany", "", 200)
+	assert.HTTPRequest{
+		Method:       "PUT",
+		Path:         "/v1/domains/uuid-for-germany",
+		ExpectStatus: 202,
+		ExpectBody:   assert.StringData(""),
+		Body: assert.JSONObject{
+			"domain": assert.JSONObject{
+				"services": []assert.JSONObject{{
+					"type":      "shared",
+					"resources": []assert.JSONObject{{"name": "things", "quota": 40}},
+				}},
+			},
+		},
+	}.Check(t, router)
+	expectETag(t, router, "/v1/domains/uuid-for-germany", etag, 200)
+	expectETag(t, router, otherProjectPath, etags[otherProjectPath], 304)
+}
+
+//expectETag performs a GET request with the given If-None-Match header (if
+//any), checks the response status and returns the ETag from the response.
+func expectETag(t *testing.T, router http.Handler, path, ifNoneMatch string, expectedStatus int) string {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != expectedStatus {
+		t.Errorf("GET %s with If-None-Match %q: expected status %d, got %d (%s)", path, ifNoneMatch, expectedStatus, rec.Code, rec.Body.String())
+	}
+	if expectedStatus == http.StatusNotModified && rec.Body.Len() > 0 {
+		t.Errorf("GET %s with If-None-Match %q: expected empty body, got %q", path, ifNoneMatch, rec.Body.String())
+	}
+	return rec.Header().Get("ETag")
+}
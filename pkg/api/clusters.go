@@ -42,21 +42,68 @@ func (p *v1Provider) ListClusters(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	isCSV, ok := readResponseFormat(w, r)
+	if !ok {
+		return
+	}
+
 	var result struct {
 		CurrentCluster string                 `json:"current_cluster"`
 		Clusters       []*limes.ClusterReport `json:"clusters"`
 	}
 	result.CurrentCluster = currentCluster.ID
 
+	if respondIfNotModified(w, r, clusterETagScope()) {
+		return
+	}
+
 	var err error
-	result.Clusters, err = reports.GetClusters(p.Config, nil, db.DB, reports.ReadFilter(r))
+	result.Clusters, err = reports.GetClusters(p.Config, nil, db.DB, reports.ReadFilter(r, p.allClusters()...))
 	if respondwith.ErrorText(w, err) {
 		return
 	}
 
+	if isCSV {
+		var rows [][]string
+		for _, cluster := range result.Clusters {
+			rows = append(rows, clusterCSVRows(cluster)...)
+		}
+		respondWithCSV(w, clusterCSVHeader, rows)
+		return
+	}
 	respondwith.JSON(w, 200, result)
 }
 
+//GetAggregateCluster handles GET /v1/clusters/aggregate.
+func (p *v1Provider) GetAggregateCluster(w http.ResponseWriter, r *http.Request) {
+	sre.IdentifyEndpoint(r, "/v1/clusters/aggregate")
+	token := p.CheckToken(r)
+	//the aggregate contains data from all clusters, so it needs the same
+	//permission as ListClusters
+	if !token.Require(w, "cluster:list") {
+		return
+	}
+
+	isCSV, ok := readResponseFormat(w, r)
+	if !ok {
+		return
+	}
+	if respondIfNotModified(w, r, clusterETagScope()) {
+		return
+	}
+
+	cluster, err := reports.GetAggregateCluster(p.Config, db.DB, reports.ReadFilter(r, p.allClusters()...))
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
+	if isCSV {
+		respondWithCSV(w, clusterCSVHeader, clusterCSVRows(cluster))
+		return
+	}
+	respondwith.JSON(w, 200, map[string]interface{}{"cluster": cluster})
+}
+
 //GetCluster handles GET /v1/clusters/:cluster_id.
 func (p *v1Provider) GetCluster(w http.ResponseWriter, r *http.Request) {
 	sre.IdentifyEndpoint(r, "/v1/clusters/:id")
@@ -76,7 +123,12 @@ func (p *v1Provider) GetCluster(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filter := reports.ReadFilter(r)
+	isCSV, ok := readResponseFormat(w, r)
+	if !ok {
+		return
+	}
+
+	filter := reports.ReadFilter(r, p.allClusters()...)
 	if showBasic {
 		if filter.LocalQuotaUsageOnly {
 			http.Error(w, "Forbidden", http.StatusForbidden)
@@ -89,6 +141,10 @@ func (p *v1Provider) GetCluster(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if respondIfNotModified(w, r, clusterETagScope()) {
+		return
+	}
+
 	clusters, err := reports.GetClusters(p.Config, &clusterID, db.DB, filter)
 	if respondwith.ErrorText(w, err) {
 		return
@@ -98,5 +154,9 @@ func (p *v1Provider) GetCluster(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isCSV {
+		respondWithCSV(w, clusterCSVHeader, clusterCSVRows(clusters[0]))
+		return
+	}
 	respondwith.JSON(w, 200, map[string]interface{}{"cluster": clusters[0]})
 }
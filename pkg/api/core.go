@@ -25,8 +25,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
-	"sync"
 
 	"github.com/gorilla/mux"
 	"github.com/sapcc/go-bits/gopherpolicy"
@@ -34,6 +34,7 @@ import (
 	"github.com/sapcc/go-bits/sre"
 	"github.com/sapcc/limes"
 	"github.com/sapcc/limes/pkg/core"
+	"github.com/sapcc/limes/pkg/datamodel"
 	"github.com/sapcc/limes/pkg/db"
 	"github.com/sapcc/limes/pkg/reports"
 )
@@ -57,15 +58,12 @@ type v1Provider struct {
 	Cluster     *core.Cluster
 	Config      core.Configuration
 	VersionData VersionData
-	//see comment in ListProjects() for details
-	listProjectsMutex sync.Mutex
 }
 
 //NewV1Router creates a http.Handler that serves the Limes v1 API.
 //It also returns the VersionData for this API version which is needed for the
 //version advertisement on "GET /".
 func NewV1Router(cluster *core.Cluster, config core.Configuration) (http.Handler, VersionData) {
-	r := mux.NewRouter()
 	p := &v1Provider{
 		Cluster: cluster,
 		Config:  config,
@@ -86,11 +84,21 @@ func NewV1Router(cluster *core.Cluster, config core.Configuration) (http.Handler
 		},
 	}
 
+	return sre.Instrument(p.router()), p.VersionData
+}
+
+//router builds the mux.Router for this API. This is separate from
+//NewV1Router() so that tests can enumerate all routes.
+func (p *v1Provider) router() *mux.Router {
+	r := mux.NewRouter()
+
 	r.Methods("GET").Path("/v1/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		respondwith.JSON(w, 200, map[string]interface{}{"version": p.VersionData})
 	})
+	r.Methods("GET").Path("/v1/openapi.json").HandlerFunc(p.GetOpenAPIDocument)
 
 	r.Methods("GET").Path("/v1/clusters").HandlerFunc(p.ListClusters)
+	r.Methods("GET").Path("/v1/clusters/aggregate").HandlerFunc(p.GetAggregateCluster)
 	r.Methods("GET").Path("/v1/clusters/{cluster_id}").HandlerFunc(p.GetCluster)
 
 	r.Methods("GET").Path("/v1/inconsistencies").HandlerFunc(p.ListInconsistencies)
@@ -108,7 +116,7 @@ func NewV1Router(cluster *core.Cluster, config core.Configuration) (http.Handler
 	r.Methods("POST").Path("/v1/domains/{domain_id}/projects/{project_id}/simulate-put").HandlerFunc(p.SimulatePutProject)
 	r.Methods("PUT").Path("/v1/domains/{domain_id}/projects/{project_id}").HandlerFunc(p.PutProject)
 
-	return sre.Instrument(r), p.VersionData
+	return r
 }
 
 //RequireJSON will parse the request body into the given data structure, or
@@ -160,6 +168,22 @@ func (p *v1Provider) FindClusterFromRequest(w http.ResponseWriter, r *http.Reque
 	return cluster
 }
 
+//allClusters returns all configured clusters, sorted by ID. This is used for
+//reports that can contain data from multiple clusters.
+func (p *v1Provider) allClusters() []*core.Cluster {
+	clusterIDs := make([]string, 0, len(p.Config.Clusters))
+	for clusterID := range p.Config.Clusters {
+		clusterIDs = append(clusterIDs, clusterID)
+	}
+	sort.Strings(clusterIDs)
+
+	result := make([]*core.Cluster, len(clusterIDs))
+	for idx, clusterID := range clusterIDs {
+		result[idx] = p.Config.Clusters[clusterID]
+	}
+	return result
+}
+
 //FindDomainFromRequest loads the db.Domain referenced by the :domain_id path
 //parameter. Any errors will be written into the response immediately and cause
 //a nil return value.
@@ -237,26 +261,51 @@ func GetClusterReport(config core.Configuration, cluster *core.Cluster, dbi db.I
 	return clusterReports[0], nil
 }
 
+//GetCachedClusterReport is like GetClusterReport(), but builds the report from
+//the `cluster_aggregates` table, which is much cheaper for large clusters. The
+//report only contains quota, usage and capacity values.
+func GetCachedClusterReport(config core.Configuration, cluster *core.Cluster, dbi db.Interface) (*limes.ClusterReport, error) {
+	//shared services aggregate over all clusters, so all of them need to be cached
+	clusterIDs := make([]string, 0, len(config.Clusters))
+	for clusterID := range config.Clusters {
+		clusterIDs = append(clusterIDs, clusterID)
+	}
+	sort.Strings(clusterIDs)
+	err := datamodel.FillClusterAggregates(clusterIDs)
+	if err != nil {
+		return nil, err
+	}
+	return GetClusterReport(config, cluster, dbi, reports.Filter{FromAggregateCache: true})
+}
+
 //GetDomainReport is a convenience wrapper around reports.GetDomains() for getting a single domain report.
 func GetDomainReport(cluster *core.Cluster, dbDomain db.Domain, dbi db.Interface, filter reports.Filter) (*limes.DomainReport, error) {
-	domainReports, err := reports.GetDomains(cluster, &dbDomain.ID, dbi, filter)
+	var result *limes.DomainReport
+	_, err := reports.GetDomains(cluster, &dbDomain.ID, dbi, filter, reports.ListOptions{}, func(domain *limes.DomainReport, _ string) error {
+		result = domain
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	if len(domainReports) == 0 {
+	if result == nil {
 		return nil, errors.New("no resource data found for domain")
 	}
-	return domainReports[0], nil
+	return result, nil
 }
 
 //GetProjectReport is a convenience wrapper around reports.GetProjects() for getting a single project report.
 func GetProjectReport(cluster *core.Cluster, dbDomain db.Domain, dbProject db.Project, dbi db.Interface, filter reports.Filter) (*limes.ProjectReport, error) {
-	projectReports, err := reports.GetProjects(cluster, dbDomain, &dbProject.ID, dbi, filter)
+	var result *limes.ProjectReport
+	_, err := reports.GetProjects(cluster, dbDomain, &dbProject.ID, dbi, filter, reports.ListOptions{}, func(project *limes.ProjectReport, _ string) error {
+		result = project
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	if len(projectReports) == 0 {
+	if result == nil {
 		return nil, errors.New("no resource data found for project")
 	}
-	return projectReports[0], nil
+	return result, nil
 }
@@ -0,0 +1,246 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package api
+
+import (
+	"encoding/csv"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/db"
+)
+
+const csvContentType = "text/csv; charset=utf-8"
+
+//readResponseFormat checks whether the client requested CSV output, either
+//with `?format=csv` or with `Accept: text/csv`. The query parameter takes
+//precedence over the header. If the requested format is not supported, an
+//error response is written and false is returned.
+func readResponseFormat(w http.ResponseWriter, r *http.Request) (isCSV, ok bool) {
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		return true, true
+	case "json":
+		return false, true
+	case "":
+		//check Accept header below
+	default:
+		http.Error(w, `invalid value for format (expected "json" or "csv")`, http.StatusBadRequest)
+		return false, false
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err == nil && mediaType == "text/csv" {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+//respondWithCSV is like respondwith.JSON, but for CSV output.
+func respondWithCSV(w http.ResponseWriter, header []string, rows [][]string) {
+	w.Header().Set("Content-Type", csvContentType)
+	w.WriteHeader(200)
+	cw := csv.NewWriter(w)
+	_ = cw.Write(header)
+	_ = cw.WriteAll(rows) //includes Flush()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// streaming CSV output
+
+//csvListWriter is the CSV counterpart of jsonListWriter. Since CSV has no
+//place for the "next_cursor" field, it is reported in the X-Limes-Next-Cursor
+//response header instead.
+type csvListWriter struct {
+	w       http.ResponseWriter
+	cw      *csv.Writer
+	header  []string
+	rowsFor func(item interface{}) [][]string
+	started bool
+}
+
+func newCSVListWriter(w http.ResponseWriter, header []string, rowsFor func(item interface{}) [][]string) *csvListWriter {
+	return &csvListWriter{w: w, cw: csv.NewWriter(w), header: header, rowsFor: rowsFor}
+}
+
+//Write implements the listWriter interface.
+func (c *csvListWriter) Write(item interface{}, nextCursor string) error {
+	if !c.started {
+		c.writeHeader(nextCursor)
+	}
+	err := c.cw.WriteAll(c.rowsFor(item)) //includes Flush()
+	return err
+}
+
+//Finish implements the listWriter interface.
+func (c *csvListWriter) Finish(nextCursor string) {
+	if !c.started {
+		c.writeHeader(nextCursor)
+	}
+	c.cw.Flush()
+}
+
+//Fail implements the listWriter interface.
+func (c *csvListWriter) Fail(r *http.Request, err error) {
+	if !c.started {
+		http.Error(c.w, err.Error(), 500)
+		return
+	}
+	logg.Error("aborting incomplete response for %s %s: %s", r.Method, r.URL.Path, err.Error())
+	//unlike a truncated JSON document, a truncated CSV document is
+	//indistinguishable from a complete one, so we need to abort the connection
+	//to make the client notice the failure
+	c.cw.Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func (c *csvListWriter) writeHeader(nextCursor string) {
+	c.started = true
+	c.w.Header().Set("Content-Type", csvContentType)
+	if nextCursor != "" {
+		c.w.Header().Set("X-Limes-Next-Cursor", nextCursor)
+	}
+	c.w.WriteHeader(200)
+	_ = c.cw.Write(c.header)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// conversion of reports into CSV rows
+
+//All values are converted into the base unit of their resource (e.g. MiB
+//into B), so that values in the same column can be compared and summed up
+//directly.
+func csvValue(value uint64, unit limes.Unit) string {
+	baseUnit, _ := unit.Base()
+	converted, err := limes.ValueWithUnit{Value: value, Unit: unit}.ConvertTo(baseUnit)
+	if err != nil {
+		//cannot happen since conversion into the base unit always works
+		return ""
+	}
+	return strconv.FormatUint(converted.Value, 10)
+}
+
+func csvOptionalValue(value *uint64, unit limes.Unit) string {
+	if value == nil {
+		return ""
+	}
+	return csvValue(*value, unit)
+}
+
+func csvUnit(unit limes.Unit) string {
+	baseUnit, _ := unit.Base()
+	return string(baseUnit)
+}
+
+var clusterCSVHeader = []string{"cluster_id", "area", "service", "resource", "unit", "capacity", "domains_quota", "usage", "burst_usage", "physical_usage"}
+
+func clusterCSVRows(cluster *limes.ClusterReport) (rows [][]string) {
+	serviceTypes := make([]string, 0, len(cluster.Services))
+	for serviceType := range cluster.Services {
+		serviceTypes = append(serviceTypes, serviceType)
+	}
+	sort.Strings(serviceTypes)
+	for _, serviceType := range serviceTypes {
+		srv := cluster.Services[serviceType]
+		resourceNames := make([]string, 0, len(srv.Resources))
+		for resourceName := range srv.Resources {
+			resourceNames = append(resourceNames, resourceName)
+		}
+		sort.Strings(resourceNames)
+		for _, resourceName := range resourceNames {
+			res := srv.Resources[resourceName]
+			rows = append(rows, []string{
+				cluster.ID, srv.Area, srv.Type, res.Name, csvUnit(res.Unit),
+				csvOptionalValue(res.Capacity, res.Unit),
+				csvOptionalValue(res.DomainsQuota, res.Unit),
+				csvValue(res.Usage, res.Unit),
+				csvValue(res.BurstUsage, res.Unit),
+				csvOptionalValue(res.PhysicalUsage, res.Unit),
+			})
+		}
+	}
+	return rows
+}
+
+var domainCSVHeader = []string{"domain_id", "domain_name", "area", "service", "resource", "unit", "quota", "projects_quota", "usage", "burst_usage", "physical_usage"}
+
+func domainCSVRows(domain *limes.DomainReport) (rows [][]string) {
+	serviceTypes := make([]string, 0, len(domain.Services))
+	for serviceType := range domain.Services {
+		serviceTypes = append(serviceTypes, serviceType)
+	}
+	sort.Strings(serviceTypes)
+	for _, serviceType := range serviceTypes {
+		srv := domain.Services[serviceType]
+		resourceNames := make([]string, 0, len(srv.Resources))
+		for resourceName := range srv.Resources {
+			resourceNames = append(resourceNames, resourceName)
+		}
+		sort.Strings(resourceNames)
+		for _, resourceName := range resourceNames {
+			res := srv.Resources[resourceName]
+			rows = append(rows, []string{
+				domain.UUID, domain.Name, srv.Area, srv.Type, res.Name, csvUnit(res.Unit),
+				csvOptionalValue(res.DomainQuota, res.Unit),
+				csvOptionalValue(res.ProjectsQuota, res.Unit),
+				csvValue(res.Usage, res.Unit),
+				csvValue(res.BurstUsage, res.Unit),
+				csvOptionalValue(res.PhysicalUsage, res.Unit),
+			})
+		}
+	}
+	return rows
+}
+
+var projectCSVHeader = []string{"domain_id", "domain_name", "project_id", "project_name", "area", "service", "resource", "unit", "quota", "usage", "burst_usage", "physical_usage"}
+
+func projectCSVRows(domain db.Domain, project *limes.ProjectReport) (rows [][]string) {
+	serviceTypes := make([]string, 0, len(project.Services))
+	for serviceType := range project.Services {
+		serviceTypes = append(serviceTypes, serviceType)
+	}
+	sort.Strings(serviceTypes)
+	for _, serviceType := range serviceTypes {
+		srv := project.Services[serviceType]
+		resourceNames := make([]string, 0, len(srv.Resources))
+		for resourceName := range srv.Resources {
+			resourceNames = append(resourceNames, resourceName)
+		}
+		sort.Strings(resourceNames)
+		for _, resourceName := range resourceNames {
+			res := srv.Resources[resourceName]
+			rows = append(rows, []string{
+				domain.UUID, domain.Name, project.UUID, project.Name, srv.Area, srv.Type, res.Name, csvUnit(res.Unit),
+				csvOptionalValue(res.Quota, res.Unit),
+				csvValue(res.Usage, res.Unit),
+				csvValue(res.BurstUsage, res.Unit),
+				csvOptionalValue(res.PhysicalUsage, res.Unit),
+			})
+		}
+	}
+	return rows
+}
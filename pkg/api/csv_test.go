@@ -0,0 +1,135 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/sapcc/limes"
+)
+
+//These tests do not need a database, so they do not use setupTest().
+
+func TestReadResponseFormat(t *testing.T) {
+	testCases := []struct {
+		Query        string
+		Accept       string
+		ExpectCSV    bool
+		ExpectStatus int
+	}{
+		{"", "", false, 200},
+		{"", "application/json", false, 200},
+		{"", "text/csv", true, 200},
+		{"", "application/json;q=0.9, text/csv; charset=utf-8", true, 200},
+		{"?format=csv", "", true, 200},
+		{"?format=json", "text/csv", false, 200},
+		{"?format=xlsx", "", false, 400},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/v1/domains"+tc.Query, nil)
+		if tc.Accept != "" {
+			req.Header.Set("Accept", tc.Accept)
+		}
+		rec := httptest.NewRecorder()
+		isCSV, ok := readResponseFormat(rec, req)
+		if ok != (tc.ExpectStatus == 200) || rec.Code != tc.ExpectStatus {
+			t.Errorf("query %q with Accept %q: expected status %d, got %d", tc.Query, tc.Accept, tc.ExpectStatus, rec.Code)
+		}
+		if ok && isCSV != tc.ExpectCSV {
+			t.Errorf("query %q with Accept %q: expected isCSV = %t, got %t", tc.Query, tc.Accept, tc.ExpectCSV, isCSV)
+		}
+	}
+}
+
+func TestClusterCSVRows(t *testing.T) {
+	capacity := uint64(100)
+	domainsQuota := uint64(80)
+	physicalUsage := uint64(3)
+	report := &limes.ClusterReport{
+		ID: "west",
+		Services: limes.ClusterServiceReports{
+			"shared": &limes.ClusterServiceReport{
+				ServiceInfo: limes.ServiceInfo{Type: "shared", Area: "storage"},
+				Resources: limes.ClusterResourceReports{
+					"things": &limes.ClusterResourceReport{
+						ResourceInfo: limes.ResourceInfo{Name: "things"},
+						Capacity:     &capacity,
+						Usage:        5,
+					},
+					"capacity": &limes.ClusterResourceReport{
+						ResourceInfo:  limes.ResourceInfo{Name: "capacity", Unit: limes.UnitMebibytes},
+						DomainsQuota:  &domainsQuota,
+						Usage:         4,
+						BurstUsage:    1,
+						PhysicalUsage: &physicalUsage,
+					},
+				},
+			},
+		},
+	}
+
+	//values with units are converted into the base unit
+	expected := [][]string{
+		{"west", "storage", "shared", "capacity", "B", "", "83886080", "4194304", "1048576", "3145728"},
+		{"west", "storage", "shared", "things", "", "100", "", "5", "0", ""},
+	}
+	actual := clusterCSVRows(report)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected rows %#v, got %#v", expected, actual)
+	}
+}
+
+func TestCSVListWriterFailure(t *testing.T) {
+	rowsFor := func(item interface{}) [][]string { return [][]string{{item.(string)}} }
+	req := httptest.NewRequest("GET", "/v1/domains/uuid-for-germany/projects?format=csv", nil)
+
+	//before the first item, errors are reported normally
+	rec := httptest.NewRecorder()
+	list := newCSVListWriter(rec, []string{"name"}, rowsFor)
+	list.Fail(req, errors.New("datacenter on fire"))
+	if rec.Code != 500 || rec.Body.String() != "datacenter on fire\n" {
+		t.Errorf("expected 500 with error message, got %d with %q", rec.Code, rec.Body.String())
+	}
+
+	//after the first item, the connection is aborted since an incomplete CSV
+	//document cannot be told apart from a complete one
+	rec = httptest.NewRecorder()
+	list = newCSVListWriter(rec, []string{"name"}, rowsFor)
+	err := list.Write("berlin", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() {
+		r := recover()
+		if r != http.ErrAbortHandler {
+			t.Errorf("expected panic with http.ErrAbortHandler, got %#v", r)
+		}
+		if rec.Code != 200 || rec.Body.String() != "name\nberlin\n" {
+			t.Errorf("expected incomplete 200 response, got %d with %q", rec.Code, rec.Body.String())
+		}
+	}()
+	list.Fail(req, errors.New("datacenter on fire"))
+	t.Error("expected Fail() to panic")
+}
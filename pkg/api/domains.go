@@ -29,6 +29,7 @@ import (
 	"github.com/sapcc/go-bits/sre"
 	"github.com/sapcc/limes"
 	"github.com/sapcc/limes/pkg/collector"
+	"github.com/sapcc/limes/pkg/datamodel"
 	"github.com/sapcc/limes/pkg/db"
 	"github.com/sapcc/limes/pkg/reports"
 	"github.com/sapcc/limes/pkg/util"
@@ -46,12 +47,39 @@ func (p *v1Provider) ListDomains(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	domains, err := reports.GetDomains(cluster, nil, db.DB, reports.ReadFilter(r))
-	if respondwith.ErrorText(w, err) {
+	filter := reports.ReadFilter(r, cluster)
+	opts, err := reports.ReadListOptions(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.ParentUUID != "" {
+		http.Error(w, "parent_id is not supported for domains", http.StatusBadRequest)
 		return
 	}
 
-	respondwith.JSON(w, 200, map[string]interface{}{"domains": domains})
+	isCSV, ok := readResponseFormat(w, r)
+	if !ok {
+		return
+	}
+	if respondIfNotModified(w, r, domainsETagScope(cluster.ID)) {
+		return
+	}
+
+	var list listWriter = newJSONListWriter(w, "domains")
+	if isCSV {
+		list = newCSVListWriter(w, domainCSVHeader, func(item interface{}) [][]string {
+			return domainCSVRows(item.(*limes.DomainReport))
+		})
+	}
+	nextCursor, err := reports.GetDomains(cluster, nil, db.DB, filter, opts, func(domain *limes.DomainReport, nextCursor string) error {
+		return list.Write(domain, nextCursor)
+	})
+	if err != nil {
+		list.Fail(r, err)
+		return
+	}
+	list.Finish(nextCursor)
 }
 
 //GetDomain handles GET /v1/domains/:domain_id.
@@ -70,10 +98,21 @@ func (p *v1Provider) GetDomain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	domain, err := GetDomainReport(cluster, *dbDomain, db.DB, reports.ReadFilter(r))
+	isCSV, ok := readResponseFormat(w, r)
+	if !ok {
+		return
+	}
+	if respondIfNotModified(w, r, domainETagScope(dbDomain.ID)) {
+		return
+	}
+	domain, err := GetDomainReport(cluster, *dbDomain, db.DB, reports.ReadFilter(r, cluster))
 	if respondwith.ErrorText(w, err) {
 		return
 	}
+	if isCSV {
+		respondWithCSV(w, domainCSVHeader, domainCSVRows(domain))
+		return
+	}
 	respondwith.JSON(w, 200, map[string]interface{}{"domain": domain})
 }
 
@@ -136,6 +175,7 @@ func (p *v1Provider) putOrSimulatePutDomain(w http.ResponseWriter, r *http.Reque
 	if updater.Cluster == nil {
 		return
 	}
+	updater.Settings = updater.Cluster.Settings()
 	updater.Domain = p.FindDomainFromRequest(w, r, updater.Cluster)
 	if updater.Domain == nil {
 		return
@@ -201,6 +241,7 @@ func (p *v1Provider) putOrSimulatePutDomain(w http.ResponseWriter, r *http.Reque
 			continue
 		}
 		isExistingResource := make(map[string]bool)
+		aggregateDeltas := make(datamodel.ClusterAggregateDeltas)
 
 		//check all existing resources
 		var resources []db.DomainResource
@@ -224,6 +265,9 @@ func (p *v1Provider) putOrSimulatePutDomain(w http.ResponseWriter, r *http.Reque
 			//would contain only identical pointers)
 			res := res
 
+			aggregateDeltas.Add(res.Name, datamodel.ClusterAggregateDelta{
+				DomainsQuota: int64(req.NewValue) - int64(res.Quota),
+			})
 			res.Quota = req.NewValue
 			resourcesToUpdate = append(resourcesToUpdate, &res)
 		}
@@ -242,6 +286,14 @@ func (p *v1Provider) putOrSimulatePutDomain(w http.ResponseWriter, r *http.Reque
 			if respondwith.ErrorText(w, err) {
 				return
 			}
+			aggregateDeltas.Add(resourceName, datamodel.ClusterAggregateDelta{
+				DomainsQuota: int64(req.NewValue),
+			})
+		}
+
+		err = datamodel.ApplyClusterAggregateDeltas(tx, updater.Cluster.ID, srv.Type, aggregateDeltas)
+		if respondwith.ErrorText(w, err) {
+			return
 		}
 	}
 
@@ -253,6 +305,11 @@ func (p *v1Provider) putOrSimulatePutDomain(w http.ResponseWriter, r *http.Reque
 	if respondwith.ErrorText(w, err) {
 		return
 	}
+	//this invalidates the ETags of reports for this domain (see etag.go)
+	_, err = tx.Exec(`UPDATE domains SET quota_changed_at = $1 WHERE id = $2`, requestTime, updater.Domain.ID)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
 	err = tx.Commit()
 	if respondwith.ErrorText(w, err) {
 		return
@@ -0,0 +1,150 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/limes/pkg/core"
+	"github.com/sapcc/limes/pkg/db"
+)
+
+//The ETag of a report is derived from everything that can change the report
+//without a restart of Limes: the scraping timestamps (every scrape updates
+//them, so they cover changes in usage, backend quota and rate usage), the
+//timestamps of the last quota change through the API, and the number of
+//domains, projects and project services (which covers discovery and deletion
+//of projects and domains). These values can be obtained from a single cheap
+//query instead of the expensive aggregations in package reports. Reports also
+//depend on the reloadable parts of the configuration (e.g. quota constraints
+//and resource behaviors), so the configuration generation is included as well.
+//
+//NOTE: CONCAT() is used instead of CONCAT_WS() because the latter skips NULL
+//values, which would make different inputs produce the same version string.
+var reportVersionQuery = db.SimplifyWhitespaceInSQL(`
+	SELECT CONCAT(MAX(d.quota_changed_at), ',', COUNT(DISTINCT d.id), ',',
+	              MAX(p.quota_changed_at), ',', COUNT(DISTINCT p.id), ',',
+	              COUNT(ps.id), ',', MAX(ps.scraped_at), ',', MAX(ps.rates_scraped_at))
+	  FROM domains d
+	  LEFT OUTER JOIN projects p ON p.domain_id = d.id
+	  LEFT OUTER JOIN project_services ps ON ps.project_id = p.id
+	 WHERE %s
+`)
+
+//Cluster reports additionally contain capacity data.
+var capacityVersionQuery = db.SimplifyWhitespaceInSQL(`
+	SELECT CONCAT(MAX(scraped_at), ',', COUNT(*)) FROM cluster_services
+`)
+
+type etagScope struct {
+	//restricts which rows of `domains d` and `projects p` are considered by
+	//reportVersionQuery (empty for cluster reports)
+	Fields map[string]interface{}
+	//whether capacity data is included in the report
+	WithCapacity bool
+}
+
+func clusterETagScope() etagScope {
+	//Cluster reports contain data from all clusters when services are shared,
+	//so we cannot restrict the scope to a single cluster.
+	return etagScope{WithCapacity: true}
+}
+
+func domainsETagScope(clusterID string) etagScope {
+	return etagScope{Fields: map[string]interface{}{"d.cluster_id": clusterID}}
+}
+
+func domainETagScope(domainID int64) etagScope {
+	return etagScope{Fields: map[string]interface{}{"d.id": domainID}}
+}
+
+func projectsETagScope(domainID int64) etagScope {
+	return etagScope{Fields: map[string]interface{}{"p.domain_id": domainID}}
+}
+
+func projectETagScope(projectID int64) etagScope {
+	return etagScope{Fields: map[string]interface{}{"p.id": projectID}}
+}
+
+//respondIfNotModified computes the ETag for a report within the given scope
+//and sets it on the response. If the request has an If-None-Match header
+//matching this ETag, a 304 response is generated and true is returned. The
+//caller shall then not generate the report.
+//
+//This must be called before the report is generated: If the data changes
+//while the report is generated, the report will be newer than its ETag, so
+//the next request will receive a full response instead of an incorrect 304.
+func respondIfNotModified(w http.ResponseWriter, r *http.Request, scope etagScope) bool {
+	etag, err := computeETag(r, scope)
+	if respondwith.ErrorText(w, err) {
+		return true
+	}
+
+	w.Header().Set("ETag", etag)
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+func computeETag(r *http.Request, scope etagScope) (string, error) {
+	whereStr, queryArgs := db.BuildSimpleWhereClause(scope.Fields, 0)
+	var version string
+	err := db.DB.QueryRow(fmt.Sprintf(reportVersionQuery, whereStr), queryArgs...).Scan(&version)
+	if err != nil {
+		return "", err
+	}
+
+	if scope.WithCapacity {
+		var capacityVersion string
+		err := db.DB.QueryRow(capacityVersionQuery).Scan(&capacityVersion)
+		if err != nil {
+			return "", err
+		}
+		version += ";" + capacityVersion
+	}
+
+	//the same data can be rendered in different ways depending on the request
+	//(filters, pagination, output format, target cluster)
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n%s\n%s\n%s\n",
+		version, core.ConfigurationDigest(), r.URL.Path, r.URL.RawQuery,
+		r.Header.Get("Accept"), r.Header.Get("X-Limes-Cluster-Id"),
+	)
+	return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`, nil
+}
+
+//etagMatches implements the comparison for If-None-Match as specified in
+//RFC 7232, section 3.2 (i.e. using weak comparison).
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package api
+
+import "testing"
+
+func TestETagMatches(t *testing.T) {
+	testCases := []struct {
+		IfNoneMatch string
+		Expected    bool
+	}{
+		{``, false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"abd"`, false},
+		{`abc`, false},
+		{`"foo", "abc"`, true},
+		{`"foo","bar"`, false},
+		{`*`, true},
+	}
+	for _, tc := range testCases {
+		actual := etagMatches(tc.IfNoneMatch, `"abc"`)
+		if actual != tc.Expected {
+			t.Errorf("expected etagMatches(%q) = %t, got %t", tc.IfNoneMatch, tc.Expected, actual)
+		}
+	}
+}
@@ -40,7 +40,7 @@ func (p *v1Provider) ListInconsistencies(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	inconsistencies, err := reports.GetInconsistencies(cluster, db.DB, reports.ReadFilter(r))
+	inconsistencies, err := reports.GetInconsistencies(cluster, db.DB, reports.ReadFilter(r, cluster))
 	if respondwith.ErrorText(w, err) {
 		return
 	}
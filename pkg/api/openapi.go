@@ -0,0 +1,44 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package api
+
+import (
+	//needed for go:embed
+	_ "embed"
+	"net/http"
+
+	"github.com/sapcc/go-bits/sre"
+)
+
+//openAPIDocument is the OpenAPI 3 description of the v1 API. It is
+//maintained by hand; openapi_test.go checks that it covers all routes and all
+//fields of the report types.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+//GetOpenAPIDocument handles GET /v1/openapi.json.
+func (p *v1Provider) GetOpenAPIDocument(w http.ResponseWriter, r *http.Request) {
+	sre.IdentifyEndpoint(r, "/v1/openapi.json")
+	//like the version advertisement, this does not require authentication
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(openAPIDocument)
+}
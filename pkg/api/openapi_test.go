@@ -0,0 +1,331 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+	"github.com/sapcc/limes/pkg/reports"
+)
+
+//This test does not need a database, so it does not use setupTest().
+
+type openAPISchema struct {
+	Ref        string                    `json:"$ref"`
+	Type       string                    `json:"type"`
+	Properties map[string]*openAPISchema `json:"properties"`
+	Items      *openAPISchema            `json:"items"`
+	AllOf      []*openAPISchema          `json:"allOf"`
+}
+
+type openAPIDocumentForTest struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+func parseOpenAPIDocument(t *testing.T) openAPIDocumentForTest {
+	t.Helper()
+	var doc openAPIDocumentForTest
+	err := json.Unmarshal(openAPIDocument, &doc)
+	if err != nil {
+		t.Fatal("openapi.json is not valid JSON: " + err.Error())
+	}
+	return doc
+}
+
+func TestOpenAPIDocumentIsServed(t *testing.T) {
+	router, _ := NewV1Router(&core.Cluster{Config: &core.ClusterConfiguration{}}, core.Configuration{})
+	req := httptest.NewRequest("GET", "/v1/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %q", ct)
+	}
+	if !reflect.DeepEqual(rec.Body.Bytes(), openAPIDocument) {
+		t.Error("response body does not match openapi.json")
+	}
+}
+
+func TestOpenAPIDocumentCoversAllRoutes(t *testing.T) {
+	doc := parseOpenAPIDocument(t)
+
+	p := &v1Provider{Cluster: &core.Cluster{Config: &core.ClusterConfiguration{}}}
+	routes := make(map[string]bool)
+	err := p.router().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			routes[strings.ToLower(method)+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	documented := make(map[string]bool)
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented[method+" "+path] = true
+		}
+	}
+
+	for _, route := range sortedKeys(routes) {
+		if !documented[route] {
+			t.Errorf("route %q is missing in openapi.json", route)
+		}
+	}
+	for _, route := range sortedKeys(documented) {
+		if !routes[route] {
+			t.Errorf("openapi.json documents route %q which does not exist", route)
+		}
+	}
+}
+
+func TestOpenAPIDocumentCoversAllReportFields(t *testing.T) {
+	doc := parseOpenAPIDocument(t)
+
+	//Each Go type that appears in an API response needs to have a schema of the
+	//same name in openapi.json.
+	typeToSchema := map[reflect.Type]string{
+		reflect.TypeOf(limes.ProjectReport{}):                  "ProjectReport",
+		reflect.TypeOf(limes.ProjectBurstingInfo{}):            "ProjectBurstingInfo",
+		reflect.TypeOf(limes.ProjectServiceReport{}):           "ProjectServiceReport",
+		reflect.TypeOf(limes.ProjectResourceReport{}):          "ProjectResourceReport",
+		reflect.TypeOf(limes.ProjectRateLimitReport{}):         "ProjectRateLimitReport",
+		reflect.TypeOf(limes.DomainReport{}):                   "DomainReport",
+		reflect.TypeOf(limes.DomainServiceReport{}):            "DomainServiceReport",
+		reflect.TypeOf(limes.DomainResourceReport{}):           "DomainResourceReport",
+		reflect.TypeOf(limes.ClusterReport{}):                  "ClusterReport",
+		reflect.TypeOf(limes.ClusterServiceReport{}):           "ClusterServiceReport",
+		reflect.TypeOf(limes.ClusterResourceReport{}):          "ClusterResourceReport",
+		reflect.TypeOf(limes.ClusterAvailabilityZoneReport{}):  "ClusterAvailabilityZoneReport",
+		reflect.TypeOf(limes.ClusterResourceBreakdownReport{}): "ClusterResourceBreakdownReport",
+		reflect.TypeOf(limes.ClusterRateLimitReport{}):         "ClusterRateLimitReport",
+		reflect.TypeOf(limes.ScalingBehavior{}):                "ScalingBehavior",
+		reflect.TypeOf(core.QuotaValidationError{}):            "QuotaValidationError",
+		reflect.TypeOf(reports.Inconsistencies{}):              "Inconsistencies",
+		reflect.TypeOf(reports.OvercommittedDomainQuota{}):     "OvercommittedDomainQuota",
+		reflect.TypeOf(reports.OverspentProjectQuota{}):        "OverspentProjectQuota",
+		reflect.TypeOf(reports.MismatchProjectQuota{}):         "MismatchProjectQuota",
+		reflect.TypeOf(reports.DomainData{}):                   "DomainData",
+		reflect.TypeOf(reports.ProjectData{}):                  "ProjectData",
+		reflect.TypeOf(VersionData{}):                          "VersionData",
+	}
+
+	for _, goType := range []reflect.Type{
+		reflect.TypeOf(limes.ProjectReport{}),
+		reflect.TypeOf(limes.DomainReport{}),
+		reflect.TypeOf(limes.ClusterReport{}),
+		reflect.TypeOf(core.QuotaValidationError{}),
+		reflect.TypeOf(reports.Inconsistencies{}),
+		reflect.TypeOf(VersionData{}),
+	} {
+		checkSchemaForType(t, doc, typeToSchema, goType, nil, map[reflect.Type]bool{})
+	}
+
+	//QuotaRequest has custom JSON (un)marshaling, so we check it by marshaling
+	//a fully populated instance instead
+	quotaRequest := limes.QuotaRequest{
+		"shared": limes.ServiceQuotaRequest{
+			Resources: limes.ResourceQuotaRequest{
+				"things": limes.ValueWithUnit{Value: 10, Unit: limes.UnitMebibytes},
+			},
+			Rates: map[string]limes.RateLimitRequest{
+				"service/shared/objects:create": {Limit: 5, Window: limes.WindowMinutes},
+			},
+		},
+	}
+	buf, err := json.Marshal(quotaRequest)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var data interface{}
+	err = json.Unmarshal(buf, &data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkSchemaForValue(t, doc, "QuotaRequest", data, doc.Components.Schemas["QuotaRequest"])
+}
+
+//checkSchemaForType checks that the schema for the given struct type has
+//exactly the same properties as the JSON serialization of the type.
+func checkSchemaForType(t *testing.T, doc openAPIDocumentForTest, typeToSchema map[reflect.Type]string, goType reflect.Type, schema *openAPISchema, checked map[reflect.Type]bool) {
+	t.Helper()
+	name := goType.String()
+	if schema == nil {
+		schemaName, exists := typeToSchema[goType]
+		if !exists {
+			t.Errorf("no schema known for type %s (please add it to openapi.json and this test)", name)
+			return
+		}
+		if checked[goType] {
+			return
+		}
+		checked[goType] = true
+		schema = doc.Components.Schemas[schemaName]
+		if schema == nil {
+			t.Errorf("schema %s for type %s is missing in openapi.json", schemaName, name)
+			return
+		}
+	}
+
+	fields := make(map[string]reflect.Type)
+	collectJSONFields(goType, fields)
+
+	for _, fieldName := range sortedKeys(fields) {
+		propSchema := resolveSchema(doc, schema.Properties[fieldName])
+		if propSchema == nil {
+			t.Errorf("field %q of type %s is missing in openapi.json", fieldName, name)
+			continue
+		}
+		checkSchemaForFieldType(t, doc, typeToSchema, fields[fieldName], propSchema, checked)
+	}
+	for propName := range schema.Properties {
+		if _, exists := fields[propName]; !exists {
+			t.Errorf("openapi.json documents field %q of type %s which does not exist", propName, name)
+		}
+	}
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+func checkSchemaForFieldType(t *testing.T, doc openAPIDocumentForTest, typeToSchema map[reflect.Type]string, goType reflect.Type, schema *openAPISchema, checked map[reflect.Type]bool) {
+	t.Helper()
+	for goType.Kind() == reflect.Ptr {
+		goType = goType.Elem()
+	}
+
+	switch goType.Kind() {
+	case reflect.Map:
+		//maps of structs that serialize into lists (e.g. ProjectServiceReports)
+		elemType := goType.Elem()
+		if goType.Implements(jsonMarshalerType) && elemType.Kind() == reflect.Ptr && elemType.Elem().Kind() == reflect.Struct {
+			if schema.Type != "array" || schema.Items == nil {
+				t.Errorf("expected array schema for type %s", goType.String())
+				return
+			}
+			checkSchemaForType(t, doc, typeToSchema, elemType.Elem(), nil, checked)
+		}
+	case reflect.Slice:
+		if schema.Type != "array" || schema.Items == nil {
+			t.Errorf("expected array schema for type %s", goType.String())
+			return
+		}
+		checkSchemaForFieldType(t, doc, typeToSchema, goType.Elem(), resolveSchema(doc, schema.Items), checked)
+	case reflect.Struct:
+		if _, exists := typeToSchema[goType]; exists {
+			checkSchemaForType(t, doc, typeToSchema, goType, nil, checked)
+		} else {
+			//anonymous structs are described inline
+			checkSchemaForType(t, doc, typeToSchema, goType, schema, checked)
+		}
+	}
+}
+
+//collectJSONFields finds all fields that appear in the JSON serialization of
+//the given struct type (including those of embedded structs).
+func collectJSONFields(goType reflect.Type, fields map[string]reflect.Type) {
+	for idx := 0; idx < goType.NumField(); idx++ {
+		field := goType.Field(idx)
+		tag := field.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			collectJSONFields(field.Type, fields)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+}
+
+//checkSchemaForValue checks that all object keys in the given unmarshaled
+//JSON value are documented in the schema.
+func checkSchemaForValue(t *testing.T, doc openAPIDocumentForTest, path string, value interface{}, schema *openAPISchema) {
+	t.Helper()
+	schema = resolveSchema(doc, schema)
+	if schema == nil {
+		t.Errorf("no schema found for %s", path)
+		return
+	}
+	switch value := value.(type) {
+	case []interface{}:
+		for _, item := range value {
+			checkSchemaForValue(t, doc, path+"[]", item, schema.Items)
+		}
+	case map[string]interface{}:
+		for key, item := range value {
+			propSchema := schema.Properties[key]
+			if propSchema == nil {
+				t.Errorf("field %s.%s is missing in openapi.json", path, key)
+				continue
+			}
+			checkSchemaForValue(t, doc, path+"."+key, item, propSchema)
+		}
+	}
+}
+
+func resolveSchema(doc openAPIDocumentForTest, schema *openAPISchema) *openAPISchema {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		return doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	if len(schema.AllOf) == 1 {
+		return resolveSchema(doc, schema.AllOf[0])
+	}
+	return schema
+}
+
+func sortedKeys(m interface{}) []string {
+	var result []string
+	for _, key := range reflect.ValueOf(m).MapKeys() {
+		result = append(result, key.String())
+	}
+	sort.Strings(result)
+	return result
+}
@@ -56,19 +56,37 @@ func (p *v1Provider) ListProjects(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//This endpoints can generate reports so large, we shouldn't be rendering
-	//more than one at the same time in order to keep our memory usage in check.
-	//(For example, a full project list with all resources for a domain with 2000
-	//projects runs as large as 160 MiB for the pure JSON.)
-	p.listProjectsMutex.Lock()
-	defer p.listProjectsMutex.Unlock()
-
-	projects, err := reports.GetProjects(cluster, *dbDomain, nil, db.DB, reports.ReadFilter(r))
-	if respondwith.ErrorText(w, err) {
+	filter := reports.ReadFilter(r, cluster)
+	opts, err := reports.ReadListOptions(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	isCSV, ok := readResponseFormat(w, r)
+	if !ok {
+		return
+	}
+	if respondIfNotModified(w, r, projectsETagScope(dbDomain.ID)) {
 		return
 	}
 
-	respondwith.JSON(w, 200, map[string]interface{}{"projects": projects})
+	//The reports are streamed into the response as they are generated, since
+	//this endpoint can generate reports so large that we cannot keep them in
+	//memory all at once.
+	var list listWriter = newJSONListWriter(w, "projects")
+	if isCSV {
+		list = newCSVListWriter(w, projectCSVHeader, func(item interface{}) [][]string {
+			return projectCSVRows(*dbDomain, item.(*limes.ProjectReport))
+		})
+	}
+	nextCursor, err := reports.GetProjects(cluster, *dbDomain, nil, db.DB, filter, opts, func(project *limes.ProjectReport, nextCursor string) error {
+		return list.Write(project, nextCursor)
+	})
+	if err != nil {
+		list.Fail(r, err)
+		return
+	}
+	list.Finish(nextCursor)
 }
 
 //GetProject handles GET /v1/domains/:domain_id/projects/:project_id.
@@ -90,10 +108,21 @@ func (p *v1Provider) GetProject(w http.ResponseWriter, r *http.Request) {
 	if dbProject == nil {
 		return
 	}
-	project, err := GetProjectReport(cluster, *dbDomain, *dbProject, db.DB, reports.ReadFilter(r))
+	isCSV, ok := readResponseFormat(w, r)
+	if !ok {
+		return
+	}
+	if respondIfNotModified(w, r, projectETagScope(dbProject.ID)) {
+		return
+	}
+	project, err := GetProjectReport(cluster, *dbDomain, *dbProject, db.DB, reports.ReadFilter(r, cluster))
 	if respondwith.ErrorText(w, err) {
 		return
 	}
+	if isCSV {
+		respondWithCSV(w, projectCSVHeader, projectCSVRows(*dbDomain, project))
+		return
+	}
 	respondwith.JSON(w, 200, map[string]interface{}{"project": project})
 }
 
@@ -243,6 +272,7 @@ func (p *v1Provider) putOrSimulatePutProjectQuotas(w http.ResponseWriter, r *htt
 	if updater.Cluster == nil {
 		return
 	}
+	updater.Settings = updater.Cluster.Settings()
 	updater.Domain = p.FindDomainFromRequest(w, r, updater.Cluster)
 	if updater.Domain == nil {
 		return
@@ -320,6 +350,7 @@ func (p *v1Provider) putOrSimulatePutProjectQuotas(w http.ResponseWriter, r *htt
 				return
 			}
 
+			aggregateDeltas := make(datamodel.ClusterAggregateDeltas)
 			for _, res := range resources {
 				req, exists := serviceRequests[res.Name]
 				if !exists {
@@ -333,10 +364,17 @@ func (p *v1Provider) putOrSimulatePutProjectQuotas(w http.ResponseWriter, r *htt
 				//we didn't take a copy manually, the resourcesToUpdate list would
 				//contain only identical pointers)
 				res := res
+				before := res
 
 				res.Quota = &req.NewValue
 				resourcesToUpdate = append(resourcesToUpdate, &res)
 				servicesToUpdate[srv.Type] = true
+				aggregateDeltas.Add(res.Name, datamodel.ProjectResourceDelta(&before, &res))
+			}
+
+			err = datamodel.ApplyClusterAggregateDeltas(tx, updater.Cluster.ID, srv.Type, aggregateDeltas)
+			if respondwith.ErrorText(w, err) {
+				return
 			}
 		}
 
@@ -388,6 +426,12 @@ func (p *v1Provider) putOrSimulatePutProjectQuotas(w http.ResponseWriter, r *htt
 		}
 	}
 
+	//this invalidates the ETags of reports for this project (see etag.go)
+	_, err = tx.Exec(`UPDATE projects SET quota_changed_at = $1 WHERE id = $2`, requestTime, updater.Project.ID)
+	if respondwith.ErrorText(w, err) {
+		return
+	}
+
 	err = tx.Commit()
 	if respondwith.ErrorText(w, err) {
 		return
@@ -532,7 +576,8 @@ func (p *v1Provider) putOrSimulateProjectAttributes(w http.ResponseWriter, r *ht
 
 	//update project
 	project.HasBursting = hasBursting
-	_, err := tx.Exec(`UPDATE projects SET has_bursting = $1 WHERE id = $2`, hasBursting, project.ID)
+	project.QuotaChangedAt = &requestTime
+	_, err := tx.Exec(`UPDATE projects SET has_bursting = $1, quota_changed_at = $2 WHERE id = $3`, hasBursting, requestTime, project.ID)
 	if respondwith.ErrorText(w, err) {
 		return
 	}
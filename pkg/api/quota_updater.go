@@ -39,10 +39,11 @@ import (
 //See func PutDomain and func PutProject for how it's used.
 type QuotaUpdater struct {
 	//scope
-	Config  core.Configuration
-	Cluster *core.Cluster
-	Domain  *db.Domain  //always set (for project quota updates, contains the project's domain)
-	Project *db.Project //nil for domain quota updates
+	Config   core.Configuration
+	Cluster  *core.Cluster
+	Settings *core.ClusterSettings //snapshot of Cluster.Settings() for the duration of this request
+	Domain   *db.Domain            //always set (for project quota updates, contains the project's domain)
+	Project  *db.Project           //nil for domain quota updates
 
 	//AuthZ info
 	CanRaise        func(serviceType string) bool
@@ -95,13 +96,13 @@ func (u QuotaUpdater) ScopeName() string {
 
 //QuotaConstraints returns the quota constraints that apply to this updater's scope.
 func (u QuotaUpdater) QuotaConstraints() core.QuotaConstraints {
-	if u.Cluster.QuotaConstraints == nil {
+	if u.Settings.QuotaConstraints == nil {
 		return nil
 	}
 	if u.Project == nil {
-		return u.Cluster.QuotaConstraints.Domains[u.Domain.Name]
+		return u.Settings.QuotaConstraints.Domains[u.Domain.Name]
 	}
-	return u.Cluster.QuotaConstraints.Projects[u.Domain.Name][u.Project.Name]
+	return u.Settings.QuotaConstraints.Projects[u.Domain.Name][u.Project.Name]
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -131,7 +132,8 @@ var getCapacityQuery = db.SimplifyWhitespaceInSQL(`
 //errors, not for validation errors.
 func (u *QuotaUpdater) ValidateInput(input limes.QuotaRequest, dbi db.Interface) error {
 	//gather reports on the cluster's capacity and domain's quotas to decide whether a quota update is legal
-	clusterReport, err := GetClusterReport(u.Config, u.Cluster, dbi, reports.Filter{})
+	//(the cluster report is only needed for its sums, so the cached version is good enough)
+	clusterReport, err := GetCachedClusterReport(u.Config, u.Cluster, dbi)
 	if err != nil {
 		return err
 	}
@@ -224,7 +226,7 @@ func (u *QuotaUpdater) ValidateInput(input limes.QuotaRequest, dbi db.Interface)
 						continue //with next resource
 					}
 					//value is valid and novel -> perform further validation
-					behavior := u.Cluster.BehaviorForResource(srv.Type, res.Name, u.ScopeName())
+					behavior := u.Settings.BehaviorForResource(srv.Type, res.Name, u.ScopeName())
 					req.ValidationError = u.validateQuota(srv, res, behavior, *clusterRes, *domRes, projRes, req.OldValue, req.NewValue)
 				}
 			}
@@ -346,11 +348,11 @@ func (u QuotaUpdater) validateQuota(srv limes.ServiceInfo, res limes.ResourceInf
 	//check authorization for quota change
 	var lprLimit uint64
 	if u.Project == nil {
-		limitSpec := u.Cluster.LowPrivilegeRaise.LimitsForDomains[srv.Type][res.Name]
+		limitSpec := u.Settings.LowPrivilegeRaise.LimitsForDomains[srv.Type][res.Name]
 		lprLimit = limitSpec.Evaluate(clusterRes, oldQuota)
 	} else {
-		if u.Cluster.Config.LowPrivilegeRaise.IsAllowedForProjectsIn(u.Domain.Name) {
-			limitSpec := u.Cluster.LowPrivilegeRaise.LimitsForProjects[srv.Type][res.Name]
+		if u.Settings.Config.LowPrivilegeRaise.IsAllowedForProjectsIn(u.Domain.Name) {
+			limitSpec := u.Settings.LowPrivilegeRaise.LimitsForProjects[srv.Type][res.Name]
 			lprLimit = limitSpec.Evaluate(clusterRes, oldQuota)
 		} else {
 			lprLimit = 0
@@ -0,0 +1,122 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sapcc/go-bits/logg"
+)
+
+const nextCursorKey = "next_cursor"
+
+//listWriter is implemented by jsonListWriter and csvListWriter.
+type listWriter interface {
+	//Write appends an item to the list. The response header is written before
+	//the first item, so the nextCursor must already be known at this point.
+	Write(item interface{}, nextCursor string) error
+	//Finish completes the response after the last item has been written.
+	Finish(nextCursor string)
+	//Fail handles an error that occurred while generating the items. If nothing
+	//has been written yet, a regular error response is generated. Otherwise,
+	//the response is left incomplete, so that the client can recognize the
+	//error.
+	Fail(r *http.Request, err error)
+}
+
+//jsonListWriter renders a response of the form `{"key":[item,...]}` (plus
+//the "next_cursor" field, if any) while the items are being generated. The
+//output is byte-for-byte identical to what respondwith.JSON() would produce
+//for the equivalent map[string]interface{}, but only one item needs to be
+//kept in memory at any time.
+type jsonListWriter struct {
+	w       http.ResponseWriter
+	key     string
+	started bool
+}
+
+func newJSONListWriter(w http.ResponseWriter, key string) *jsonListWriter {
+	return &jsonListWriter{w: w, key: key}
+}
+
+//Write implements the listWriter interface.
+func (j *jsonListWriter) Write(item interface{}, nextCursor string) error {
+	buf, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if j.started {
+		buf = append([]byte{','}, buf...)
+	} else {
+		j.writeHeader(nextCursor)
+	}
+	_, err = j.w.Write(buf)
+	return err
+}
+
+//Finish implements the listWriter interface.
+func (j *jsonListWriter) Finish(nextCursor string) {
+	if !j.started {
+		j.writeHeader(nextCursor)
+	}
+	buf := []byte{']'}
+	//encoding/json sorts map keys, so we have to do the same
+	if nextCursor != "" && j.key < nextCursorKey {
+		buf = append(buf, ',')
+		buf = appendJSONField(buf, nextCursorKey, nextCursor)
+	}
+	buf = append(buf, '}')
+	_, _ = j.w.Write(buf)
+}
+
+//Fail implements the listWriter interface. An incomplete response is not
+//valid JSON, so the client will recognize the error.
+func (j *jsonListWriter) Fail(r *http.Request, err error) {
+	if !j.started {
+		http.Error(j.w, err.Error(), 500)
+		return
+	}
+	logg.Error("aborting incomplete response for %s %s: %s", r.Method, r.URL.Path, err.Error())
+}
+
+func (j *jsonListWriter) writeHeader(nextCursor string) {
+	j.started = true
+	j.w.Header().Set("Content-Type", "application/json")
+	j.w.WriteHeader(200)
+
+	buf := []byte{'{'}
+	if nextCursor != "" && nextCursorKey < j.key {
+		buf = appendJSONField(buf, nextCursorKey, nextCursor)
+		buf = append(buf, ',')
+	}
+	keyBuf, _ := json.Marshal(j.key)
+	buf = append(buf, keyBuf...)
+	buf = append(buf, ':', '[')
+	_, _ = j.w.Write(buf)
+}
+
+func appendJSONField(buf []byte, key, value string) []byte {
+	keyBuf, _ := json.Marshal(key)
+	valueBuf, _ := json.Marshal(value)
+	buf = append(buf, keyBuf...)
+	buf = append(buf, ':')
+	return append(buf, valueBuf...)
+}
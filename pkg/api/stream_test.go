@@ -0,0 +1,106 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sapcc/go-bits/respondwith"
+	"github.com/sapcc/limes"
+)
+
+//This test does not need a database, so it does not use setupTest().
+func TestJSONListWriterMatchesRespondWithJSON(t *testing.T) {
+	usage := uint64(42)
+	items := []*limes.ProjectReport{
+		{UUID: "uuid-for-berlin", Name: "berlin <&>", Services: limes.ProjectServiceReports{}},
+		{UUID: "uuid-for-dresden", Name: "dresden", ParentUUID: "uuid-for-berlin", Services: limes.ProjectServiceReports{
+			"shared": &limes.ProjectServiceReport{
+				ServiceInfo: limes.ServiceInfo{Type: "shared", Area: "shared"},
+				Resources: limes.ProjectResourceReports{
+					"things": &limes.ProjectResourceReport{
+						ResourceInfo:  limes.ResourceInfo{Name: "things"},
+						Usage:         2,
+						PhysicalUsage: &usage,
+					},
+				},
+			},
+		}},
+	}
+
+	for _, key := range []string{"domains", "projects"} {
+		for _, count := range []int{0, 1, 2} {
+			for _, nextCursor := range []string{"", "abc"} {
+				expected := map[string]interface{}{key: items[:count]}
+				if nextCursor != "" {
+					expected["next_cursor"] = nextCursor
+				}
+				expectedRec := httptest.NewRecorder()
+				respondwith.JSON(expectedRec, 200, expected)
+
+				actualRec := httptest.NewRecorder()
+				list := newJSONListWriter(actualRec, key)
+				for _, item := range items[:count] {
+					err := list.Write(item, nextCursor)
+					if err != nil {
+						t.Fatal(err.Error())
+					}
+				}
+				list.Finish(nextCursor)
+
+				if actualRec.Code != expectedRec.Code {
+					t.Errorf("key = %q, count = %d, nextCursor = %q: expected status %d, got %d", key, count, nextCursor, expectedRec.Code, actualRec.Code)
+				}
+				if actualRec.Header().Get("Content-Type") != expectedRec.Header().Get("Content-Type") {
+					t.Errorf("key = %q, count = %d, nextCursor = %q: expected Content-Type %q, got %q", key, count, nextCursor, expectedRec.Header().Get("Content-Type"), actualRec.Header().Get("Content-Type"))
+				}
+				if actualRec.Body.String() != expectedRec.Body.String() {
+					t.Errorf("key = %q, count = %d, nextCursor = %q: response body does not match", key, count, nextCursor)
+					t.Logf("  expected = %s", expectedRec.Body.String())
+					t.Logf("    actual = %s", actualRec.Body.String())
+				}
+			}
+		}
+	}
+}
+
+func TestJSONListWriterFailure(t *testing.T) {
+	//before the first item, errors are reported normally
+	rec := httptest.NewRecorder()
+	list := newJSONListWriter(rec, "projects")
+	list.Fail(httptest.NewRequest("GET", "/v1/domains/uuid-for-germany/projects", nil), errors.New("datacenter on fire"))
+	if rec.Code != 500 || rec.Body.String() != "datacenter on fire\n" {
+		t.Errorf("expected 500 with error message, got %d with %q", rec.Code, rec.Body.String())
+	}
+
+	//after the first item, the response is left incomplete
+	rec = httptest.NewRecorder()
+	list = newJSONListWriter(rec, "projects")
+	err := list.Write(&limes.ProjectReport{UUID: "uuid-for-berlin"}, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	list.Fail(httptest.NewRequest("GET", "/v1/domains/uuid-for-germany/projects", nil), errors.New("datacenter on fire"))
+	if rec.Code != 200 || rec.Body.String() != `{"projects":[{"id":"uuid-for-berlin","name":"","parent_id":"","services":[]}` {
+		t.Errorf("expected incomplete 200 response, got %d with %q", rec.Code, rec.Body.String())
+	}
+}
@@ -22,6 +22,7 @@ package collector
 import (
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/limes/pkg/datamodel"
 	"github.com/sapcc/limes/pkg/db"
@@ -71,7 +72,7 @@ func (c *Collector) checkConsistencyCluster() {
 
 		if !c.Cluster.HasService(service.Type) || c.Cluster.IsServiceShared[service.Type] {
 			logg.Info("cleaning up %s service entry for domain %s", service.Type, c.Cluster.ID)
-			_, err := db.DB.Delete(&service)
+			err := c.deleteClusterService(service)
 			if err != nil {
 				c.LogError(err.Error())
 			}
@@ -116,11 +117,47 @@ func (c *Collector) checkConsistencyCluster() {
 	}
 
 	for _, domain := range domains {
-		c.checkConsistencyDomain(domain)
+		c.checkConsistencyDomain(domain, now)
 	}
+
+	//repair the cached cluster aggregates (no drift is expected here unless
+	//somebody bypassed the aggregate deltas)
+	drift, err := datamodel.CheckClusterAggregates(c.Cluster.ID)
+	if err != nil {
+		c.LogError(err.Error())
+		return
+	}
+	for _, msg := range drift {
+		logg.Info("repaired drift in cluster aggregates for cluster %s: %s", c.Cluster.ID, msg)
+	}
+	clusterAggregateDriftCounter.With(prometheus.Labels{"os_cluster": c.Cluster.ID}).Add(float64(len(drift)))
+}
+
+func (c *Collector) deleteClusterService(service db.ClusterService) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer db.RollbackUnlessCommitted(tx)
+
+	//when the service is not configured anymore, its domain and project
+	//services are removed by checkConsistencyDomain() below, so the cached
+	//aggregates for this service type can go right away (when the service has
+	//merely become shared, the aggregates remain valid)
+	if !c.Cluster.HasService(service.Type) {
+		err = datamodel.DeleteClusterAggregatesForService(tx, c.Cluster.ID, service.Type)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Delete(&service)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (c *Collector) checkConsistencyDomain(domain db.Domain) {
+func (c *Collector) checkConsistencyDomain(domain db.Domain, now time.Time) {
 	tx, err := db.DB.Begin()
 	if err != nil {
 		c.LogError(err.Error())
@@ -129,7 +166,7 @@ func (c *Collector) checkConsistencyDomain(domain db.Domain) {
 	defer db.RollbackUnlessCommitted(tx)
 
 	//validate domain_services entries
-	_, err = datamodel.ValidateDomainServices(tx, c.Cluster, domain)
+	_, err = datamodel.ValidateDomainServices(tx, c.Cluster, domain, now)
 	if err == nil {
 		err = tx.Commit()
 	}
@@ -147,14 +184,14 @@ func (c *Collector) checkConsistencyDomain(domain db.Domain) {
 	}
 
 	for _, project := range projects {
-		err := c.checkConsistencyProject(project, domain)
+		err := c.checkConsistencyProject(project, domain, now)
 		if err != nil {
 			c.LogError(err.Error())
 		}
 	}
 }
 
-func (c *Collector) checkConsistencyProject(project db.Project, domain db.Domain) error {
+func (c *Collector) checkConsistencyProject(project db.Project, domain db.Domain, now time.Time) error {
 	tx, err := db.DB.Begin()
 	if err != nil {
 		return err
@@ -162,7 +199,7 @@ func (c *Collector) checkConsistencyProject(project db.Project, domain db.Domain
 	defer db.RollbackUnlessCommitted(tx)
 
 	//validate project_services entries
-	_, err = datamodel.ValidateProjectServices(tx, c.Cluster, domain, project)
+	_, err = datamodel.ValidateProjectServices(tx, c.Cluster, domain, project, now)
 	if err == nil {
 		err = tx.Commit()
 	}
@@ -20,10 +20,15 @@
 package collector
 
 import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/sapcc/limes/pkg/core"
+	"github.com/sapcc/limes/pkg/datamodel"
 	"github.com/sapcc/limes/pkg/db"
 	"github.com/sapcc/limes/pkg/test"
 )
@@ -53,32 +58,35 @@ func Test_Consistency(t *testing.T) {
 	test.AssertDBContent(t, "fixtures/checkconsistency0.sql")
 
 	//add some quota constraints
-	cluster.QuotaConstraints = &core.QuotaConstraintSet{
-		Domains: map[string]core.QuotaConstraints{
-			"germany": {
-				"unshared": {
-					"capacity": {Minimum: p2u64(10)},
-				},
-				"shared": {
-					"capacity": {Maximum: p2u64(100)},
-				},
-			},
-		},
-		Projects: map[string]map[string]core.QuotaConstraints{
-			"germany": {
-				"berlin": {
+	cluster.SetSettings(core.ClusterSettings{
+		Config: cluster.Config,
+		QuotaConstraints: &core.QuotaConstraintSet{
+			Domains: map[string]core.QuotaConstraints{
+				"germany": {
 					"unshared": {
-						"capacity": {Maximum: p2u64(10)},
+						"capacity": {Minimum: p2u64(10)},
 					},
-				},
-				"dresden": {
 					"shared": {
-						"capacity": {Minimum: p2u64(10)},
+						"capacity": {Maximum: p2u64(100)},
+					},
+				},
+			},
+			Projects: map[string]map[string]core.QuotaConstraints{
+				"germany": {
+					"berlin": {
+						"unshared": {
+							"capacity": {Maximum: p2u64(10)},
+						},
+					},
+					"dresden": {
+						"shared": {
+							"capacity": {Minimum: p2u64(10)},
+						},
 					},
 				},
 			},
 		},
-	}
+	})
 
 	//remove some *_services entries
 	_, err = db.DB.Exec(`DELETE FROM cluster_services WHERE type = $1`, "shared")
@@ -128,7 +136,7 @@ func Test_Consistency(t *testing.T) {
 		t.Error(err)
 	}
 
-	//add a domain_resource that contradicts the cluster.QuotaConstraints; this
+	//add a domain_resource that contradicts the cluster's QuotaConstraints; this
 	//should be fixed by CheckConsistency()
 	err = db.DB.Insert(&db.DomainResource{
 		ServiceID: 2,
@@ -138,7 +146,7 @@ func Test_Consistency(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	//add a project_resource that contradicts the cluster.QuotaConstraints; this
+	//add a project_resource that contradicts the cluster's QuotaConstraints; this
 	//should cause CheckConsistency() to mark the corresponding project_service
 	//as stale (to prompt the scraper to take care of the problem)
 	err = db.DB.Insert(&db.ProjectResource{
@@ -163,3 +171,225 @@ func Test_Consistency(t *testing.T) {
 	c.CheckConsistency()
 	test.AssertDBContent(t, "fixtures/checkconsistency2.sql")
 }
+
+func Test_ClusterAggregates(t *testing.T) {
+	plugin := test.NewPlugin("unittest")
+	cluster := prepareScrapeTest(t, 2, plugin)
+	c := Collector{
+		Cluster:  cluster,
+		Plugin:   plugin,
+		LogError: t.Errorf,
+		TimeNow:  test.TimeNow,
+		Once:     true,
+	}
+	c.Scrape()
+	c.Scrape() //twice because there are two projects
+
+	//CheckClusterAggregates() shall not do anything before the cache was filled
+	expectClusterAggregateDrift(t, cluster.ID, nil)
+	err := datamodel.FillClusterAggregates([]string{cluster.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClusterAggregateDrift(t, cluster.ID, nil)
+
+	//changes in usage are applied incrementally by Scrape()
+	plugin.StaticResourceData["things"].Usage = 5
+	setProjectServicesStale(t)
+	c.Scrape()
+	c.Scrape() //twice because there are two projects
+	expectClusterAggregateDrift(t, cluster.ID, nil)
+
+	//changes that bypass ApplyClusterAggregateDeltas() are detected and repaired
+	_, err = db.DB.Exec(`UPDATE project_resources SET quota = $1 WHERE name = $2`, 13, "things")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClusterAggregateDrift(t, cluster.ID, []string{"unittest/things"})
+	expectClusterAggregateDrift(t, cluster.ID, nil)
+
+	//resources without quota are counted separately, so that the cached
+	//projects quota can be reported as NULL like in the live report
+	_, err = db.DB.Exec(`UPDATE project_resources SET quota = NULL WHERE name = $1`, "things")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClusterAggregateDrift(t, cluster.ID, []string{"unittest/things"})
+	count, err := db.DB.SelectInt(`SELECT projects_with_quota FROM cluster_aggregates WHERE cluster_id = $1 AND resource_name = $2`, cluster.ID, "things")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected projects_with_quota = 0 for things, but got %d", count)
+	}
+
+	//deletions subtract the deleted records from the aggregates beforehand
+	var domain db.Domain
+	err = db.DB.SelectOne(&domain, `SELECT * FROM domains WHERE cluster_id = $1 ORDER BY id LIMIT 1`, cluster.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var project db.Project
+	err = db.DB.SelectOne(&project, `SELECT * FROM projects WHERE domain_id = $1 ORDER BY id LIMIT 1`, domain.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteProject(&domain, &project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClusterAggregateDrift(t, cluster.ID, nil)
+	err = deleteDomain(&domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClusterAggregateDrift(t, cluster.ID, nil)
+}
+
+func Test_ConsistencyResourceMoves(t *testing.T) {
+	test.ResetTime()
+	cluster := keystoneTestCluster(t)
+	c := Collector{
+		Cluster:  cluster,
+		Plugin:   nil,
+		LogError: t.Errorf,
+		TimeNow:  test.TimeNow,
+		Once:     true,
+	}
+	_, err := ScanDomains(cluster, ScanDomainsOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.CheckConsistency()
+	test.AssertDBContent(t, "fixtures/checkconsistency0.sql")
+
+	//pretend that the "things" resource has moved from "shared" into "unshared"
+	defer func(moves []core.ResourceMove) {
+		core.ResourceMoves = moves
+	}(core.ResourceMoves)
+	core.ResourceMoves = []core.ResourceMove{{
+		FromServiceType: "shared",
+		ToServiceType:   "unshared",
+		ResourceNames:   []string{"things"},
+	}}
+
+	//domain germany: not moved yet
+	//domain france: an empty record already exists in the new service
+	//project berlin: a non-empty record already exists in the new service
+	//project dresden: not moved yet
+	records := []interface{}{
+		&db.DomainResource{ServiceID: 2, Name: "things", Quota: 10},
+		&db.DomainResource{ServiceID: 4, Name: "things", Quota: 20},
+		&db.DomainResource{ServiceID: 3, Name: "things", Quota: 0},
+		&db.ProjectResource{ServiceID: 2, Name: "things", Quota: p2u64(5), Usage: 2, BackendQuota: p2i64(5), DesiredBackendQuota: p2u64(5)},
+		&db.ProjectResource{ServiceID: 1, Name: "things", Quota: p2u64(7), Usage: 2, BackendQuota: p2i64(7), DesiredBackendQuota: p2u64(7)},
+		&db.ProjectResource{ServiceID: 4, Name: "things", Quota: p2u64(8), Usage: 3, BackendQuota: p2i64(8), DesiredBackendQuota: p2u64(8)},
+	}
+	for _, record := range records {
+		err := db.DB.Insert(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = datamodel.FillClusterAggregates([]string{cluster.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//run the consistency check twice to check that the move is idempotent
+	for pass := 1; pass <= 2; pass++ {
+		now := c.TimeNow()
+		var domains []db.Domain
+		_, err = db.DB.Select(&domains, `SELECT * FROM domains ORDER BY id`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, domain := range domains {
+			c.checkConsistencyDomain(domain, now)
+		}
+		expectClusterAggregateDrift(t, cluster.ID, nil)
+
+		expectThingsQuotas(t, "domain", []string{
+			"1/unshared=10",
+			"2/unshared=20",
+		})
+		expectThingsQuotas(t, "project", []string{
+			"1/shared=5",
+			"1/unshared=7",
+			"2/unshared=8",
+		})
+		//quota_changed_at is only set by the first pass
+		expectQuotaChangedAt(t, "domain", []string{"1=1", "2=1"})
+		expectQuotaChangedAt(t, "project", []string{"1=NULL", "2=1", "3=NULL"})
+	}
+}
+
+//expectThingsQuotas checks the location and quota of all domain_resources or
+//project_resources records for the "things" resource. Each entry in
+//`expected` looks like "$scope_id/$service_type=$quota".
+func expectThingsQuotas(t *testing.T, scope string, expected []string) {
+	t.Helper()
+	query := fmt.Sprintf(`
+		SELECT s.%[1]s_id, s.type, r.quota FROM %[1]s_resources r JOIN %[1]s_services s ON s.id = r.service_id
+		 WHERE r.name = 'things' ORDER BY s.%[1]s_id, s.type`, scope)
+	var actual []string
+	err := db.ForeachRow(db.DB, query, nil, func(rows *sql.Rows) error {
+		var (
+			scopeID     int64
+			serviceType string
+			quota       uint64
+		)
+		err := rows.Scan(&scopeID, &serviceType, &quota)
+		actual = append(actual, fmt.Sprintf("%d/%s=%d", scopeID, serviceType, quota))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %s quotas %v, but got %v", scope, expected, actual)
+	}
+}
+
+//expectQuotaChangedAt checks the quota_changed_at of all domains or projects
+//(as UNIX timestamps). Each entry in `expected` looks like "$id=$timestamp".
+func expectQuotaChangedAt(t *testing.T, scope string, expected []string) {
+	t.Helper()
+	query := fmt.Sprintf(`SELECT id, quota_changed_at FROM %ss ORDER BY id`, scope)
+	var actual []string
+	err := db.ForeachRow(db.DB, query, nil, func(rows *sql.Rows) error {
+		var (
+			id             int64
+			quotaChangedAt *time.Time
+		)
+		err := rows.Scan(&id, &quotaChangedAt)
+		if quotaChangedAt == nil {
+			actual = append(actual, fmt.Sprintf("%d=NULL", id))
+		} else {
+			actual = append(actual, fmt.Sprintf("%d=%d", id, quotaChangedAt.Unix()))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %s quota_changed_at %v, but got %v", scope, expected, actual)
+	}
+}
+
+func expectClusterAggregateDrift(t *testing.T, clusterID string, expectedKeys []string) {
+	t.Helper()
+	drift, err := datamodel.CheckClusterAggregates(clusterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != len(expectedKeys) {
+		t.Fatalf("expected drift in %v, but got %#v", expectedKeys, drift)
+	}
+	for idx, key := range expectedKeys {
+		if !strings.HasPrefix(drift[idx], key+": ") {
+			t.Errorf("expected drift in %s, but got %q", key, drift[idx])
+		}
+	}
+}
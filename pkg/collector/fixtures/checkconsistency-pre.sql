@@ -3,8 +3,8 @@ INSERT INTO domain_services (id, domain_id, type) VALUES (2, 1, 'shared');
 INSERT INTO domain_services (id, domain_id, type) VALUES (3, 2, 'unshared');
 INSERT INTO domain_services (id, domain_id, type) VALUES (4, 2, 'shared');
 
-INSERT INTO domains (id, cluster_id, name, uuid) VALUES (1, 'west', 'germany', 'uuid-for-germany');
-INSERT INTO domains (id, cluster_id, name, uuid) VALUES (2, 'west', 'france', 'uuid-for-france');
+INSERT INTO domains (id, cluster_id, name, uuid, quota_changed_at) VALUES (1, 'west', 'germany', 'uuid-for-germany', NULL);
+INSERT INTO domains (id, cluster_id, name, uuid, quota_changed_at) VALUES (2, 'west', 'france', 'uuid-for-france', NULL);
 
 INSERT INTO project_services (id, project_id, type, scraped_at, stale, scrape_duration_secs, rates_scraped_at, rates_stale, rates_scrape_duration_secs, rates_scrape_state, serialized_metrics) VALUES (1, 1, 'unshared', NULL, FALSE, 0, NULL, FALSE, 0, '', '');
 INSERT INTO project_services (id, project_id, type, scraped_at, stale, scrape_duration_secs, rates_scraped_at, rates_stale, rates_scrape_duration_secs, rates_scrape_state, serialized_metrics) VALUES (2, 1, 'shared', NULL, FALSE, 0, NULL, FALSE, 0, '', '');
@@ -13,6 +13,6 @@ INSERT INTO project_services (id, project_id, type, scraped_at, stale, scrape_du
 INSERT INTO project_services (id, project_id, type, scraped_at, stale, scrape_duration_secs, rates_scraped_at, rates_stale, rates_scrape_duration_secs, rates_scrape_state, serialized_metrics) VALUES (5, 3, 'unshared', NULL, FALSE, 0, NULL, FALSE, 0, '', '');
 INSERT INTO project_services (id, project_id, type, scraped_at, stale, scrape_duration_secs, rates_scraped_at, rates_stale, rates_scrape_duration_secs, rates_scrape_state, serialized_metrics) VALUES (6, 3, 'shared', NULL, FALSE, 0, NULL, FALSE, 0, '', '');
 
-INSERT INTO projects (id, domain_id, name, uuid, parent_uuid, has_bursting) VALUES (1, 1, 'berlin', 'uuid-for-berlin', 'uuid-for-germany', FALSE);
-INSERT INTO projects (id, domain_id, name, uuid, parent_uuid, has_bursting) VALUES (2, 1, 'dresden', 'uuid-for-dresden', 'uuid-for-berlin', FALSE);
-INSERT INTO projects (id, domain_id, name, uuid, parent_uuid, has_bursting) VALUES (3, 2, 'paris', 'uuid-for-paris', 'uuid-for-france', FALSE);
+INSERT INTO projects (id, domain_id, name, uuid, parent_uuid, has_bursting, quota_changed_at) VALUES (1, 1, 'berlin', 'uuid-for-berlin', 'uuid-for-germany', FALSE, NULL);
+INSERT INTO projects (id, domain_id, name, uuid, parent_uuid, has_bursting, quota_changed_at) VALUES (2, 1, 'dresden', 'uuid-for-dresden', 'uuid-for-berlin', FALSE, NULL);
+INSERT INTO projects (id, domain_id, name, uuid, parent_uuid, has_bursting, quota_changed_at) VALUES (3, 2, 'paris', 'uuid-for-paris', 'uuid-for-france', FALSE, NULL);
@@ -1,6 +1,6 @@
 INSERT INTO domain_services (id, domain_id, type) VALUES (1, 1, 'unittest');
 
-INSERT INTO domains (id, cluster_id, name, uuid) VALUES (1, 'west', 'germany', 'uuid-for-germany');
+INSERT INTO domains (id, cluster_id, name, uuid, quota_changed_at) VALUES (1, 'west', 'germany', 'uuid-for-germany', NULL);
 
 INSERT INTO project_rates (service_id, name, rate_limit, window_ns, usage_as_bigint) VALUES (1, 'otherrate', 42, 120000000000, '');
 INSERT INTO project_rates (service_id, name, rate_limit, window_ns, usage_as_bigint) VALUES (1, 'secondrate', 10, 1000000000, '');
@@ -8,5 +8,5 @@ INSERT INTO project_rates (service_id, name, rate_limit, window_ns, usage_as_big
 INSERT INTO project_services (id, project_id, type, scraped_at, stale, scrape_duration_secs, rates_scraped_at, rates_stale, rates_scrape_duration_secs, rates_scrape_state, serialized_metrics) VALUES (1, 1, 'unittest', NULL, FALSE, 0, NULL, FALSE, 0, '', '');
 INSERT INTO project_services (id, project_id, type, scraped_at, stale, scrape_duration_secs, rates_scraped_at, rates_stale, rates_scrape_duration_secs, rates_scrape_state, serialized_metrics) VALUES (2, 2, 'unittest', NULL, FALSE, 0, NULL, FALSE, 0, '', '');
 
-INSERT INTO projects (id, domain_id, name, uuid, parent_uuid, has_bursting) VALUES (1, 1, 'berlin', 'uuid-for-berlin', 'uuid-for-germany', FALSE);
-INSERT INTO projects (id, domain_id, name, uuid, parent_uuid, has_bursting) VALUES (2, 1, 'dresden', 'uuid-for-dresden', 'uuid-for-berlin', TRUE);
+INSERT INTO projects (id, domain_id, name, uuid, parent_uuid, has_bursting, quota_changed_at) VALUES (1, 1, 'berlin', 'uuid-for-berlin', 'uuid-for-germany', FALSE, NULL);
+INSERT INTO projects (id, domain_id, name, uuid, parent_uuid, has_bursting, quota_changed_at) VALUES (2, 1, 'dresden', 'uuid-for-dresden', 'uuid-for-berlin', TRUE, NULL);
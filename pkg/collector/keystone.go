@@ -20,6 +20,8 @@
 package collector
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/limes/pkg/core"
@@ -102,7 +104,7 @@ func ScanDomains(cluster *core.Cluster, opts ScanDomainsOpts) (result []string,
 	for _, dbDomain := range dbDomains {
 		if !isDomainUUID[dbDomain.UUID] {
 			logg.Info("removing deleted Keystone domain from our database: %s", dbDomain.Name)
-			_, err := db.DB.Delete(dbDomain)
+			err := deleteDomain(dbDomain)
 			if err != nil {
 				return nil, err
 			}
@@ -159,6 +161,25 @@ func ScanDomains(cluster *core.Cluster, opts ScanDomainsOpts) (result []string,
 	return result, nil
 }
 
+func deleteDomain(dbDomain *db.Domain) error {
+	//do this in a transaction to keep the cluster aggregates consistent
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer db.RollbackUnlessCommitted(tx)
+
+	err = datamodel.SubtractDomainFromClusterAggregates(tx, *dbDomain)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Delete(dbDomain)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func initDomain(cluster *core.Cluster, domain core.KeystoneDomain) (*db.Domain, error) {
 	//do this in a transaction to avoid half-initialized domains
 	tx, err := db.DB.Begin()
@@ -178,7 +199,7 @@ func initDomain(cluster *core.Cluster, domain core.KeystoneDomain) (*db.Domain,
 		return nil, err
 	}
 
-	_, err = datamodel.ValidateDomainServices(tx, cluster, *dbDomain)
+	_, err = datamodel.ValidateDomainServices(tx, cluster, *dbDomain, time.Now())
 	if err != nil {
 		return nil, err
 	}
@@ -228,7 +249,7 @@ func ScanProjects(cluster *core.Cluster, domain *db.Domain) (result []string, re
 	for _, dbProject := range dbProjects {
 		if !isProjectUUID[dbProject.UUID] {
 			logg.Info("removing deleted Keystone project from our database: %s/%s", domain.Name, dbProject.Name)
-			_, err := db.DB.Delete(dbProject)
+			err := deleteProject(domain, dbProject)
 			if err != nil {
 				return nil, err
 			}
@@ -278,6 +299,25 @@ func ScanProjects(cluster *core.Cluster, domain *db.Domain) (result []string, re
 
 //Initialize all the database records for a project (in both `projects` and
 //`project_services`).
+func deleteProject(domain *db.Domain, dbProject *db.Project) error {
+	//do this in a transaction to keep the cluster aggregates consistent
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer db.RollbackUnlessCommitted(tx)
+
+	err = datamodel.SubtractProjectFromClusterAggregates(tx, *domain, *dbProject)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Delete(dbProject)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func initProject(cluster *core.Cluster, domain *db.Domain, project core.KeystoneProject) error {
 	//do this in a transaction to avoid half-initialized projects
 	tx, err := db.DB.Begin()
@@ -299,7 +339,7 @@ func initProject(cluster *core.Cluster, domain *db.Domain, project core.Keystone
 	}
 
 	//add records to `project_services` table
-	_, err = datamodel.ValidateProjectServices(tx, cluster, *domain, *dbProject)
+	_, err = datamodel.ValidateProjectServices(tx, cluster, *domain, *dbProject, time.Now())
 	if err != nil {
 		return err
 	}
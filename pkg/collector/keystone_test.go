@@ -59,28 +59,31 @@ func Test_ScanDomains(t *testing.T) {
 
 	//add a quota constraint set; we're going to test if it's applied correctly
 	pointerTo := func(x uint64) *uint64 { return &x }
-	cluster.QuotaConstraints = &core.QuotaConstraintSet{
-		Domains: map[string]core.QuotaConstraints{
-			"germany": {
-				"unshared": {
-					"things":   {Minimum: pointerTo(10)},
-					"capacity": {Minimum: pointerTo(20)},
-				},
-			},
-		},
-		Projects: map[string]map[string]core.QuotaConstraints{
-			"germany": {
-				"berlin": {
+	cluster.SetSettings(core.ClusterSettings{
+		Config: cluster.Config,
+		QuotaConstraints: &core.QuotaConstraintSet{
+			Domains: map[string]core.QuotaConstraints{
+				"germany": {
 					"unshared": {
-						"things": {Minimum: pointerTo(5)},
+						"things":   {Minimum: pointerTo(10)},
+						"capacity": {Minimum: pointerTo(20)},
 					},
-					"shared": {
-						"capacity": {Minimum: pointerTo(10)},
+				},
+			},
+			Projects: map[string]map[string]core.QuotaConstraints{
+				"germany": {
+					"berlin": {
+						"unshared": {
+							"things": {Minimum: pointerTo(5)},
+						},
+						"shared": {
+							"capacity": {Minimum: pointerTo(10)},
+						},
 					},
 				},
 			},
 		},
-	}
+	})
 
 	//first ScanDomains should discover the StaticDomains in the cluster,
 	//and initialize domains, projects and project_services (project_resources
@@ -131,6 +131,14 @@ var ratesScrapeSuspendedCounter = prometheus.NewCounterVec(
 	[]string{"os_cluster", "service", "service_name"},
 )
 
+var clusterAggregateDriftCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "limes_cluster_aggregate_drifts",
+		Help: "Counter for records in the cluster aggregate cache that were repaired by the consistency check.",
+	},
+	[]string{"os_cluster"},
+)
+
 func init() {
 	prometheus.MustRegister(scrapeSuccessCounter)
 	prometheus.MustRegister(scrapeFailedCounter)
@@ -144,6 +152,7 @@ func init() {
 	prometheus.MustRegister(ratesScrapeSuccessCounter)
 	prometheus.MustRegister(ratesScrapeFailedCounter)
 	prometheus.MustRegister(ratesScrapeSuspendedCounter)
+	prometheus.MustRegister(clusterAggregateDriftCounter)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -633,6 +642,7 @@ func (c *DataMetricsCollector) Collect(ch chan<- prometheus.Metric) {
 	unitConversionDesc := <-descCh
 
 	//fetch values for cluster level
+	settings := c.Cluster.Settings()
 	capacityReported := make(map[string]map[string]bool)
 	queryArgs := []interface{}{c.Cluster.ID}
 	err := db.ForeachRow(db.DB, clusterMetricsQuery, queryArgs, func(rows *sql.Rows) error {
@@ -657,7 +667,7 @@ func (c *DataMetricsCollector) Collect(ch chan<- prometheus.Metric) {
 			sharedString = "false"
 		}
 
-		behavior := c.Cluster.BehaviorForResource(serviceType, resourceName, "")
+		behavior := settings.BehaviorForResource(serviceType, resourceName, "")
 		overcommitFactor := float64(behavior.OvercommitFactor)
 		if overcommitFactor == 0 {
 			overcommitFactor = 1
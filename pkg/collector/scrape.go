@@ -140,7 +140,7 @@ func (c *Collector) Scrape() {
 		}
 
 		scrapeEndedAt := c.TimeNow()
-		err = c.writeScrapeResult(domain, projectName, projectUUID, projectID, projectHasBursting, serviceType, serviceID, resourceData, serializedMetrics, scrapeEndedAt, scrapeEndedAt.Sub(scrapeStartedAt))
+		err = c.writeScrapeResult(domain, projectName, projectUUID, projectID, projectHasBursting, serviceType, serviceID, serviceScrapedAt == nil, resourceData, serializedMetrics, scrapeEndedAt, scrapeEndedAt.Sub(scrapeStartedAt))
 		if err != nil {
 			c.LogError("write %s backend data for %s/%s failed: %s", serviceType, domainName, projectName, err.Error())
 			scrapeFailedCounter.With(labels).Inc()
@@ -161,20 +161,22 @@ func (c *Collector) Scrape() {
 	}
 }
 
-func (c *Collector) writeScrapeResult(domain core.KeystoneDomain, projectName, projectUUID string, projectID int64, projectHasBursting bool, serviceType string, serviceID int64, resourceData map[string]core.ResourceData, serializedMetrics string, scrapedAt time.Time, scrapeDuration time.Duration) error {
+func (c *Collector) writeScrapeResult(domain core.KeystoneDomain, projectName, projectUUID string, projectID int64, projectHasBursting bool, serviceType string, serviceID int64, isFirstScrape bool, resourceData map[string]core.ResourceData, serializedMetrics string, scrapedAt time.Time, scrapeDuration time.Duration) error {
 	tx, err := db.DB.Begin()
 	if err != nil {
 		return err
 	}
 	defer db.RollbackUnlessCommitted(tx)
 
+	settings := c.Cluster.Settings()
 	var serviceConstraints map[string]core.QuotaConstraint
-	if c.Cluster.QuotaConstraints != nil {
-		serviceConstraints = c.Cluster.QuotaConstraints.Projects[domain.Name][projectName][serviceType]
+	if settings.QuotaConstraints != nil {
+		serviceConstraints = settings.QuotaConstraints.Projects[domain.Name][projectName][serviceType]
 	}
 
 	//update existing project_resources entries
 	resourceExists := make(map[string]bool)
+	aggregateDeltas := make(datamodel.ClusterAggregateDeltas)
 	var resources []db.ProjectResource
 	_, err = tx.Select(&resources, `SELECT * FROM project_resources WHERE service_id = $1`, serviceID)
 	if err != nil {
@@ -182,6 +184,7 @@ func (c *Collector) writeScrapeResult(domain core.KeystoneDomain, projectName, p
 	}
 	for _, res := range resources {
 		resourceExists[res.Name] = true
+		before := res
 
 		data, exists := resourceData[res.Name]
 		if !exists {
@@ -226,6 +229,10 @@ func (c *Collector) writeScrapeResult(domain core.KeystoneDomain, projectName, p
 				}
 				res.DesiredBackendQuota = res.Quota
 			}
+			if data.QuotaOnlyInLimes && res.DesiredBackendQuota != nil {
+				backendQuota := int64(*res.DesiredBackendQuota)
+				res.BackendQuota = &backendQuota
+			}
 		}
 
 		if len(data.Subresources) == 0 {
@@ -250,6 +257,7 @@ func (c *Collector) writeScrapeResult(domain core.KeystoneDomain, projectName, p
 		if err != nil {
 			return err
 		}
+		aggregateDeltas.Add(res.Name, datamodel.ProjectResourceDelta(&before, &res))
 	}
 
 	//insert missing project_resources entries
@@ -260,7 +268,13 @@ func (c *Collector) writeScrapeResult(domain core.KeystoneDomain, projectName, p
 		data := resourceData[resMetadata.Name]
 
 		initialQuota := uint64(0)
-		if constraint := serviceConstraints[resMetadata.Name]; constraint.Minimum != nil {
+		if !isFirstScrape && data.Quota >= 0 {
+			//This resource was added to a service that has been scraped before (e.g.
+			//when a new version of the plugin reports additional resources). Since
+			//Limes did not manage the backend quota until now, it is adopted as the
+			//initial quota instead of being overwritten with 0 by ApplyBackendQuota().
+			initialQuota = serviceConstraints[resMetadata.Name].ApplyTo(uint64(data.Quota))
+		} else if constraint := serviceConstraints[resMetadata.Name]; constraint.Minimum != nil {
 			initialQuota = *constraint.Minimum
 		}
 
@@ -296,12 +310,16 @@ func (c *Collector) writeScrapeResult(domain core.KeystoneDomain, projectName, p
 			}
 
 			if projectHasBursting {
-				behavior := c.Cluster.BehaviorForResource(serviceType, resMetadata.Name, domain.Name+"/"+projectName)
+				behavior := settings.BehaviorForResource(serviceType, resMetadata.Name, domain.Name+"/"+projectName)
 				desiredBackendQuota := behavior.MaxBurstMultiplier.ApplyTo(*res.Quota)
 				res.DesiredBackendQuota = &desiredBackendQuota
 			} else {
 				res.DesiredBackendQuota = res.Quota
 			}
+			if data.QuotaOnlyInLimes {
+				backendQuota := int64(*res.DesiredBackendQuota)
+				res.BackendQuota = &backendQuota
+			}
 		}
 
 		if len(data.Subresources) != 0 {
@@ -323,6 +341,12 @@ func (c *Collector) writeScrapeResult(domain core.KeystoneDomain, projectName, p
 		if err != nil {
 			return err
 		}
+		aggregateDeltas.Add(res.Name, datamodel.ProjectResourceDelta(nil, res))
+	}
+
+	err = datamodel.ApplyClusterAggregateDeltas(tx, c.Cluster.ID, serviceType, aggregateDeltas)
+	if err != nil {
+		return err
 	}
 
 	//update scraped_at timestamp and reset the stale flag on this service so
@@ -380,9 +404,10 @@ func (c *Collector) writeDummyResources(domain core.KeystoneDomain, projectName
 	}
 	defer db.RollbackUnlessCommitted(tx)
 
+	settings := c.Cluster.Settings()
 	var serviceConstraints map[string]core.QuotaConstraint
-	if c.Cluster.QuotaConstraints != nil {
-		serviceConstraints = c.Cluster.QuotaConstraints.Projects[domain.Name][projectName][serviceType]
+	if settings.QuotaConstraints != nil {
+		serviceConstraints = settings.QuotaConstraints.Projects[domain.Name][projectName][serviceType]
 	}
 
 	//find existing project_resources entries (we don't want to touch those)
@@ -398,6 +423,7 @@ func (c *Collector) writeDummyResources(domain core.KeystoneDomain, projectName
 	}
 
 	//create dummy resources
+	aggregateDeltas := make(datamodel.ClusterAggregateDeltas)
 	for _, resMetadata := range c.Plugin.Resources() {
 		if isExistingResource[resMetadata.Name] {
 			continue
@@ -424,7 +450,7 @@ func (c *Collector) writeDummyResources(domain core.KeystoneDomain, projectName
 			res.BackendQuota = nil
 		} else {
 			if projectHasBursting {
-				behavior := c.Cluster.BehaviorForResource(serviceType, resMetadata.Name, domain.Name+"/"+projectName)
+				behavior := settings.BehaviorForResource(serviceType, resMetadata.Name, domain.Name+"/"+projectName)
 				desiredBackendQuota := behavior.MaxBurstMultiplier.ApplyTo(*res.Quota)
 				res.DesiredBackendQuota = &desiredBackendQuota
 			} else {
@@ -436,6 +462,12 @@ func (c *Collector) writeDummyResources(domain core.KeystoneDomain, projectName
 		if err != nil {
 			return err
 		}
+		aggregateDeltas.Add(res.Name, datamodel.ProjectResourceDelta(nil, res))
+	}
+
+	err = datamodel.ApplyClusterAggregateDeltas(tx, c.Cluster.ID, serviceType, aggregateDeltas)
+	if err != nil {
+		return err
 	}
 
 	//update scraped_at timestamp and reset stale flag to make sure that we do
@@ -104,14 +104,17 @@ func prepareScrapeTest(t *testing.T, numProjects int, quotaPlugins ...core.Quota
 			"capacity": {Minimum: p2u64(10), Maximum: p2u64(40)},
 		},
 	}
-	cluster.QuotaConstraints = &core.QuotaConstraintSet{
-		Projects: map[string]map[string]core.QuotaConstraints{
-			domain1.Name: {
-				project1.Name: projectConstraints,
-				project2.Name: projectConstraints,
+	cluster.SetSettings(core.ClusterSettings{
+		Config: cluster.Config,
+		QuotaConstraints: &core.QuotaConstraintSet{
+			Projects: map[string]map[string]core.QuotaConstraints{
+				domain1.Name: {
+					project1.Name: projectConstraints,
+					project2.Name: projectConstraints,
+				},
 			},
 		},
-	}
+	})
 
 	return cluster
 }
@@ -180,7 +183,7 @@ func Test_ScrapeSuccess(t *testing.T) {
 	c.Scrape()
 	test.AssertDBContent(t, "fixtures/scrape4.sql") //same as scrape3.sql except for scraped_at timestamp
 
-	//set a quota that contradicts the cluster.QuotaConstraints
+	//set a quota that contradicts the cluster's QuotaConstraints
 	_, err = db.DB.Exec(`UPDATE project_resources SET quota = $1 WHERE name = $2`, 50, "capacity")
 	if err != nil {
 		t.Fatal(err)
@@ -24,6 +24,7 @@ import (
 	"regexp"
 	"sort"
 	"strconv"
+	"sync/atomic"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/sapcc/go-bits/logg"
@@ -33,20 +34,40 @@ import (
 //Cluster contains all configuration and runtime information about a single
 //cluster. It is passed around a lot in Limes code, mostly for the cluster ID,
 //the list of enabled services, and access to the quota and capacity plugins.
+//
+//Config contains the configuration as it was at startup. Those parts of it
+//that can be reloaded at runtime must be read through Settings() instead.
 type Cluster struct {
-	ID                string
+	ID              string
+	Config          *ClusterConfiguration
+	ServiceTypes    []string
+	IsServiceShared map[string]bool
+	DiscoveryPlugin DiscoveryPlugin
+	QuotaPlugins    map[string]QuotaPlugin
+	CapacityPlugins map[string]CapacityPlugin
+	Authoritative   bool
+	//settings contains a *ClusterSettings, see func Settings().
+	settings atomic.Value
+}
+
+//ClusterSettings contains the parts of a cluster's configuration that can be
+//replaced at runtime by Configuration.Reload(). Instances are never modified
+//once installed with SetSettings(); a reload installs a new instance instead.
+//Readers shall call Cluster.Settings() once per request or scrape and use
+//that snapshot throughout.
+type ClusterSettings struct {
+	//Config is like Cluster.Config, but includes the reloaded parts of the
+	//configuration (e.g. ResourceBehaviors and LowPrivilegeRaise).
 	Config            *ClusterConfiguration
-	ServiceTypes      []string
-	IsServiceShared   map[string]bool
-	DiscoveryPlugin   DiscoveryPlugin
-	QuotaPlugins      map[string]QuotaPlugin
-	CapacityPlugins   map[string]CapacityPlugin
-	Authoritative     bool
 	QuotaConstraints  *QuotaConstraintSet
-	LowPrivilegeRaise struct {
-		LimitsForDomains  map[string]map[string]LowPrivilegeRaiseLimit
-		LimitsForProjects map[string]map[string]LowPrivilegeRaiseLimit
-	}
+	LowPrivilegeRaise LowPrivilegeRaiseLimitSet
+}
+
+//LowPrivilegeRaiseLimitSet contains the parsed low-privilege raise limits of
+//a cluster, indexed by service type and resource name.
+type LowPrivilegeRaiseLimitSet struct {
+	LimitsForDomains  map[string]map[string]LowPrivilegeRaiseLimit
+	LimitsForProjects map[string]map[string]LowPrivilegeRaiseLimit
 }
 
 //NewCluster creates a new Cluster instance with the given ID and
@@ -69,9 +90,9 @@ func NewCluster(id string, config *ClusterConfiguration) *Cluster {
 	}
 
 	for _, srv := range config.Services {
-		factory, exists := quotaPluginFactories[srv.Type]
-		if !exists {
-			logg.Error("skipping service %s: no suitable collector plugin found", srv.Type)
+		factory, err := findQuotaPluginFactory(srv)
+		if err != nil {
+			logg.Error("skipping service: %s", err.Error())
 			continue
 		}
 
@@ -85,6 +106,18 @@ func NewCluster(id string, config *ClusterConfiguration) *Cluster {
 			logg.Error("skipping service %s: failed to initialize collector plugin", srv.Type)
 			continue
 		}
+		if srv.QuotaBackend != "" {
+			backendFactory, err := findQuotaBackendFactory(srv, plugin)
+			if err != nil {
+				logg.Error("skipping service %s: %s", srv.Type, err.Error())
+				continue
+			}
+			plugin = backendFactory(plugin, srv)
+		}
+		if srv.Plugin != "" && plugin.ServiceInfo().Area == "" {
+			logg.Error("skipping service %s: no area configured", srv.Type)
+			continue
+		}
 
 		c.ServiceTypes = append(c.ServiceTypes, srv.Type)
 		c.QuotaPlugins[srv.Type] = plugin
@@ -173,49 +206,86 @@ func (c *Cluster) Connect() error {
 		}
 	}
 
-	//load quota constraints
-	if c.Config.ConstraintConfigPath != "" && c.QuotaConstraints == nil {
-		var errs []error
-		c.QuotaConstraints, errs = NewQuotaConstraints(c, c.Config.ConstraintConfigPath)
-		if len(errs) > 0 {
-			for _, err := range errs {
-				logg.Error(err.Error())
-			}
-			return fmt.Errorf("cannot load quota constraints for cluster %s (see errors above)", c.ID)
+	constraints, lprLimits, errs := c.compileReloadableSettings(c.Config)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			logg.Error(err.Error())
 		}
+		return fmt.Errorf("cannot load configuration for cluster %s (see errors above)", c.ID)
+	}
+	c.SetSettings(ClusterSettings{
+		Config:            c.Config,
+		QuotaConstraints:  constraints,
+		LowPrivilegeRaise: lprLimits,
+	})
+	return nil
+}
+
+//Settings returns the current snapshot of the reloadable settings of this
+//cluster. Before Connect() has been called, the snapshot only contains the
+//Config.
+func (c *Cluster) Settings() *ClusterSettings {
+	s, ok := c.settings.Load().(*ClusterSettings)
+	if !ok {
+		return &ClusterSettings{Config: c.Config}
+	}
+	return s
+}
+
+//SetSettings replaces the reloadable settings of this cluster. This is called
+//by Connect() and Configuration.Reload(), and can be used by tests to inject
+//settings without loading them from the configuration.
+func (c *Cluster) SetSettings(s ClusterSettings) {
+	c.settings.Store(&s)
+}
+
+//compileReloadableSettings loads the QuotaConstraints and parses the
+//LowPrivilegeRaise.Limits described by the given config, and validates if
+//config.ResourceBehavior[].ScalesWith refers to existing resources. This is
+//used by Connect(), when reloading the configuration at runtime, and when
+//validating the configuration offline, so it must not modify the Cluster.
+//
+//All errors are collected and returned at once.
+func (c *Cluster) compileReloadableSettings(config *ClusterConfiguration) (constraints *QuotaConstraintSet, lprLimits LowPrivilegeRaiseLimitSet, errs []error) {
+	//load quota constraints
+	if config.ConstraintConfigPath != "" {
+		var constraintErrs []error
+		constraints, constraintErrs = NewQuotaConstraints(c, config.ConstraintConfigPath)
+		errs = append(errs, constraintErrs...)
 	}
 
 	//parse low-privilege raise limits
-	c.LowPrivilegeRaise.LimitsForDomains, err = c.parseLowPrivilegeRaiseLimits(
-		c.Config.LowPrivilegeRaise.Limits.ForDomains, "domain")
+	var err error
+	lprLimits.LimitsForDomains, err = c.parseLowPrivilegeRaiseLimits(
+		config.LowPrivilegeRaise.Limits.ForDomains, "domain")
 	if err != nil {
-		return fmt.Errorf("could not parse low-privilege raise limit: %s", err.Error())
+		errs = append(errs, fmt.Errorf("could not parse low-privilege raise limit: %s", err.Error()))
 	}
-	c.LowPrivilegeRaise.LimitsForProjects, err = c.parseLowPrivilegeRaiseLimits(
-		c.Config.LowPrivilegeRaise.Limits.ForProjects, "project")
+	lprLimits.LimitsForProjects, err = c.parseLowPrivilegeRaiseLimits(
+		config.LowPrivilegeRaise.Limits.ForProjects, "project")
 	if err != nil {
-		return fmt.Errorf("could not parse low-privilege raise limit: %s", err.Error())
+		errs = append(errs, fmt.Errorf("could not parse low-privilege raise limit: %s", err.Error()))
 	}
 
 	//validate scaling relations
-	for _, behavior := range c.Config.ResourceBehaviors {
+	for _, behavior := range config.ResourceBehaviors {
 		b := behavior.Compiled
 		if b.ScalesWithResourceName == "" {
 			continue
 		}
 		if !c.HasResource(b.ScalesWithServiceType, b.ScalesWithResourceName) {
-			return fmt.Errorf(`resources matching "%s" scale with unknown resource "%s/%s"`,
-				behavior.FullResourceName, b.ScalesWithServiceType, b.ScalesWithResourceName)
+			errs = append(errs, fmt.Errorf(`resources matching "%s" scale with unknown resource "%s/%s"`,
+				behavior.FullResourceName, b.ScalesWithServiceType, b.ScalesWithResourceName))
 		}
 	}
 
-	return nil
+	return constraints, lprLimits, errs
 }
 
 var percentOfClusterRx = regexp.MustCompile(`^([0-9.]+)\s*% of cluster capacity$`)
 var untilPercentOfClusterAssignedRx = regexp.MustCompile(`^until ([0-9.]+)\s*% of cluster capacity is assigned$`)
 
-func (c Cluster) parseLowPrivilegeRaiseLimits(inputs map[string]map[string]string, scopeType string) (map[string]map[string]LowPrivilegeRaiseLimit, error) {
+func (c *Cluster) parseLowPrivilegeRaiseLimits(inputs map[string]map[string]string, scopeType string) (map[string]map[string]LowPrivilegeRaiseLimit, error) {
 	result := make(map[string]map[string]LowPrivilegeRaiseLimit)
 	for srvType, quotaPlugin := range c.QuotaPlugins {
 		result[srvType] = make(map[string]LowPrivilegeRaiseLimit)
@@ -349,21 +419,33 @@ func (c *Cluster) InfoForService(serviceType string) limes.ServiceInfo {
 	return plugin.ServiceInfo()
 }
 
+//ServiceTypesForArea returns the types of all services in this cluster whose
+//ServiceInfo() reports the given area.
+func (c *Cluster) ServiceTypesForArea(area string) []string {
+	var result []string
+	for _, serviceType := range c.ServiceTypes {
+		if c.QuotaPlugins[serviceType].ServiceInfo().Area == area {
+			result = append(result, serviceType)
+		}
+	}
+	return result
+}
+
 //BehaviorForResource returns the ResourceBehavior for the given resource in
 //the given scope.
 //
 //`scopeName` should be empty for cluster resources, equal to the domain name
 //for domain resources, or equal to `$DOMAIN_NAME/$PROJECT_NAME` for project
 //resources.
-func (c *Cluster) BehaviorForResource(serviceType, resourceName, scopeName string) ResourceBehavior {
+func (s *ClusterSettings) BehaviorForResource(serviceType, resourceName, scopeName string) ResourceBehavior {
 	//default behavior
 	result := ResourceBehavior{
-		MaxBurstMultiplier: c.Config.Bursting.MaxMultiplier,
+		MaxBurstMultiplier: s.Config.Bursting.MaxMultiplier,
 	}
 
 	//check for specific behavior
 	fullName := serviceType + "/" + resourceName
-	for _, behaviorConfig := range c.Config.ResourceBehaviors {
+	for _, behaviorConfig := range s.Config.ResourceBehaviors {
 		behavior := behaviorConfig.Compiled
 		if !behavior.FullResourceNameRx.MatchString(fullName) {
 			continue
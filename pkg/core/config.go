@@ -21,10 +21,10 @@ package core
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
-	"os"
 	"regexp"
 	"strings"
 
@@ -107,6 +107,15 @@ type ServiceConfiguration struct {
 	Auth   *AuthParameters `yaml:"auth"`
 	// RateLimits describes the global rate limits (all requests for to a backend) and default project level rate limits.
 	RateLimits ServiceRateLimitConfiguration `yaml:"rate_limits"`
+	//If set, quota is stored in this backend instead of the service's own quota
+	//API (see RegisterQuotaBackend).
+	QuotaBackend   string                      `yaml:"quota_backend"`
+	KeystoneLimits KeystoneLimitsConfiguration `yaml:"keystone_limits"`
+	//If set, the quota plugin with this name (see RegisterConfigurableQuotaPlugin)
+	//is used instead of the built-in quota plugin for this service type.
+	Plugin      string                         `yaml:"plugin"`
+	GenericHTTP GenericHTTPConfiguration       `yaml:"generic_http"`
+	Prometheus  PrometheusServiceConfiguration `yaml:"prometheus"`
 	//for quota plugins that need configuration, add a field with the service type as
 	//name and put the config data in there (use a struct to be able to give
 	//config options meaningful names)
@@ -137,6 +146,47 @@ type ServiceConfiguration struct {
 	VolumeV2 struct {
 		VolumeTypes []string `yaml:"volume_types"`
 	} `yaml:"volumev2"`
+	Kubernetes KubernetesConfiguration `yaml:"kubernetes"`
+}
+
+//KeystoneLimitsConfiguration contains configuration parameters for services
+//with `quota_backend: keystone`.
+type KeystoneLimitsConfiguration struct {
+	//ID of the service in the Keystone catalog (default: looked up by service type)
+	ServiceID string `yaml:"service_id"`
+	//only needed if the registered limits for this service are region-specific
+	RegionID string `yaml:"region_id"`
+	//maps Limes resource names to Keystone resource names (default: identical names)
+	ResourceNames map[string]string `yaml:"resource_names"`
+}
+
+//GenericHTTPConfiguration contains configuration parameters for services
+//with `plugin: generic-http`.
+type GenericHTTPConfiguration struct {
+	Area        string `yaml:"area"`
+	ProductName string `yaml:"product_name"`
+	//base URL of the quota API (default: looked up in the Keystone catalog by service type)
+	URL string `yaml:"url"`
+}
+
+//PrometheusServiceConfiguration contains configuration parameters for services
+//with `plugin: prometheus`.
+type PrometheusServiceConfiguration struct {
+	Area        string                            `yaml:"area"`
+	ProductName string                            `yaml:"product_name"`
+	APIConfig   PrometheusAPIConfiguration        `yaml:"api"`
+	Resources   []PrometheusResourceConfiguration `yaml:"resources"`
+}
+
+//PrometheusResourceConfiguration describes a single resource of a service
+//with `plugin: prometheus`.
+type PrometheusResourceConfiguration struct {
+	Name     string     `yaml:"name"`
+	Unit     limes.Unit `yaml:"unit"`
+	Category string     `yaml:"category"`
+	//template for the usage query, with {{.DomainID}} and {{.ProjectID}} placeholders
+	UsageQuery string `yaml:"usage_query"`
+	NoQuota    bool   `yaml:"no_quota"`
 }
 
 //ServiceRateLimitConfiguration describes the global and project-level default rate limit configurations for a service.
@@ -177,8 +227,8 @@ type CapacitorConfiguration struct {
 		UsePlacementAPI       bool              `yaml:"use_placement_api"`
 	} `yaml:"nova"`
 	Prometheus struct {
-		APIConfig PrometheusAPIConfiguration   `yaml:"api"`
-		Queries   map[string]map[string]string `yaml:"queries"`
+		APIConfig PrometheusAPIConfiguration                    `yaml:"api"`
+		Queries   map[string]map[string]PrometheusCapacityQuery `yaml:"queries"`
 	} `yaml:"prometheus"`
 	Cinder struct {
 		VolumeTypes map[string]struct {
@@ -186,17 +236,51 @@ type CapacitorConfiguration struct {
 			IsDefault         bool   `yaml:"default"`
 		} `yaml:"volume_types"`
 	} `yaml:"cinder"`
+	Ironic struct {
+		FlavorAliases map[string][]string `yaml:"flavor_aliases"`
+	} `yaml:"ironic"`
 	Manila struct {
-		ShareTypes        []string `yaml:"share_types"`
-		ShareNetworks     uint64   `yaml:"share_networks"`
-		SharesPerPool     uint64   `yaml:"shares_per_pool"`
-		SnapshotsPerShare uint64   `yaml:"snapshots_per_share"`
-		CapacityBalance   float64  `yaml:"capacity_balance"`
+		ShareTypes          []string `yaml:"share_types"`
+		ShareNetworks       uint64   `yaml:"share_networks"`
+		ShareGroups         uint64   `yaml:"share_groups"`
+		ShareGroupSnapshots uint64   `yaml:"share_group_snapshots"`
+		SharesPerPool       uint64   `yaml:"shares_per_pool"`
+		SnapshotsPerShare   uint64   `yaml:"snapshots_per_share"`
+		CapacityBalance     float64  `yaml:"capacity_balance"`
 	} `yaml:"manila"`
-	Manual      map[string]map[string]uint64 `yaml:"manual"`
+	Manual  map[string]map[string]uint64 `yaml:"manual"`
+	Neutron struct {
+		ExternalNetworkIDs []string `yaml:"external_networks"`
+	} `yaml:"neutron"`
 	SAPCCIronic struct {
 		FlavorAliases map[string][]string `yaml:"flavor_aliases"`
 	} `yaml:"sapcc_ironic"`
+	Swift struct {
+		//storage nodes whose recon middleware is queried for disk usage
+		Nodes []SwiftStorageNodeConfiguration `yaml:"nodes"`
+		//alternative to Nodes: a single endpoint that reports disk usage for all nodes
+		AggregatedURL string `yaml:"aggregated_url"`
+		//replica count of the storage policy, either given statically or read
+		//from the object ring file at RingPath (if both are given, Replicas is
+		//used when the ring file is in an unsupported format)
+		Replicas float64 `yaml:"replicas"`
+		RingPath string  `yaml:"ring_path"`
+		//either "region", "zone" or empty (no per-AZ breakdown)
+		AZFrom string `yaml:"per_az"`
+	} `yaml:"swift"`
+	Kubernetes struct {
+		APIConfig PrometheusAPIConfiguration `yaml:"api"`
+		TokenPath string                     `yaml:"token_path"`
+		//label selector for nodes that are considered (default: all nodes)
+		NodeSelector string `yaml:"node_selector"`
+		//nodes with a taint with one of these keys are not considered
+		ExcludeTaints []string `yaml:"exclude_taints"`
+	} `yaml:"kubernetes"`
+	GenericHTTP struct {
+		APIConfig PrometheusAPIConfiguration `yaml:"api"`
+		//if true, requests carry the Keystone token of the Limes service user
+		KeystoneAuth bool `yaml:"keystone_auth"`
+	} `yaml:"generic_http"`
 }
 
 //LowPrivilegeRaiseConfiguration contains the configuration options for
@@ -312,22 +396,60 @@ type PrometheusAPIConfiguration struct {
 	ServerCACertificatePath  string `yaml:"ca_cert"`
 }
 
+//KubernetesConfiguration contains the parameters for connecting to a
+//Kubernetes API server.
+type KubernetesConfiguration struct {
+	APIConfig PrometheusAPIConfiguration `yaml:"api"`
+	//path to a file containing a bearer token (e.g. a service account token)
+	TokenPath string `yaml:"token_path"`
+	//label on namespaces that contains the Keystone project ID
+	ProjectLabel string `yaml:"project_label"`
+	//name of the ResourceQuota objects managed by Limes
+	ResourceQuotaName string `yaml:"resource_quota_name"`
+}
+
+//PrometheusCapacityQuery describes how the capacity of a single resource is
+//obtained by the "prometheus" capacitor. In the configuration file, it can also
+//be given as a plain string, which is equivalent to only giving the Query field.
+type PrometheusCapacityQuery struct {
+	//returns a single value, or one value per AZ if AZLabel is set
+	Query string `yaml:"query"`
+	//label that identifies the AZ in the results of Query and UsageQuery
+	AZLabel string `yaml:"az_label"`
+	//optional (requires AZLabel), returns the usage per AZ
+	UsageQuery string `yaml:"usage_query"`
+	//optional, each result is reported as one subcapacity
+	SubcapacitiesQuery string `yaml:"subcapacities_query"`
+}
+
+//UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (q *PrometheusCapacityQuery) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var query string
+	err := unmarshal(&query)
+	if err == nil {
+		*q = PrometheusCapacityQuery{Query: query}
+		return nil
+	}
+	type plain PrometheusCapacityQuery //same fields, but without the UnmarshalYAML method
+	return unmarshal((*plain)(q))
+}
+
+//SwiftStorageNodeConfiguration describes a Swift storage node that is queried
+//by the "swift" capacitor.
+type SwiftStorageNodeConfiguration struct {
+	//base URL of the object server with the recon middleware, e.g. "http://10.0.0.1:6000"
+	URL    string `yaml:"url"`
+	Region string `yaml:"region"`
+	Zone   string `yaml:"zone"`
+}
+
 //NewConfiguration reads and validates the given configuration file.
 //Errors are logged and will result in
 //program termination, causing the function to not return.
 func NewConfiguration(path string) (cfg Configuration) {
-	//read config file
-	configBytes, err := ioutil.ReadFile(path)
-	if err != nil {
-		logg.Fatal("read configuration file: %s", err.Error())
-	}
-	var cfgFile configurationInFile
-	err = yaml.Unmarshal(configBytes, &cfgFile)
+	cfgFile, configBytes, err := readConfigurationFile(path)
 	if err != nil {
-		logg.Fatal("parse configuration: %s", err.Error())
-	}
-	if !cfgFile.validate() {
-		os.Exit(1)
+		logg.Fatal(err.Error())
 	}
 
 	//inflate the ClusterConfiguration instances into Cluster, thereby validating
@@ -348,21 +470,46 @@ func NewConfiguration(path string) (cfg Configuration) {
 	}
 
 	//load the policy file
-	cfg.API.PolicyEnforcer, err = loadPolicyFile(cfg.API.PolicyFilePath)
+	enforcer, err := loadPolicyFile(cfg.API.PolicyFilePath)
 	if err != nil {
 		logg.Fatal(err.Error())
 	}
+	cfg.API.PolicyEnforcer = newReloadablePolicyEnforcer(enforcer)
 
+	configDigest.Store(computeConfigurationDigest(configBytes, cfgFile))
 	return
 }
 
-func (cfg configurationInFile) validate() (success bool) {
+//readConfigurationFile reads, parses and validates the given configuration
+//file. Validation errors are logged individually. The raw file contents are
+//returned alongside the parsed configuration.
+func readConfigurationFile(path string) (cfgFile configurationInFile, configBytes []byte, err error) {
+	configBytes, err = ioutil.ReadFile(path)
+	if err != nil {
+		return cfgFile, nil, fmt.Errorf("read configuration file: %s", err.Error())
+	}
+	err = yaml.Unmarshal(configBytes, &cfgFile)
+	if err != nil {
+		return cfgFile, nil, fmt.Errorf("parse configuration: %s", err.Error())
+	}
+	errs := cfgFile.validate()
+	if len(errs) > 0 {
+		for _, err := range errs {
+			logg.Error(err.Error())
+		}
+		return cfgFile, nil, errors.New("configuration file is invalid (see errors above)")
+	}
+	return cfgFile, configBytes, nil
+}
+
+func (cfg configurationInFile) validate() (errs []error) {
 	//do not fail on first error; keep going and report all errors at once
-	success = true //until proven otherwise
+	fail := func(msg string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(msg, args...))
+	}
 
 	missing := func(key string) {
-		logg.Error("missing %s configuration value", key)
-		success = false
+		fail("missing %s configuration value", key)
 	}
 	if len(cfg.Clusters) == 0 {
 		missing("clusters[]")
@@ -371,16 +518,15 @@ func (cfg configurationInFile) validate() (success bool) {
 	for clusterID, cluster := range cfg.Clusters {
 		switch clusterID {
 		case "current":
-			logg.Error("\"current\" is not an acceptable cluster ID (it would make the URL /v1/clusters/current ambiguous)")
-			success = false
+			fail("\"current\" is not an acceptable cluster ID (it would make the URL /v1/clusters/current ambiguous)")
+		case "aggregate":
+			fail("\"aggregate\" is not an acceptable cluster ID (it would make the URL /v1/clusters/aggregate ambiguous)")
 		case "shared":
-			logg.Error("\"shared\" is not an acceptable cluster ID (it is used for internal accounting)")
-			success = false
+			fail("\"shared\" is not an acceptable cluster ID (it is used for internal accounting)")
 		}
 
 		missing := func(key string) {
-			logg.Error("missing clusters[%s].%s configuration value", clusterID, key)
-			success = false
+			fail("missing clusters[%s].%s configuration value", clusterID, key)
 		}
 		compileOptionalRx := func(pattern string) *regexp.Regexp {
 			if pattern == "" {
@@ -388,8 +534,7 @@ func (cfg configurationInFile) validate() (success bool) {
 			}
 			rx, err := regexp.Compile(pattern)
 			if err != nil {
-				logg.Error("failed to compile regex %#v: %s", pattern, err.Error())
-				success = false
+				fail("failed to compile regex %#v: %s", pattern, err.Error())
 			}
 			return rx
 		}
@@ -407,11 +552,9 @@ func (cfg configurationInFile) validate() (success bool) {
 		case cluster.Auth.AuthURL == "":
 			missing("auth.auth_url")
 		case !strings.HasPrefix(cluster.Auth.AuthURL, "http://") && !strings.HasPrefix(cluster.Auth.AuthURL, "https://"):
-			logg.Error("clusters[%s].auth.auth_url does not look like a HTTP URL", clusterID)
-			success = false
+			fail("clusters[%s].auth.auth_url does not look like a HTTP URL", clusterID)
 		case !strings.HasSuffix(cluster.Auth.AuthURL, "/v3/"):
-			logg.Error("clusters[%s].auth.auth_url does not end with \"/v3/\"", clusterID)
-			success = false
+			fail("clusters[%s].auth.auth_url does not end with \"/v3/\"", clusterID)
 		}
 
 		if cluster.Auth.UserName == "" {
@@ -476,8 +619,7 @@ func (cfg configurationInFile) validate() (success bool) {
 			if behavior.MaxBurstMultiplier != nil {
 				behavior.Compiled.MaxBurstMultiplier = *behavior.MaxBurstMultiplier
 				if *behavior.MaxBurstMultiplier < 0 {
-					logg.Error(`clusters[%s].resource_behavior[%d].max_burst_multiplier may not be negative`, clusterID, idx)
-					success = false
+					fail(`clusters[%s].resource_behavior[%d].max_burst_multiplier may not be negative`, clusterID, idx)
 				}
 			} else {
 				behavior.Compiled.MaxBurstMultiplier = limes.BurstingMultiplier(math.Inf(+1))
@@ -496,22 +638,50 @@ func (cfg configurationInFile) validate() (success bool) {
 						behavior.Compiled.ScalesWithResourceName = fields[1]
 						behavior.Compiled.ScalingFactor = behavior.ScalingFactor
 					} else {
-						logg.Error(`clusters[%s].resource_behavior[%d].scales_with must have the format "service_type/resource_name"`, clusterID, idx)
-						success = false
+						fail(`clusters[%s].resource_behavior[%d].scales_with must have the format "service_type/resource_name"`, clusterID, idx)
+					}
+				}
+			}
+		}
+
+		//reject references to resources that have moved to a different service
+		//type (these would otherwise be ignored silently); patterns that match
+		//all resources of the old service type (e.g. "network/.*") do not refer
+		//to the moved resources specifically, so they are accepted
+		for idx, behavior := range cluster.ResourceBehaviors {
+			rx := behavior.Compiled.FullResourceNameRx
+			for _, move := range ResourceMoves {
+				matchesAnyResource := rx != nil && rx.MatchString(move.FromServiceType+"/-")
+				for _, resourceName := range move.ResourceNames {
+					oldName := move.FromServiceType + "/" + resourceName
+					refersToOldName := rx != nil && rx.MatchString(oldName) && !matchesAnyResource
+					if refersToOldName || behavior.ScalesWith == oldName {
+						fail("clusters[%s].resource_behavior[%d]: %s",
+							clusterID, idx, checkForMovedResource(move.FromServiceType, resourceName).Error())
+					}
+				}
+			}
+		}
+		for scopeType, limits := range map[string]map[string]map[string]string{
+			"domains":  cluster.LowPrivilegeRaise.Limits.ForDomains,
+			"projects": cluster.LowPrivilegeRaise.Limits.ForProjects,
+		} {
+			for serviceType, serviceLimits := range limits {
+				for resourceName := range serviceLimits {
+					if err := checkForMovedResource(serviceType, resourceName); err != nil {
+						fail("clusters[%s].lowpriv_raise.limits.%s: %s", clusterID, scopeType, err.Error())
 					}
 				}
 			}
 		}
 
 		if cluster.Bursting.MaxMultiplier < 0 {
-			logg.Error("clusters[%s].bursting.max_multiplier may not be negative")
-			success = false
+			fail("clusters[%s].bursting.max_multiplier may not be negative", clusterID)
 		}
 
 		//warn about removed configuration options
 		if cluster.OldSeedConfigPath != "" {
-			logg.Error("quota seeds have been replaced by quota constraints: rename clusters[%s].seeds config key to clusters[%s].constraints and convert seed file into constraint file; documentation at https://github.com/sapcc/limes/blob/master/docs/operators/constraints.md", clusterID, clusterID)
-			success = false
+			fail("quota seeds have been replaced by quota constraints: rename clusters[%s].seeds config key to clusters[%s].constraints and convert seed file into constraint file; documentation at https://github.com/sapcc/limes/blob/master/docs/operators/constraints.md", clusterID, clusterID)
 		}
 
 		if cluster.CADF.Enabled && cluster.CADF.RabbitMQ.QueueName == "" {
@@ -226,6 +226,10 @@ func compileQuotaConstraints(cluster *Cluster, data map[string]map[string]string
 		values[serviceType] = make(map[string]QuotaConstraint)
 
 		for resourceName, constraintStr := range serviceData {
+			if err := checkForMovedResource(serviceType, resourceName); err != nil {
+				errors = append(errors, err)
+				continue
+			}
 			if !cluster.HasResource(serviceType, resourceName) {
 				//this is not an error: our global constraint sets have domain quota
 				//constraints "at least 0 more than project constraints" for all
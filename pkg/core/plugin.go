@@ -20,6 +20,7 @@
 package core
 
 import (
+	"fmt"
 	"math/big"
 
 	"github.com/gophercloud/gophercloud"
@@ -57,11 +58,17 @@ type DiscoveryPlugin interface {
 //The Subresources field may optionally be populated with subresources, if the
 //quota plugin providing this ResourceData instance has been instructed to (and
 //is able to) scrape subresources for this resource.
+//
+//Plugins for services that do not store quota themselves (i.e. the quota only
+//exists in Limes) set QuotaOnlyInLimes instead of reporting a Quota value. The
+//collector then takes the backend quota to be equal to the desired backend
+//quota.
 type ResourceData struct {
-	Quota         int64 //negative values indicate infinite quota
-	Usage         uint64
-	PhysicalUsage *uint64 //only supported by some plugins
-	Subresources  []interface{}
+	Quota            int64 //negative values indicate infinite quota
+	QuotaOnlyInLimes bool
+	Usage            uint64
+	PhysicalUsage    *uint64 //only supported by some plugins
+	Subresources     []interface{}
 }
 
 //QuotaPlugin is the interface that the quota/usage collector plugins for all
@@ -127,6 +134,18 @@ type QuotaPlugin interface {
 	CollectMetrics(ch chan<- prometheus.Metric, clusterID, domainUUID, projectUUID, serializedMetrics string) error
 }
 
+//UsageOnlyQuotaPlugin is an optional interface for QuotaPlugins that can
+//scrape usage without using the quota API of the backend service. This is
+//required for services that use a quota backend (see
+//ServiceConfiguration.QuotaBackend), since the quota API of these services
+//may not be available anymore.
+type UsageOnlyQuotaPlugin interface {
+	QuotaPlugin
+	//ScrapeUsage is like Scrape, but must not access the quota API of the
+	//backend service. The Quota field of the returned ResourceData is ignored.
+	ScrapeUsage(client *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string) (result map[string]ResourceData, serializedMetrics string, error error)
+}
+
 //CapacityData contains the total and per-availability-zone capacity data for a
 //single resource.
 //
@@ -207,6 +226,12 @@ type DiscoveryPluginFactory func(DiscoveryConfiguration) DiscoveryPlugin
 //(the keys are resource names).
 type QuotaPluginFactory func(cfg ServiceConfiguration, scrapeSubresources map[string]bool) QuotaPlugin
 
+//QuotaBackendFactory is a function that wraps a QuotaPlugin such that quota
+//values are stored in a different backend instead of the backend service's
+//own quota API. It is used for services where ServiceConfiguration.QuotaBackend
+//is set. The wrapped plugin is guaranteed to implement UsageOnlyQuotaPlugin.
+type QuotaBackendFactory func(plugin QuotaPlugin, cfg ServiceConfiguration) QuotaPlugin
+
 //CapacityPluginFactory is a function that produces capacity plugins with a
 //certain ID. The capacity plugin instance will use the capacitor configuration
 //given to it if it wants to. For plugins that support subcapacity scraping,
@@ -216,8 +241,9 @@ type CapacityPluginFactory func(cfg CapacitorConfiguration, scrapeSubcapacities
 
 var discoveryPluginFactories = map[string]DiscoveryPluginFactory{}
 var quotaPluginFactories = map[string]QuotaPluginFactory{}
+var configurableQuotaPluginFactories = map[string]QuotaPluginFactory{}
+var quotaBackendFactories = map[string]QuotaBackendFactory{}
 var capacityPluginFactories = map[string]CapacityPluginFactory{}
-var serviceTypesByArea = map[string][]string{}
 
 //RegisterDiscoveryPlugin registers a DiscoveryPlugin with this package. It may
 //only be called once, typically in a func init() for the package that offers
@@ -260,13 +286,75 @@ func RegisterQuotaPlugin(factory QuotaPluginFactory) {
 		panic("collector.RegisterQuotaPlugin() called multiple times for service type: " + info.Type)
 	}
 	quotaPluginFactories[info.Type] = factory
-	serviceTypesByArea[info.Area] = append(serviceTypesByArea[info.Area], info.Type)
 }
 
-//GetServiceTypesForArea returns a list of all service types whose QuotaPlugins
-//report the given area.
-func GetServiceTypesForArea(area string) []string {
-	return serviceTypesByArea[area]
+//RegisterConfigurableQuotaPlugin registers a QuotaPlugin whose service type
+//and area are not fixed, but taken from the ServiceConfiguration. It is used
+//for services where ServiceConfiguration.Plugin is set to the given name. It
+//may only be called once for each name, typically in a func init() for the
+//package that offers the QuotaPlugin.
+func RegisterConfigurableQuotaPlugin(name string, factory QuotaPluginFactory) {
+	if factory == nil {
+		panic("collector.RegisterConfigurableQuotaPlugin() called with nil QuotaPluginFactory instance")
+	}
+	if name == "" {
+		panic("collector.RegisterConfigurableQuotaPlugin() called with empty name!")
+	}
+	if configurableQuotaPluginFactories[name] != nil {
+		panic("collector.RegisterConfigurableQuotaPlugin() called multiple times for name: " + name)
+	}
+	configurableQuotaPluginFactories[name] = factory
+}
+
+//findQuotaPluginFactory returns the factory for the quota plugin that is
+//selected by the given service configuration.
+func findQuotaPluginFactory(srv ServiceConfiguration) (QuotaPluginFactory, error) {
+	if srv.Plugin == "" {
+		factory, exists := quotaPluginFactories[srv.Type]
+		if !exists {
+			return nil, fmt.Errorf("no suitable collector plugin found for service %s", srv.Type)
+		}
+		return factory, nil
+	}
+
+	if quotaPluginFactories[srv.Type] != nil {
+		return nil, fmt.Errorf("cannot use plugin %q for service %s: this service type is reserved for a built-in plugin", srv.Plugin, srv.Type)
+	}
+	factory, exists := configurableQuotaPluginFactories[srv.Plugin]
+	if !exists {
+		return nil, fmt.Errorf("no suitable collector plugin found for service %s: unknown plugin %q", srv.Type, srv.Plugin)
+	}
+	return factory, nil
+}
+
+//RegisterQuotaBackend registers a QuotaBackendFactory with this package. It
+//may only be called once for each name, typically in a func init() for the
+//package that offers the QuotaBackendFactory.
+func RegisterQuotaBackend(name string, factory QuotaBackendFactory) {
+	if factory == nil {
+		panic("collector.RegisterQuotaBackend() called with nil QuotaBackendFactory instance")
+	}
+	if name == "" {
+		panic("collector.RegisterQuotaBackend() called with empty name!")
+	}
+	if quotaBackendFactories[name] != nil {
+		panic("collector.RegisterQuotaBackend() called multiple times for name: " + name)
+	}
+	quotaBackendFactories[name] = factory
+}
+
+//findQuotaBackendFactory returns the factory for the quota backend that is
+//selected by the given service configuration, after checking that it can be
+//used with the given quota plugin.
+func findQuotaBackendFactory(srv ServiceConfiguration, plugin QuotaPlugin) (QuotaBackendFactory, error) {
+	factory, exists := quotaBackendFactories[srv.QuotaBackend]
+	if !exists {
+		return nil, fmt.Errorf("no suitable quota backend found for %q", srv.QuotaBackend)
+	}
+	if _, ok := plugin.(UsageOnlyQuotaPlugin); !ok {
+		return nil, fmt.Errorf("quota backend %q cannot be used because the collector plugin cannot scrape usage without the quota API of the service", srv.QuotaBackend)
+	}
+	return factory, nil
 }
 
 //RegisterCapacityPlugin registers a CapacityPlugin with this package. It may
@@ -0,0 +1,302 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	policy "github.com/databus23/goslo.policy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/gopherpolicy"
+	"github.com/sapcc/go-bits/logg"
+)
+
+var configReloadSuccessCounter = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "limes_successful_config_reloads",
+		Help: "Counter for successful configuration reloads.",
+	},
+)
+
+var configReloadFailedCounter = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "limes_failed_config_reloads",
+		Help: "Counter for failed configuration reloads.",
+	},
+)
+
+var configReloadTimestampGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "limes_last_successful_config_reload",
+		Help: "UNIX timestamp of the last successful configuration reload (or of program startup if no reload took place).",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(configReloadSuccessCounter)
+	prometheus.MustRegister(configReloadFailedCounter)
+	prometheus.MustRegister(configReloadTimestampGauge)
+	configReloadTimestampGauge.Set(float64(time.Now().Unix()))
+}
+
+//Only one reload may be in progress at any given time.
+var reloadMutex sync.Mutex
+
+//configDigest contains the digest of the configuration that is currently
+//active (as a string). It is set on startup and after each successful reload.
+var configDigest atomic.Value
+
+var (
+	executableDigest     string
+	executableDigestOnce sync.Once
+)
+
+//ConfigurationDigest returns a string that identifies the active configuration
+//(including the files referenced by it) and the running binary. Unlike a
+//counter of reloads, it is the same across restarts and across replicas as
+//long as they run the same binary with the same configuration.
+func ConfigurationDigest() string {
+	executableDigestOnce.Do(func() {
+		executableDigest = computeExecutableDigest()
+	})
+	digest, _ := configDigest.Load().(string)
+	return digest + ":" + executableDigest
+}
+
+func computeExecutableDigest() string {
+	path, err := os.Executable()
+	if err == nil {
+		var buf []byte
+		buf, err = ioutil.ReadFile(path)
+		if err == nil {
+			return sha256Hex(buf)
+		}
+	}
+	logg.Error("cannot compute digest of executable: %s", err.Error())
+	return ""
+}
+
+//computeConfigurationDigest computes the digest for ConfigurationDigest().
+//Besides the configuration file itself, this includes all files that are read
+//again on reload.
+func computeConfigurationDigest(configBytes []byte, cfgFile configurationInFile) string {
+	paths := []string{cfgFile.API.PolicyFilePath}
+	for _, cluster := range cfgFile.Clusters {
+		paths = append(paths, cluster.ConstraintConfigPath)
+	}
+	sort.Strings(paths)
+
+	hash := sha256.New()
+	hash.Write(configBytes)
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			//does not happen in practice since this file was read successfully before
+			buf = []byte(err.Error())
+		}
+		fmt.Fprintf(hash, "\x00%s\x00%s", path, sha256Hex(buf))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func sha256Hex(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+//Reload re-reads the configuration file at `path` and applies those parts of
+//it that can be changed at runtime:
+//
+//- the quota constraints file of each cluster (clusters.$id.constraints),
+//- the resource behaviors of each cluster (clusters.$id.resource_behavior),
+//- the low-privilege raise configuration of each cluster (clusters.$id.lowpriv_raise),
+//- the policy file (api.policy).
+//
+//The new configuration is validated completely before any of it is applied.
+//If any error occurs, the old configuration remains active and the error is
+//returned. Changes to other parts of the configuration (e.g. the list of
+//services or the auth parameters) require a restart and are only reported in
+//the log.
+//
+//Because the clusters and the policy enforcer are shared between all copies
+//of a Configuration, it is sufficient to call this method on any such copy.
+//The outcome is reported in the log and through the limes_*_config_reloads
+//metrics.
+func (cfg Configuration) Reload(path string) error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	err := cfg.reload(path)
+	if err != nil {
+		configReloadFailedCounter.Inc()
+		logg.Error("configuration reload failed, keeping the previous configuration: %s", err.Error())
+		return err
+	}
+	configReloadSuccessCounter.Inc()
+	configReloadTimestampGauge.Set(float64(time.Now().Unix()))
+	logg.Info("configuration reloaded successfully from %s", path)
+	return nil
+}
+
+//clusterReload contains the new values for the reloadable parts of a single
+//cluster that were computed by Configuration.reload().
+type clusterReload struct {
+	Cluster  *Cluster
+	Settings ClusterSettings
+}
+
+func (cfg Configuration) reload(path string) error {
+	enforcer, ok := cfg.API.PolicyEnforcer.(*reloadablePolicyEnforcer)
+	if !ok {
+		return errors.New("policy enforcer does not support reloading")
+	}
+
+	cfgFile, configBytes, err := readConfigurationFile(path)
+	if err != nil {
+		return err
+	}
+
+	//phase 1: compute and validate everything, but do not change anything yet
+	var reloads []clusterReload
+	for clusterID, cluster := range cfg.Clusters {
+		newClusterConfig, exists := cfgFile.Clusters[clusterID]
+		if !exists {
+			return fmt.Errorf("cluster %s is missing in the new configuration", clusterID)
+		}
+		warnAboutUnreloadableChanges(clusterID, cluster.Config, newClusterConfig)
+
+		//only the reloadable parts are taken from the new config, everything else
+		//stays as it is since the plugins were initialized with it
+		merged := *cluster.Settings().Config
+		merged.ConstraintConfigPath = newClusterConfig.ConstraintConfigPath
+		merged.LowPrivilegeRaise = newClusterConfig.LowPrivilegeRaise
+		merged.ResourceBehaviors = newClusterConfig.ResourceBehaviors
+
+		constraints, lprLimits, errs := cluster.compileReloadableSettings(&merged)
+		if len(errs) > 0 {
+			for _, err := range errs {
+				logg.Error(err.Error())
+			}
+			return fmt.Errorf("cannot load configuration for cluster %s (see errors above)", clusterID)
+		}
+		reloads = append(reloads, clusterReload{
+			Cluster: cluster,
+			Settings: ClusterSettings{
+				Config:            &merged,
+				QuotaConstraints:  constraints,
+				LowPrivilegeRaise: lprLimits,
+			},
+		})
+	}
+	for clusterID := range cfgFile.Clusters {
+		if _, exists := cfg.Clusters[clusterID]; !exists {
+			logg.Info("ignoring new cluster %s in configuration reload (adding clusters requires a restart)", clusterID)
+		}
+	}
+
+	newPolicyEnforcer, err := loadPolicyFile(cfgFile.API.PolicyFilePath)
+	if err != nil {
+		return fmt.Errorf("cannot load policy file: %s", err.Error())
+	}
+
+	//phase 2: swap in the new values
+	for _, r := range reloads {
+		r.Cluster.SetSettings(r.Settings)
+	}
+	enforcer.Set(newPolicyEnforcer)
+	configDigest.Store(computeConfigurationDigest(configBytes, cfgFile))
+	return nil
+}
+
+//warnAboutUnreloadableChanges logs a message when the new configuration
+//changes the set of services or capacitors, since this is not applied until
+//the next restart.
+func warnAboutUnreloadableChanges(clusterID string, oldConfig, newConfig *ClusterConfiguration) {
+	var oldServices, newServices, oldCapacitors, newCapacitors []string
+	for _, srv := range oldConfig.Services {
+		oldServices = append(oldServices, srv.Type)
+	}
+	for _, srv := range newConfig.Services {
+		newServices = append(newServices, srv.Type)
+	}
+	for _, capa := range oldConfig.Capacitors {
+		oldCapacitors = append(oldCapacitors, capa.ID)
+	}
+	for _, capa := range newConfig.Capacitors {
+		newCapacitors = append(newCapacitors, capa.ID)
+	}
+
+	if !equalAsSets(oldServices, newServices) {
+		logg.Info("ignoring change of clusters[%s].services in configuration reload (requires a restart)", clusterID)
+	}
+	if !equalAsSets(oldCapacitors, newCapacitors) {
+		logg.Info("ignoring change of clusters[%s].capacitors in configuration reload (requires a restart)", clusterID)
+	}
+}
+
+func equalAsSets(a, b []string) bool {
+	sort.Strings(a)
+	sort.Strings(b)
+	return strings.Join(a, "\x00") == strings.Join(b, "\x00")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// reloadable policy enforcer
+
+//reloadablePolicyEnforcer is a gopherpolicy.Enforcer that forwards to another
+//enforcer which can be replaced atomically at runtime.
+type reloadablePolicyEnforcer struct {
+	inner atomic.Value //contains policyEnforcerBox
+}
+
+//We need the box type because atomic.Value requires all stored values to have
+//the same concrete type.
+type policyEnforcerBox struct {
+	Enforcer gopherpolicy.Enforcer
+}
+
+func newReloadablePolicyEnforcer(e gopherpolicy.Enforcer) *reloadablePolicyEnforcer {
+	r := &reloadablePolicyEnforcer{}
+	r.Set(e)
+	return r
+}
+
+//Set replaces the enforcer that this instance forwards to.
+func (r *reloadablePolicyEnforcer) Set(e gopherpolicy.Enforcer) {
+	r.inner.Store(policyEnforcerBox{e})
+}
+
+//Enforce implements the gopherpolicy.Enforcer interface.
+func (r *reloadablePolicyEnforcer) Enforce(rule string, ctx policy.Context) bool {
+	return r.inner.Load().(policyEnforcerBox).Enforcer.Enforce(rule, ctx)
+}
@@ -0,0 +1,129 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package core
+
+import (
+	"testing"
+
+	policy "github.com/databus23/goslo.policy"
+)
+
+type denyAllPolicyEnforcer struct{}
+
+func (denyAllPolicyEnforcer) Enforce(rule string, ctx policy.Context) bool {
+	return false
+}
+
+func configurationForReloadTest() Configuration {
+	cluster := clusterForQuotaConstraintTest()
+	cluster.ID = "west"
+	cluster.Config = &ClusterConfiguration{
+		Auth: &AuthParameters{},
+		Services: []ServiceConfiguration{
+			{Type: "service-one"},
+			{Type: "service-two"},
+		},
+	}
+	return Configuration{
+		Clusters: map[string]*Cluster{"west": cluster},
+		API: APIConfiguration{
+			PolicyEnforcer: newReloadablePolicyEnforcer(denyAllPolicyEnforcer{}),
+		},
+	}
+}
+
+func TestConfigurationReloadSuccess(t *testing.T) {
+	cfg := configurationForReloadTest()
+	cluster := cfg.Clusters["west"]
+	oldConfig := cluster.Config
+	oldSettings := cluster.Settings()
+	oldDigest := ConfigurationDigest()
+
+	err := cfg.Reload("fixtures/config-reload-valid.yaml")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	newDigest := ConfigurationDigest()
+	if newDigest == oldDigest {
+		t.Error("expected configuration digest to change")
+	}
+	//reloading the same configuration again must not change the digest
+	err = cfg.Reload("fixtures/config-reload-valid.yaml")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if ConfigurationDigest() != newDigest {
+		t.Error("expected configuration digest to be stable for the same configuration")
+	}
+
+	if cluster.Config != oldConfig {
+		t.Error("expected startup config to be retained")
+	}
+	settings := cluster.Settings()
+	if settings.Config == oldConfig {
+		t.Error("expected cluster config to be replaced")
+	}
+	if settings.Config.Auth != oldConfig.Auth {
+		t.Error("expected auth parameters to be retained")
+	}
+	if oldSettings.QuotaConstraints != nil {
+		t.Error("expected previous settings snapshot to remain unchanged")
+	}
+	if settings.QuotaConstraints == nil || len(settings.QuotaConstraints.Domains) == 0 {
+		t.Error("expected quota constraints to be loaded")
+	}
+	limit := settings.LowPrivilegeRaise.LimitsForProjects["service-one"]["capacity_MiB"]
+	if limit.AbsoluteValue != 1024 {
+		t.Errorf("expected low-privilege raise limit of 1024 MiB, got %#v", limit)
+	}
+	behavior := settings.BehaviorForResource("service-two", "things", "")
+	if behavior.ScalesWithResourceName != "things" || behavior.ScalingFactor != 2 {
+		t.Errorf("expected resource behavior to be reloaded, got %#v", behavior)
+	}
+	if !cfg.API.PolicyEnforcer.Enforce("project:show", policy.Context{}) {
+		t.Error("expected policy to be reloaded")
+	}
+}
+
+func TestConfigurationReloadFailure(t *testing.T) {
+	cfg := configurationForReloadTest()
+	cluster := cfg.Clusters["west"]
+	oldConfig := cluster.Config
+	oldDigest := ConfigurationDigest()
+
+	err := cfg.Reload("fixtures/config-reload-invalid.yaml")
+	if err == nil {
+		t.Fatal("expected reload to fail, but it succeeded")
+	}
+	if ConfigurationDigest() != oldDigest {
+		t.Error("expected configuration digest to be retained")
+	}
+
+	settings := cluster.Settings()
+	if settings.Config != oldConfig {
+		t.Error("expected cluster config to be retained")
+	}
+	if settings.QuotaConstraints != nil {
+		t.Error("expected quota constraints to be retained")
+	}
+	if cfg.API.PolicyEnforcer.Enforce("project:show", policy.Context{}) {
+		t.Error("expected policy to be retained")
+	}
+}
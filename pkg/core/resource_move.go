@@ -0,0 +1,60 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package core
+
+import "fmt"
+
+//ResourceMove describes resources that have moved from one service type to
+//another, e.g. because a quota plugin was split in two.
+type ResourceMove struct {
+	FromServiceType string
+	ToServiceType   string
+	ResourceNames   []string
+}
+
+//ResourceMoves contains all resource moves that Limes knows about. The
+//existing records of moved resources are migrated by package datamodel during
+//the consistency check once the new service type is configured.
+var ResourceMoves = []ResourceMove{
+	{
+		FromServiceType: "network",
+		ToServiceType:   "load-balancer",
+		ResourceNames:   []string{"healthmonitors", "l7policies", "listeners", "loadbalancers", "pool_members", "pools"},
+	},
+}
+
+//checkForMovedResource returns an error if the given resource has moved to a
+//different service type. This is used to reject configuration that still
+//refers to the old resource name, since it would otherwise be ignored
+//silently.
+func checkForMovedResource(serviceType, resourceName string) error {
+	for _, move := range ResourceMoves {
+		if move.FromServiceType != serviceType {
+			continue
+		}
+		for _, name := range move.ResourceNames {
+			if name == resourceName {
+				return fmt.Errorf("resource %s/%s has moved to %s/%s",
+					serviceType, resourceName, move.ToServiceType, resourceName)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package core
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const configWithMovedResources = `
+clusters:
+  west:
+    services:
+      - type: network
+      - type: load-balancer
+    lowpriv_raise:
+      limits:
+        projects:
+          network:
+            loadbalancers: 10
+            floating_ips: 5
+          load-balancer:
+            listeners: 20
+    resource_behavior:
+      - resource: network/(pools|floating_ips)
+        max_burst_multiplier: 0.2
+      - resource: load-balancer/pool_members
+        scales_with: network/pools
+        scaling_factor: 10
+      - resource: network/.*
+        overcommit_factor: 2
+`
+
+func TestConfigRejectsMovedResources(t *testing.T) {
+	var cfg configurationInFile
+	err := yaml.Unmarshal([]byte(configWithMovedResources), &cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var actual []string
+	for _, err := range cfg.validate() {
+		if strings.Contains(err.Error(), "has moved") {
+			actual = append(actual, err.Error())
+		}
+	}
+	sort.Strings(actual)
+	expected := []string{
+		"clusters[west].lowpriv_raise.limits.projects: resource network/loadbalancers has moved to load-balancer/loadbalancers",
+		"clusters[west].resource_behavior[0]: resource network/pools has moved to load-balancer/pools",
+		"clusters[west].resource_behavior[1]: resource network/pools has moved to load-balancer/pools",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected errors %#v, but got %#v", expected, actual)
+	}
+}
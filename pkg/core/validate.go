@@ -0,0 +1,285 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/limes"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//ConfigDiagnostic is a single finding reported by ValidateConfigurationOffline.
+type ConfigDiagnostic struct {
+	//Severity is either "error" or "warning".
+	Severity string `json:"severity"`
+	//ClusterID is empty for findings that do not relate to a specific cluster.
+	ClusterID string `json:"cluster,omitempty"`
+	Message   string `json:"message"`
+}
+
+//ValidateConfigurationOffline runs all static checks on the configuration
+//file at `path` without contacting any backend services: the checks done by
+//NewConfiguration(), parsing of quota constraints and low-privilege raise
+//limits, validation of scaling relations, and parsing of the policy file.
+//
+//Since the resources of each service are usually only known after calling
+//Init() on the quota plugins, they are taken from `resourceCache` instead.
+//This is usually the parsed response of `GET /v1/clusters`. Checks that
+//require the list of resources are skipped (with a warning) for clusters
+//that are missing in the cache.
+func ValidateConfigurationOffline(path string, resourceCache []limes.ClusterReport) (result []ConfigDiagnostic) {
+	report := func(severity, clusterID string, err error) {
+		result = append(result, ConfigDiagnostic{severity, clusterID, err.Error()})
+	}
+
+	configBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		report("error", "", fmt.Errorf("read configuration file: %s", err.Error()))
+		return
+	}
+	var cfgFile configurationInFile
+	err = yaml.Unmarshal(configBytes, &cfgFile)
+	if err != nil {
+		report("error", "", fmt.Errorf("parse configuration: %s", err.Error()))
+		return
+	}
+
+	//the policy file can be checked even if the rest of the config is invalid
+	if cfgFile.API.PolicyFilePath != "" {
+		_, err := loadPolicyFile(cfgFile.API.PolicyFilePath)
+		if err != nil {
+			report("error", "", fmt.Errorf("cannot load policy file: %s", err.Error()))
+		}
+	}
+
+	errs := cfgFile.validate()
+	for _, err := range errs {
+		report("error", "", err)
+	}
+	if len(errs) > 0 {
+		//the following checks rely on the compiled regexes etc. from validate()
+		return
+	}
+
+	cachedServices := make(map[string]limes.ClusterServiceReports, len(resourceCache))
+	for _, clusterReport := range resourceCache {
+		cachedServices[clusterReport.ID] = clusterReport.Services
+	}
+
+	clusterIDs := make([]string, 0, len(cfgFile.Clusters))
+	for clusterID := range cfgFile.Clusters {
+		clusterIDs = append(clusterIDs, clusterID)
+	}
+	sort.Strings(clusterIDs)
+
+	for _, clusterID := range clusterIDs {
+		config := cfgFile.Clusters[clusterID]
+		services, exists := cachedServices[clusterID]
+		result = append(result, validateClusterConfigurationOffline(clusterID, config, services, exists)...)
+	}
+
+	return result
+}
+
+func validateClusterConfigurationOffline(clusterID string, config *ClusterConfiguration, cachedServices limes.ClusterServiceReports, hasCache bool) (result []ConfigDiagnostic) {
+	report := func(severity string, err error) {
+		result = append(result, ConfigDiagnostic{severity, clusterID, err.Error()})
+	}
+
+	//check that all requested plugins exist (this is what NewCluster() would do)
+	method := config.Discovery.Method
+	if method == "" {
+		method = "list"
+	}
+	if discoveryPluginFactories[method] == nil {
+		report("error", fmt.Errorf("no suitable discovery plugin found for method %q", method))
+	}
+	for _, srv := range config.Services {
+		factory, err := findQuotaPluginFactory(srv)
+		if err != nil {
+			report("error", err)
+			continue
+		}
+		plugin := factory(srv, map[string]bool{})
+		//the area of configurable plugins is taken from the configuration
+		if srv.Plugin != "" && plugin.ServiceInfo().Area == "" {
+			report("error", fmt.Errorf("no area configured for service %s", srv.Type))
+		}
+		if srv.QuotaBackend != "" {
+			_, err := findQuotaBackendFactory(srv, plugin)
+			if err != nil {
+				report("error", fmt.Errorf("service %s: %s", srv.Type, err.Error()))
+			}
+		}
+	}
+	for _, capa := range config.Capacitors {
+		if capacityPluginFactories[capa.ID] == nil {
+			report("error", fmt.Errorf("no suitable collector plugin found for capacitor %s", capa.ID))
+		}
+	}
+
+	if !hasCache {
+		report("warning", errors.New("no resources found for this cluster in the resource cache, so constraints, low-privilege raise limits and resource behaviors were not checked"))
+		return
+	}
+
+	//build a cluster whose quota plugins report the cached resources
+	c := &Cluster{
+		ID:              clusterID,
+		Config:          config,
+		IsServiceShared: make(map[string]bool),
+		QuotaPlugins:    make(map[string]QuotaPlugin),
+	}
+	for _, srv := range config.Services {
+		srvReport := cachedServices[srv.Type]
+		if srvReport == nil {
+			report("warning", fmt.Errorf("no resources found for service %s in the resource cache", srv.Type))
+			continue
+		}
+		c.ServiceTypes = append(c.ServiceTypes, srv.Type)
+		c.QuotaPlugins[srv.Type] = newCachedQuotaPlugin(srvReport)
+		c.IsServiceShared[srv.Type] = srv.Shared
+	}
+	sort.Strings(c.ServiceTypes)
+
+	_, _, errs := c.compileReloadableSettings(config)
+	for _, err := range errs {
+		report("error", err)
+	}
+
+	//lint: find config entries that do not apply to any resource
+	for idx, behavior := range config.ResourceBehaviors {
+		matches := false
+		for _, serviceType := range c.ServiceTypes {
+			for _, res := range c.QuotaPlugins[serviceType].Resources() {
+				if behavior.Compiled.FullResourceNameRx.MatchString(serviceType + "/" + res.Name) {
+					matches = true
+				}
+			}
+		}
+		if !matches {
+			report("warning", fmt.Errorf("resource_behavior[%d].resource %q does not match any resource", idx, behavior.FullResourceName))
+		}
+	}
+	lprLimits := map[string]map[string]map[string]string{
+		"domains":  config.LowPrivilegeRaise.Limits.ForDomains,
+		"projects": config.LowPrivilegeRaise.Limits.ForProjects,
+	}
+	var unknownLimits []string
+	for _, scopeType := range []string{"domains", "projects"} {
+		for serviceType, limits := range lprLimits[scopeType] {
+			for resourceName := range limits {
+				if !c.HasResource(serviceType, resourceName) {
+					unknownLimits = append(unknownLimits, fmt.Sprintf("lowpriv_raise.limits.%s contains a limit for unknown resource %s/%s (it will be ignored)", scopeType, serviceType, resourceName))
+				}
+			}
+		}
+	}
+	sort.Strings(unknownLimits) //determinism is useful for diffing the output
+	for _, msg := range unknownLimits {
+		report("warning", errors.New(msg))
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// quota plugin with cached resource list
+
+//cachedQuotaPlugin is a QuotaPlugin that only knows the ServiceInfo and
+//resource list from a ClusterServiceReport. It is used for offline
+//validation of configuration files, and cannot talk to any backend.
+type cachedQuotaPlugin struct {
+	info      limes.ServiceInfo
+	resources []limes.ResourceInfo
+	rates     []limes.RateInfo
+}
+
+func newCachedQuotaPlugin(report *limes.ClusterServiceReport) *cachedQuotaPlugin {
+	p := &cachedQuotaPlugin{info: report.ServiceInfo}
+	for _, res := range report.Resources {
+		p.resources = append(p.resources, res.ResourceInfo)
+	}
+	for _, rate := range report.Rates {
+		p.rates = append(p.rates, rate.RateInfo)
+	}
+	sort.Slice(p.resources, func(i, j int) bool {
+		return p.resources[i].Name < p.resources[j].Name
+	})
+	sort.Slice(p.rates, func(i, j int) bool {
+		return p.rates[i].Name < p.rates[j].Name
+	})
+	return p
+}
+
+var errCachedQuotaPlugin = errors.New("operation not supported during offline validation")
+
+//Init implements the QuotaPlugin interface.
+func (p *cachedQuotaPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
+	return nil
+}
+
+//ServiceInfo implements the QuotaPlugin interface.
+func (p *cachedQuotaPlugin) ServiceInfo() limes.ServiceInfo {
+	return p.info
+}
+
+//Resources implements the QuotaPlugin interface.
+func (p *cachedQuotaPlugin) Resources() []limes.ResourceInfo {
+	return p.resources
+}
+
+//Rates implements the QuotaPlugin interface.
+func (p *cachedQuotaPlugin) Rates() []limes.RateInfo {
+	return p.rates
+}
+
+//Scrape implements the QuotaPlugin interface.
+func (p *cachedQuotaPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string) (map[string]ResourceData, string, error) {
+	return nil, "", errCachedQuotaPlugin
+}
+
+//ScrapeRates implements the QuotaPlugin interface.
+func (p *cachedQuotaPlugin) ScrapeRates(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, prevSerializedState string) (map[string]*big.Int, string, error) {
+	return nil, "", errCachedQuotaPlugin
+}
+
+//SetQuota implements the QuotaPlugin interface.
+func (p *cachedQuotaPlugin) SetQuota(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, quotas map[string]uint64) error {
+	return errCachedQuotaPlugin
+}
+
+//DescribeMetrics implements the QuotaPlugin interface.
+func (p *cachedQuotaPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	//not used by this plugin
+}
+
+//CollectMetrics implements the QuotaPlugin interface.
+func (p *cachedQuotaPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, domainUUID, projectUUID, serializedMetrics string) error {
+	//not used by this plugin
+	return nil
+}
@@ -0,0 +1,120 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/sapcc/limes"
+)
+
+func loadResourceCacheForTest(t *testing.T) []limes.ClusterReport {
+	t.Helper()
+	buf, err := ioutil.ReadFile("fixtures/resource-cache.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var data struct {
+		Clusters []limes.ClusterReport `json:"clusters"`
+	}
+	err = json.Unmarshal(buf, &data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return data.Clusters
+}
+
+func TestValidateConfigurationOffline(t *testing.T) {
+	//validation only checks that the requested plugins exist, so we can get
+	//away with registering dummy factories
+	discoveryPluginFactories["list"] = func(DiscoveryConfiguration) DiscoveryPlugin { return nil }
+	for _, serviceType := range []string{"service-one", "service-two"} {
+		plugin := quotaConstraintTestPlugin{serviceType}
+		quotaPluginFactories[serviceType] = func(ServiceConfiguration, map[string]bool) QuotaPlugin { return plugin }
+	}
+	defer func() {
+		delete(discoveryPluginFactories, "list")
+		delete(quotaPluginFactories, "service-one")
+		delete(quotaPluginFactories, "service-two")
+	}()
+
+	expectDiagnostics(t, "fixtures/config-reload-valid.yaml", loadResourceCacheForTest(t))
+
+	expectDiagnostics(t, "fixtures/config-reload-invalid.yaml", loadResourceCacheForTest(t),
+		ConfigDiagnostic{"error", "west", `resources matching "service-two/things" scale with unknown resource "service-one/unknown"`},
+		ConfigDiagnostic{"warning", "west", "lowpriv_raise.limits.projects contains a limit for unknown resource service-two/unknown (it will be ignored)"},
+	)
+
+	expectDiagnostics(t, "fixtures/config-reload-valid.yaml", nil,
+		ConfigDiagnostic{"warning", "west", "no resources found for this cluster in the resource cache, so constraints, low-privilege raise limits and resource behaviors were not checked"},
+	)
+
+	expectDiagnostics(t, "fixtures/does-not-exist.yaml", nil,
+		ConfigDiagnostic{"error", "", "read configuration file: open fixtures/does-not-exist.yaml: no such file or directory"},
+	)
+
+	//without registered plugins, each service is reported as an error
+	delete(quotaPluginFactories, "service-two")
+	expectDiagnostics(t, "fixtures/config-reload-valid.yaml", loadResourceCacheForTest(t),
+		ConfigDiagnostic{"error", "west", "no suitable collector plugin found for service service-two"},
+	)
+
+	//configurable plugins need an area in their configuration
+	configurableQuotaPluginFactories["dummy"] = func(srv ServiceConfiguration, _ map[string]bool) QuotaPlugin {
+		return quotaConstraintTestPlugin{srv.Type}
+	}
+	defer delete(configurableQuotaPluginFactories, "dummy")
+	//quota backends need plugins that can scrape usage without the service's quota API
+	quotaBackendFactories["dummy"] = func(plugin QuotaPlugin, _ ServiceConfiguration) QuotaPlugin { return plugin }
+	defer delete(quotaBackendFactories, "dummy")
+	config := &ClusterConfiguration{Services: []ServiceConfiguration{
+		{Type: "service-three", Plugin: "dummy"},
+		{Type: "service-one", QuotaBackend: "dummy"},
+		{Type: "service-one", QuotaBackend: "unknown"},
+	}}
+	actual := validateClusterConfigurationOffline("west", config, nil, false)
+	expected := []ConfigDiagnostic{
+		{"error", "west", "no area configured for service service-three"},
+		{"error", "west", `service service-one: quota backend "dummy" cannot be used because the collector plugin cannot scrape usage without the quota API of the service`},
+		{"error", "west", `service service-one: no suitable quota backend found for "unknown"`},
+		{"warning", "west", "no resources found for this cluster in the resource cache, so constraints, low-privilege raise limits and resource behaviors were not checked"},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Error("unexpected diagnostics for services with invalid plugin configuration")
+		t.Logf("  expected = %#v", expected)
+		t.Logf("    actual = %#v", actual)
+	}
+}
+
+func expectDiagnostics(t *testing.T, path string, resourceCache []limes.ClusterReport, expected ...ConfigDiagnostic) {
+	t.Helper()
+	actual := ValidateConfigurationOffline(path, resourceCache)
+	if len(actual) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("unexpected diagnostics for %s", path)
+		t.Logf("  expected = %#v", expected)
+		t.Logf("    actual = %#v", actual)
+	}
+}
@@ -0,0 +1,459 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package datamodel
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/sapcc/limes/pkg/db"
+)
+
+//The `cluster_aggregates` table caches the sums of domain quota, project
+//quota, usage and burst usage for each resource in a cluster, so that quota
+//validation does not need to aggregate over all project resources in the
+//cluster for every PUT request.
+//
+//The records for a cluster are created on first use by
+//FillClusterAggregates(). Afterwards, they are updated incrementally by
+//everyone who writes quota or usage values, using ApplyClusterAggregateDeltas()
+//in the same transaction as the write. Deletions that cascade into
+//`domain_resources` or `project_resources` must call one of the
+//Subtract...FromClusterAggregates() functions in the same transaction, before
+//the deletion. Writes that bypass all of this cause drift that is detected and
+//repaired by CheckClusterAggregates(). Capacity values are not cached since
+//they can be read cheaply from `cluster_resources`.
+//
+//Project resources without quota do not contribute to `projects_quota`, but
+//SUM() over only NULL values is NULL, not 0. To report NULL in the same way,
+//`projects_with_quota` counts the project resources that have a quota.
+
+var clusterAggregatesQuery = db.SimplifyWhitespaceInSQL(`
+	SELECT type, name, SUM(domains_quota), SUM(projects_quota), SUM(projects_with_quota), SUM(usage), SUM(burst_usage) FROM (
+		SELECT ds.type, dr.name, dr.quota AS domains_quota, 0 AS projects_quota, 0 AS projects_with_quota, 0 AS usage, 0 AS burst_usage
+		  FROM domains d
+		  JOIN domain_services ds ON ds.domain_id = d.id
+		  JOIN domain_resources dr ON dr.service_id = ds.id
+		 WHERE d.cluster_id = $1
+		UNION ALL
+		SELECT ps.type, pr.name, 0, COALESCE(pr.quota, 0), CASE WHEN pr.quota IS NULL THEN 0 ELSE 1 END,
+		       pr.usage, COALESCE(GREATEST(pr.usage - pr.quota, 0), 0)
+		  FROM domains d
+		  JOIN projects p ON p.domain_id = d.id
+		  JOIN project_services ps ON ps.project_id = p.id
+		  JOIN project_resources pr ON pr.service_id = ps.id
+		 WHERE d.cluster_id = $1
+	) x GROUP BY type, name
+`)
+
+//This computes the contribution of a part of a cluster to the cluster
+//aggregates. The placeholders are filled with conditions that select the
+//relevant domain resources and project resources, respectively.
+var partialAggregatesQuery = `
+	SELECT type, name, SUM(domains_quota), SUM(projects_quota), SUM(projects_with_quota), SUM(usage), SUM(burst_usage) FROM (
+		SELECT ds.type, dr.name, dr.quota AS domains_quota, 0 AS projects_quota, 0 AS projects_with_quota, 0 AS usage, 0 AS burst_usage
+		  FROM domains d
+		  JOIN domain_services ds ON ds.domain_id = d.id
+		  JOIN domain_resources dr ON dr.service_id = ds.id
+		 WHERE d.cluster_id = $1 AND %[1]s
+		UNION ALL
+		SELECT ps.type, pr.name, 0, COALESCE(pr.quota, 0), CASE WHEN pr.quota IS NULL THEN 0 ELSE 1 END,
+		       pr.usage, COALESCE(GREATEST(pr.usage - pr.quota, 0), 0)
+		  FROM domains d
+		  JOIN projects p ON p.domain_id = d.id
+		  JOIN project_services ps ON ps.project_id = p.id
+		  JOIN project_resources pr ON pr.service_id = ps.id
+		 WHERE d.cluster_id = $1 AND %[2]s
+	) x GROUP BY type, name
+`
+
+//The INSERT is needed for resources that did not exist yet when the records
+//for this cluster were created. The EXISTS condition skips clusters whose
+//records have not been created yet.
+var applyClusterAggregateDeltaQuery = db.SimplifyWhitespaceInSQL(`
+	INSERT INTO cluster_aggregates (cluster_id, service_type, resource_name, domains_quota, projects_quota, projects_with_quota, usage, burst_usage)
+	SELECT $1, $2, $3, $4::BIGINT, $5::BIGINT, $6::BIGINT, $7::BIGINT, $8::BIGINT
+	 WHERE EXISTS (SELECT 1 FROM cluster_aggregates WHERE cluster_id = $1)
+	    ON CONFLICT (cluster_id, service_type, resource_name) DO UPDATE
+	   SET domains_quota = cluster_aggregates.domains_quota + EXCLUDED.domains_quota,
+	       projects_quota = cluster_aggregates.projects_quota + EXCLUDED.projects_quota,
+	       projects_with_quota = cluster_aggregates.projects_with_quota + EXCLUDED.projects_with_quota,
+	       usage = cluster_aggregates.usage + EXCLUDED.usage,
+	       burst_usage = cluster_aggregates.burst_usage + EXCLUDED.burst_usage
+`)
+
+//Unlike applyClusterAggregateDeltaQuery, this does not create missing records:
+//When there is nothing to subtract from, the records for this resource have
+//already been removed (or were never created).
+var subtractClusterAggregateQuery = db.SimplifyWhitespaceInSQL(`
+	UPDATE cluster_aggregates
+	   SET domains_quota = domains_quota - $4, projects_quota = projects_quota - $5,
+	       projects_with_quota = projects_with_quota - $6, usage = usage - $7, burst_usage = burst_usage - $8
+	 WHERE cluster_id = $1 AND service_type = $2 AND resource_name = $3
+`)
+
+//ClusterAggregateDelta describes a change to a record in the
+//`cluster_aggregates` table.
+type ClusterAggregateDelta struct {
+	DomainsQuota      int64
+	ProjectsQuota     int64
+	ProjectsWithQuota int64
+	Usage             int64
+	BurstUsage        int64
+}
+
+//ProjectResourceDelta returns the change in the cluster aggregates when the
+//given project resource changes from `before` to `after`. For newly created
+//resources, `before` shall be nil.
+//
+//All arithmetic is done on int64 since this is how Postgres sums up the
+//BIGINT columns in clusterAggregatesQuery.
+func ProjectResourceDelta(before, after *db.ProjectResource) ClusterAggregateDelta {
+	var d ClusterAggregateDelta
+	if before != nil {
+		d = d.sub(projectResourceContribution(*before))
+	}
+	return d.add(projectResourceContribution(*after))
+}
+
+//DomainResourceDelta is like ProjectResourceDelta, but for domain resources.
+func DomainResourceDelta(before, after *db.DomainResource) ClusterAggregateDelta {
+	var d ClusterAggregateDelta
+	if before != nil {
+		d.DomainsQuota -= int64(before.Quota)
+	}
+	d.DomainsQuota += int64(after.Quota)
+	return d
+}
+
+func projectResourceContribution(res db.ProjectResource) ClusterAggregateDelta {
+	c := ClusterAggregateDelta{Usage: int64(res.Usage)}
+	if res.Quota != nil {
+		c.ProjectsQuota = int64(*res.Quota)
+		c.ProjectsWithQuota = 1
+		if c.Usage > c.ProjectsQuota {
+			c.BurstUsage = c.Usage - c.ProjectsQuota
+		}
+	}
+	return c
+}
+
+func (d ClusterAggregateDelta) add(other ClusterAggregateDelta) ClusterAggregateDelta {
+	return ClusterAggregateDelta{
+		DomainsQuota:      d.DomainsQuota + other.DomainsQuota,
+		ProjectsQuota:     d.ProjectsQuota + other.ProjectsQuota,
+		ProjectsWithQuota: d.ProjectsWithQuota + other.ProjectsWithQuota,
+		Usage:             d.Usage + other.Usage,
+		BurstUsage:        d.BurstUsage + other.BurstUsage,
+	}
+}
+
+func (d ClusterAggregateDelta) sub(other ClusterAggregateDelta) ClusterAggregateDelta {
+	return ClusterAggregateDelta{
+		DomainsQuota:      d.DomainsQuota - other.DomainsQuota,
+		ProjectsQuota:     d.ProjectsQuota - other.ProjectsQuota,
+		ProjectsWithQuota: d.ProjectsWithQuota - other.ProjectsWithQuota,
+		Usage:             d.Usage - other.Usage,
+		BurstUsage:        d.BurstUsage - other.BurstUsage,
+	}
+}
+
+func (d ClusterAggregateDelta) isZero() bool {
+	return d == ClusterAggregateDelta{}
+}
+
+//ClusterAggregateDeltas collects the changes to the resources of a single
+//service, keyed by resource name.
+type ClusterAggregateDeltas map[string]ClusterAggregateDelta
+
+//Add records a change for the given resource.
+func (d ClusterAggregateDeltas) Add(resourceName string, delta ClusterAggregateDelta) {
+	d[resourceName] = d[resourceName].add(delta)
+}
+
+//ApplyClusterAggregateDeltas updates the cached aggregates for the given
+//service. This must be called in the same transaction that writes the changes
+//that the deltas were computed from. If FillClusterAggregates() has not
+//created the records for this cluster yet, nothing is done.
+func ApplyClusterAggregateDeltas(dbi db.Interface, clusterID, serviceType string, deltas ClusterAggregateDeltas) error {
+	//apply in a deterministic order to avoid deadlocks between concurrent transactions
+	resourceNames := make([]string, 0, len(deltas))
+	for resourceName, delta := range deltas {
+		if !delta.isZero() {
+			resourceNames = append(resourceNames, resourceName)
+		}
+	}
+	if len(resourceNames) == 0 {
+		return nil
+	}
+	sort.Strings(resourceNames)
+
+	stmt, err := dbi.Prepare(applyClusterAggregateDeltaQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, resourceName := range resourceNames {
+		d := deltas[resourceName]
+		_, err := stmt.Exec(clusterID, serviceType, resourceName, d.DomainsQuota, d.ProjectsQuota, d.ProjectsWithQuota, d.Usage, d.BurstUsage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//SubtractDomainFromClusterAggregates removes the contribution of the given
+//domain (including all its projects) from the cached aggregates. This must be
+//called in the same transaction that deletes the domain, before the deletion.
+func SubtractDomainFromClusterAggregates(dbi db.Interface, domain db.Domain) error {
+	return subtractFromClusterAggregates(dbi, domain.ClusterID, "d.id = $2", "d.id = $2", domain.ID)
+}
+
+//SubtractProjectFromClusterAggregates removes the contribution of the given
+//project from the cached aggregates. This must be called in the same
+//transaction that deletes the project, before the deletion.
+func SubtractProjectFromClusterAggregates(dbi db.Interface, domain db.Domain, project db.Project) error {
+	return subtractFromClusterAggregates(dbi, domain.ClusterID, "FALSE", "p.id = $2", project.ID)
+}
+
+func subtractDomainServiceFromClusterAggregates(dbi db.Interface, domain db.Domain, srv db.DomainService) error {
+	return subtractFromClusterAggregates(dbi, domain.ClusterID, "ds.id = $2", "FALSE", srv.ID)
+}
+
+func subtractProjectServiceFromClusterAggregates(dbi db.Interface, domain db.Domain, srv db.ProjectService) error {
+	return subtractFromClusterAggregates(dbi, domain.ClusterID, "FALSE", "ps.id = $2", srv.ID)
+}
+
+func subtractFromClusterAggregates(dbi db.Interface, clusterID, domainCondition, projectCondition string, id int64) error {
+	query := db.SimplifyWhitespaceInSQL(fmt.Sprintf(partialAggregatesQuery, domainCondition, projectCondition))
+	contributions, err := computeClusterAggregates(dbi, query, clusterID, id)
+	if err != nil {
+		return err
+	}
+
+	//apply in a deterministic order to avoid deadlocks between concurrent transactions
+	sort.Slice(contributions, func(i, j int) bool {
+		if contributions[i].ServiceType != contributions[j].ServiceType {
+			return contributions[i].ServiceType < contributions[j].ServiceType
+		}
+		return contributions[i].ResourceName < contributions[j].ResourceName
+	})
+	for _, c := range contributions {
+		if aggregateValues(c).isZero() {
+			continue
+		}
+		_, err := dbi.Exec(subtractClusterAggregateQuery, clusterID, c.ServiceType, c.ResourceName,
+			c.DomainsQuota, c.ProjectsQuota, c.ProjectsWithQuota, c.Usage, c.BurstUsage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//DeleteClusterAggregatesForService removes the cached aggregates for a service
+//type that is not configured for the given cluster anymore.
+func DeleteClusterAggregatesForService(dbi db.Interface, clusterID, serviceType string) error {
+	_, err := dbi.Exec(`DELETE FROM cluster_aggregates WHERE cluster_id = $1 AND service_type = $2`, clusterID, serviceType)
+	return err
+}
+
+//FillClusterAggregates creates the `cluster_aggregates` records for each of
+//the given clusters that does not have any records yet.
+func FillClusterAggregates(clusterIDs []string) error {
+	for _, clusterID := range clusterIDs {
+		count, err := db.DB.SelectInt(`SELECT COUNT(*) FROM cluster_aggregates WHERE cluster_id = $1`, clusterID)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		_, err = syncClusterAggregates(clusterID, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//CheckClusterAggregates compares the `cluster_aggregates` records for the
+//given cluster with freshly computed sums, and repairs all records that have
+//drifted. A description of each repaired record is returned. If the records
+//for this cluster have not been created yet, nothing is done.
+func CheckClusterAggregates(clusterID string) (drift []string, err error) {
+	return syncClusterAggregates(clusterID, false)
+}
+
+//Like clusterAggregatesQuery, but restricted to a single resource. Postgres
+//pushes the outer condition down into both branches of the UNION.
+var singleClusterAggregateQuery = db.SimplifyWhitespaceInSQL(fmt.Sprintf(
+	`SELECT * FROM (%s) y WHERE type = $2 AND name = $3`,
+	clusterAggregatesQuery,
+))
+
+func syncClusterAggregates(clusterID string, fill bool) (drift []string, err error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer db.RollbackUnlessCommitted(tx)
+
+	//When checking, the expensive aggregation runs before we take the lock (see
+	//below), so that writers are not blocked while it runs. Only the records
+	//that look drifted are recomputed under the lock.
+	var computed []db.ClusterAggregate
+	if !fill {
+		computed, err = computeClusterAggregates(tx, clusterAggregatesQuery, clusterID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//The lock conflicts with the UPDATEs in ApplyClusterAggregateDeltas(), so
+	//every concurrent write is either visible in the queries below (if it was
+	//committed before we acquired the lock), or its delta is applied after
+	//we're done (if not).
+	_, err = tx.Exec(`LOCK TABLE cluster_aggregates IN EXCLUSIVE MODE`)
+	if err != nil {
+		return nil, err
+	}
+
+	var cached []db.ClusterAggregate
+	_, err = tx.Select(&cached, `SELECT * FROM cluster_aggregates WHERE cluster_id = $1`, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	if fill != (len(cached) == 0) {
+		//somebody else was faster (when filling), or nothing to check yet
+		return nil, nil
+	}
+	cachedByKey := make(map[string]db.ClusterAggregate, len(cached))
+	for _, agg := range cached {
+		cachedByKey[agg.ServiceType+"/"+agg.ResourceName] = agg
+	}
+
+	if fill {
+		computed, err = computeClusterAggregates(tx, clusterAggregatesQuery, clusterID)
+	} else {
+		computed, err = recomputeMismatchedClusterAggregates(tx, clusterID, computed, cachedByKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, agg := range computed {
+		agg := agg
+		key := agg.ServiceType + "/" + agg.ResourceName
+		old, exists := cachedByKey[key]
+		delete(cachedByKey, key)
+		switch {
+		case !exists:
+			if !fill {
+				drift = append(drift, fmt.Sprintf("%s: missing record", key))
+			}
+			err = tx.Insert(&agg)
+		case old != agg:
+			drift = append(drift, fmt.Sprintf("%s: expected %+v, but found %+v", key, aggregateValues(agg), aggregateValues(old)))
+			_, err = tx.Update(&agg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//remaining records belong to resources that do not exist anymore (this is
+	//not drift if all values have been subtracted when the resources were deleted)
+	for key, agg := range cachedByKey {
+		agg := agg
+		if !aggregateValues(agg).isZero() {
+			drift = append(drift, fmt.Sprintf("%s: unexpected record", key))
+		}
+		_, err := tx.Delete(&agg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(drift)
+	return drift, tx.Commit()
+}
+
+//Takes the aggregates that were computed without holding the lock, and
+//returns the records that need to be compared with `cachedByKey`. Records
+//that match their cached counterpart are returned as-is, all others are
+//recomputed. Cached records that do not appear in the result are deleted by
+//the caller, so records missing from `computed` are recomputed as well.
+func recomputeMismatchedClusterAggregates(tx db.Interface, clusterID string, computed []db.ClusterAggregate, cachedByKey map[string]db.ClusterAggregate) ([]db.ClusterAggregate, error) {
+	var (
+		result     []db.ClusterAggregate
+		suspicious [][2]string
+	)
+	isComputed := make(map[string]bool, len(computed))
+	for _, agg := range computed {
+		key := agg.ServiceType + "/" + agg.ResourceName
+		isComputed[key] = true
+		if old, exists := cachedByKey[key]; exists && old == agg {
+			result = append(result, agg)
+		} else {
+			suspicious = append(suspicious, [2]string{agg.ServiceType, agg.ResourceName})
+		}
+	}
+	for key, agg := range cachedByKey {
+		if !isComputed[key] {
+			suspicious = append(suspicious, [2]string{agg.ServiceType, agg.ResourceName})
+		}
+	}
+
+	for _, k := range suspicious {
+		aggs, err := computeClusterAggregates(tx, singleClusterAggregateQuery, clusterID, k[0], k[1])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, aggs...)
+	}
+	return result, nil
+}
+
+func computeClusterAggregates(dbi db.Interface, query, clusterID string, args ...interface{}) ([]db.ClusterAggregate, error) {
+	//NOTE: collect all rows before returning, since the caller cannot issue
+	//further queries on the transaction while the result set is still open
+	var result []db.ClusterAggregate
+	args = append([]interface{}{clusterID}, args...)
+	err := db.ForeachRow(dbi, query, args, func(rows *sql.Rows) error {
+		agg := db.ClusterAggregate{ClusterID: clusterID}
+		err := rows.Scan(&agg.ServiceType, &agg.ResourceName, &agg.DomainsQuota, &agg.ProjectsQuota, &agg.ProjectsWithQuota, &agg.Usage, &agg.BurstUsage)
+		result = append(result, agg)
+		return err
+	})
+	return result, err
+}
+
+func aggregateValues(agg db.ClusterAggregate) ClusterAggregateDelta {
+	return ClusterAggregateDelta{
+		DomainsQuota:      agg.DomainsQuota,
+		ProjectsQuota:     agg.ProjectsQuota,
+		ProjectsWithQuota: agg.ProjectsWithQuota,
+		Usage:             agg.Usage,
+		BurstUsage:        agg.BurstUsage,
+	}
+}
@@ -20,7 +20,9 @@
 package datamodel
 
 import (
+	"fmt"
 	"sort"
+	"time"
 
 	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/limes"
@@ -31,7 +33,10 @@ import (
 
 //ValidateDomainServices ensures that all required DomainService records for
 //this domain exist (and none other). It returns the full set of domain services.
-func ValidateDomainServices(tx *gorp.Transaction, cluster *core.Cluster, domain db.Domain) ([]db.DomainService, error) {
+//
+//If quotas of the domain are changed to satisfy constraints, or moved between
+//services, the domain's quota_changed_at is set to `now`.
+func ValidateDomainServices(tx *gorp.Transaction, cluster *core.Cluster, domain db.Domain, now time.Time) ([]db.DomainService, error) {
 	//list existing records
 	seen := make(map[string]bool)
 	var services []db.DomainService
@@ -42,8 +47,8 @@ func ValidateDomainServices(tx *gorp.Transaction, cluster *core.Cluster, domain
 	}
 
 	var constraints core.QuotaConstraints
-	if cluster.QuotaConstraints != nil {
-		constraints = cluster.QuotaConstraints.Domains[domain.Name]
+	if settings := cluster.Settings(); settings.QuotaConstraints != nil {
+		constraints = settings.QuotaConstraints.Domains[domain.Name]
 	}
 
 	for _, srv := range services {
@@ -51,15 +56,24 @@ func ValidateDomainServices(tx *gorp.Transaction, cluster *core.Cluster, domain
 		seen[srv.Type] = true
 		if !cluster.HasService(srv.Type) {
 			logg.Info("cleaning up %s service entry for domain %s", srv.Type, domain.Name)
-			_, err := tx.Delete(&srv)
+			err := subtractDomainServiceFromClusterAggregates(tx, domain, srv)
+			if err != nil {
+				return nil, err
+			}
+			_, err = tx.Delete(&srv)
 			if err != nil {
 				return nil, err
 			}
 			continue
 		}
 
-		//valid service -> check whether the existing quota values violate any constraints
-		err := checkDomainServiceConstraints(tx, cluster, domain, srv, constraints[srv.Type])
+		//valid service -> pick up resources that were moved here from a different
+		//service, then check whether the existing quota values violate any constraints
+		_, err := moveResourcesIntoService(tx, domain.ClusterID, "domain", domain.ID, domain.Name, srv.ID, srv.Type, now)
+		if err != nil {
+			return nil, err
+		}
+		err = checkDomainServiceConstraints(tx, cluster, domain, srv, constraints[srv.Type], now)
 		if err != nil {
 			return nil, err
 		}
@@ -81,7 +95,14 @@ func ValidateDomainServices(tx *gorp.Transaction, cluster *core.Cluster, domain
 		}
 		services = append(services, srv)
 
-		err = createMissingDomainResources(tx, cluster, domain, srv, constraints[serviceType], nil)
+		movedResources, err := moveResourcesIntoService(tx, domain.ClusterID, "domain", domain.ID, domain.Name, srv.ID, serviceType, now)
+		if err != nil {
+			return nil, err
+		}
+		//NOTE: Initializing quotas for a new service does not count as a quota
+		//change since the new service only appears together with a change in the
+		//configuration (or with a new domain), which changes the ETag anyway.
+		_, err = createMissingDomainResources(tx, cluster, domain, srv, constraints[serviceType], movedResources)
 		if err != nil {
 			return nil, err
 		}
@@ -90,7 +111,7 @@ func ValidateDomainServices(tx *gorp.Transaction, cluster *core.Cluster, domain
 	return services, nil
 }
 
-func checkDomainServiceConstraints(tx *gorp.Transaction, cluster *core.Cluster, domain db.Domain, srv db.DomainService, serviceConstraints map[string]core.QuotaConstraint) error {
+func checkDomainServiceConstraints(tx *gorp.Transaction, cluster *core.Cluster, domain db.Domain, srv db.DomainService, serviceConstraints map[string]core.QuotaConstraint, now time.Time) error {
 	//do not hit the database if there are no constraints to check
 	if len(serviceConstraints) == 0 {
 		return nil
@@ -106,6 +127,7 @@ func checkDomainServiceConstraints(tx *gorp.Transaction, cluster *core.Cluster,
 	//check existing domain_resources for any quota values that violate constraints
 	seen := make(map[string]bool)
 	var resourcesToUpdate []interface{}
+	deltas := make(ClusterAggregateDeltas)
 	for _, res := range resources {
 		seen[res.Name] = true
 
@@ -124,8 +146,10 @@ func checkDomainServiceConstraints(tx *gorp.Transaction, cluster *core.Cluster,
 			//contain only identical pointers)
 			res := res
 
+			before := res
 			res.Quota = newQuota
 			resourcesToUpdate = append(resourcesToUpdate, &res)
+			deltas.Add(res.Name, DomainResourceDelta(&before, &res))
 		}
 	}
 	if len(resourcesToUpdate) > 0 {
@@ -136,16 +160,29 @@ func checkDomainServiceConstraints(tx *gorp.Transaction, cluster *core.Cluster,
 		if err != nil {
 			return err
 		}
+		err = ApplyClusterAggregateDeltas(tx, domain.ClusterID, srv.Type, deltas)
+		if err != nil {
+			return err
+		}
 	}
 
 	//create any missing domain resources where there are "at least/exactly/should be" constraints
-	return createMissingDomainResources(tx, cluster, domain, srv, serviceConstraints, seen)
+	created, err := createMissingDomainResources(tx, cluster, domain, srv, serviceConstraints, seen)
+	if err != nil {
+		return err
+	}
+
+	if len(resourcesToUpdate) > 0 || created {
+		return updateQuotaChangedAt(tx, "domain", domain.ID, now)
+	}
+	return nil
 }
 
-func createMissingDomainResources(tx *gorp.Transaction, cluster *core.Cluster, domain db.Domain, srv db.DomainService, serviceConstraints map[string]core.QuotaConstraint, resourceExists map[string]bool) error {
+//createMissingDomainResources returns whether any domain resources were created.
+func createMissingDomainResources(tx *gorp.Transaction, cluster *core.Cluster, domain db.Domain, srv db.DomainService, serviceConstraints map[string]core.QuotaConstraint, resourceExists map[string]bool) (bool, error) {
 	//do not hit the database if there are no constraints to check
 	if len(serviceConstraints) == 0 {
-		return nil
+		return false, nil
 	}
 
 	//ensure deterministic ordering of resources (useful for tests)
@@ -158,6 +195,7 @@ func createMissingDomainResources(tx *gorp.Transaction, cluster *core.Cluster, d
 	}
 	sort.Strings(resourceNames)
 
+	deltas := make(ClusterAggregateDeltas)
 	for _, resourceName := range resourceNames {
 		resInfo := cluster.InfoForResource(srv.Type, resourceName)
 		constraint := serviceConstraints[resourceName]
@@ -168,14 +206,23 @@ func createMissingDomainResources(tx *gorp.Transaction, cluster *core.Cluster, d
 			constraint.String(),
 		)
 
-		err := tx.Insert(&db.DomainResource{
+		res := db.DomainResource{
 			ServiceID: srv.ID,
 			Name:      resourceName,
 			Quota:     newQuota,
-		})
+		}
+		err := tx.Insert(&res)
 		if err != nil {
-			return err
+			return false, err
 		}
+		deltas.Add(resourceName, DomainResourceDelta(nil, &res))
 	}
-	return nil
+	return len(resourceNames) > 0, ApplyClusterAggregateDeltas(tx, domain.ClusterID, srv.Type, deltas)
+}
+
+//updateQuotaChangedAt is used when quotas of a domain or project are changed
+//outside of the API, so that the ETags of the affected reports change.
+func updateQuotaChangedAt(tx *gorp.Transaction, scope string, scopeID int64, now time.Time) error {
+	_, err := tx.Exec(fmt.Sprintf(`UPDATE %ss SET quota_changed_at = $1 WHERE id = $2`, scope), now, scopeID)
+	return err
 }
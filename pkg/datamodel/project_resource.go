@@ -52,6 +52,7 @@ func ApplyBackendQuota(dbi db.Interface, cluster *core.Cluster, domain core.Keys
 	}
 
 	//collect desired backend quotas
+	settings := cluster.Settings()
 	var resourcesToUpdate []db.ProjectResource
 	quotaValues := make(map[string]uint64)
 	for _, res := range resources {
@@ -67,7 +68,7 @@ func ApplyBackendQuota(dbi db.Interface, cluster *core.Cluster, domain core.Keys
 
 		desiredQuota := *res.Quota
 		if project.HasBursting {
-			behavior := cluster.BehaviorForResource(serviceType, res.Name, domain.Name+"/"+project.Name)
+			behavior := settings.BehaviorForResource(serviceType, res.Name, domain.Name+"/"+project.Name)
 			desiredQuota = behavior.MaxBurstMultiplier.ApplyTo(*res.Quota)
 		}
 		quotaValues[res.Name] = desiredQuota
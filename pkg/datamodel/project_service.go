@@ -21,6 +21,7 @@ package datamodel
 
 import (
 	"sort"
+	"time"
 
 	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/limes/pkg/core"
@@ -32,8 +33,9 @@ import (
 //this project exist (and none other). It also marks all services as stale
 //where quota values contradict the project's quota constraints.
 //
-//It returns the full set of project services.
-func ValidateProjectServices(tx *gorp.Transaction, cluster *core.Cluster, domain db.Domain, project db.Project) ([]db.ProjectService, error) {
+//It returns the full set of project services. If quotas are moved between
+//services, the project's quota_changed_at is set to `now`.
+func ValidateProjectServices(tx *gorp.Transaction, cluster *core.Cluster, domain db.Domain, project db.Project, now time.Time) ([]db.ProjectService, error) {
 	//list existing records
 	seen := make(map[string]bool)
 	var services []db.ProjectService
@@ -43,9 +45,10 @@ func ValidateProjectServices(tx *gorp.Transaction, cluster *core.Cluster, domain
 		return nil, err
 	}
 
+	settings := cluster.Settings()
 	var constraints core.QuotaConstraints
-	if cluster.QuotaConstraints != nil {
-		constraints = cluster.QuotaConstraints.Projects[domain.Name][project.Name]
+	if settings.QuotaConstraints != nil {
+		constraints = settings.QuotaConstraints.Projects[domain.Name][project.Name]
 	}
 
 	for _, srv := range services {
@@ -53,14 +56,23 @@ func ValidateProjectServices(tx *gorp.Transaction, cluster *core.Cluster, domain
 		seen[srv.Type] = true
 		if !cluster.HasService(srv.Type) {
 			logg.Info("cleaning up %s service entry for project %s/%s", srv.Type, domain.Name, project.Name)
-			_, err := tx.Delete(&srv)
+			err := subtractProjectServiceFromClusterAggregates(tx, domain, srv)
+			if err != nil {
+				return nil, err
+			}
+			_, err = tx.Delete(&srv)
 			if err != nil {
 				return nil, err
 			}
 			continue
 		}
 
-		//valid service -> check whether the existing quota values violate any constraints
+		//valid service -> pick up resources that were moved here from a different
+		//service, then check whether the existing quota values violate any constraints
+		_, err := moveResourcesIntoService(tx, domain.ClusterID, "project", project.ID, domain.Name+"/"+project.Name, srv.ID, srv.Type, now)
+		if err != nil {
+			return nil, err
+		}
 		compliant, err := checkProjectResourcesAgainstConstraint(tx, cluster, domain, project, srv, constraints[srv.Type])
 		if err != nil {
 			return nil, err
@@ -97,17 +109,23 @@ func ValidateProjectServices(tx *gorp.Transaction, cluster *core.Cluster, domain
 		}
 		services = append(services, srv)
 
+		movedResources, err := moveResourcesIntoService(tx, domain.ClusterID, "project", project.ID, domain.Name+"/"+project.Name, srv.ID, serviceType, now)
+		if err != nil {
+			return nil, err
+		}
+
 		//initialize project quotas from constraints, if there is one
 		if serviceConstraints, exists := constraints[serviceType]; exists {
 			//ensure deterministic ordering of resources (useful for tests)
 			resourceNames := make([]string, 0, len(serviceConstraints))
 			for resourceName, constraint := range serviceConstraints {
-				if constraint.Minimum != nil {
+				if constraint.Minimum != nil && !movedResources[resourceName] {
 					resourceNames = append(resourceNames, resourceName)
 				}
 			}
 			sort.Strings(resourceNames)
 
+			deltas := make(ClusterAggregateDeltas)
 			for _, resourceName := range resourceNames {
 				res := db.ProjectResource{
 					ServiceID: srv.ID,
@@ -123,7 +141,7 @@ func ValidateProjectServices(tx *gorp.Transaction, cluster *core.Cluster, domain
 					res.Quota = &zeroQuota
 				}
 				if project.HasBursting {
-					behavior := cluster.BehaviorForResource(serviceType, resourceName, domain.Name+"/"+project.Name)
+					behavior := settings.BehaviorForResource(serviceType, resourceName, domain.Name+"/"+project.Name)
 					desiredBackendQuota := behavior.MaxBurstMultiplier.ApplyTo(*res.Quota)
 					res.DesiredBackendQuota = &desiredBackendQuota
 				} else {
@@ -134,6 +152,11 @@ func ValidateProjectServices(tx *gorp.Transaction, cluster *core.Cluster, domain
 				if err != nil {
 					return nil, err
 				}
+				deltas.Add(resourceName, ProjectResourceDelta(nil, &res))
+			}
+			err = ApplyClusterAggregateDeltas(tx, domain.ClusterID, serviceType, deltas)
+			if err != nil {
+				return nil, err
 			}
 		}
 	}
@@ -0,0 +1,141 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package datamodel
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/limes/pkg/core"
+	"github.com/sapcc/limes/pkg/db"
+	gorp "gopkg.in/gorp.v2"
+)
+
+//Records in the new service that were created with zero quota (e.g. by
+//Scrape() before the old records could be moved) do not contain any
+//information of value, so they are replaced by the old records. This is a
+//no-op when the resource does not exist in the old service.
+var deleteEmptyMoveTargetQuery = `
+	DELETE FROM %[1]s_resources
+	 WHERE service_id = $1 AND name = $2 AND COALESCE(quota, 0) = 0
+	   AND EXISTS (SELECT 1 FROM %[1]s_resources WHERE name = $2 AND service_id = (SELECT id FROM %[1]s_services WHERE %[1]s_id = $3 AND type = $4))
+	RETURNING *
+`
+
+//This is a no-op when the resource does not exist in the old service, or
+//when it already exists in the new service, so it can be run repeatedly
+//without changing or losing any data.
+var moveResourceQuery = `
+	UPDATE %[1]s_resources SET service_id = $1
+	 WHERE name = $2 AND service_id = (SELECT id FROM %[1]s_services WHERE %[1]s_id = $3 AND type = $4)
+	   AND NOT EXISTS (SELECT 1 FROM %[1]s_resources WHERE service_id = $1 AND name = $2)
+	RETURNING *
+`
+
+//moveResourcesIntoService is called by the consistency check for each domain
+//or project service. If resources of this service type were previously
+//reported by a different service type, their existing records (and thus their
+//quotas) are moved into this service. The names of all moved resources are
+//returned, and if any were moved, the domain's or project's quota_changed_at
+//is set to `now`.
+//
+//Since this runs on every consistency check, records that could not be moved
+//before (e.g. because the move was interrupted, or because the new service
+//already had an empty record for the same resource) are moved eventually.
+//Records in the new service that have a non-zero quota are never overwritten.
+//
+//This is done here instead of in a schema migration because it depends on
+//the configuration: The new service type only gets service records when it
+//is configured, and service records for unconfigured service types would be
+//cleaned up by the consistency check (thus losing the quota values).
+func moveResourcesIntoService(tx *gorp.Transaction, clusterID, scope string, scopeID int64, scopeName string, serviceID int64, serviceType string, now time.Time) (map[string]bool, error) {
+	deleteQuery := db.SimplifyWhitespaceInSQL(fmt.Sprintf(deleteEmptyMoveTargetQuery, scope))
+	moveQuery := db.SimplifyWhitespaceInSQL(fmt.Sprintf(moveResourceQuery, scope))
+	moved := make(map[string]bool)
+	for _, move := range core.ResourceMoves {
+		if move.ToServiceType != serviceType {
+			continue
+		}
+		fromDeltas := make(ClusterAggregateDeltas)
+		toDeltas := make(ClusterAggregateDeltas)
+		for _, resourceName := range move.ResourceNames {
+			deletedDelta, _, err := moveResource(tx, scope, deleteQuery, serviceID, resourceName, scopeID, move.FromServiceType)
+			if err != nil {
+				return nil, err
+			}
+			delta, exists, err := moveResource(tx, scope, moveQuery, serviceID, resourceName, scopeID, move.FromServiceType)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				logg.Info("moved %s/%s into %s/%s for %s %s",
+					move.FromServiceType, resourceName, serviceType, resourceName, scope, scopeName)
+				moved[resourceName] = true
+				fromDeltas.Add(resourceName, ClusterAggregateDelta{}.sub(delta))
+				toDeltas.Add(resourceName, delta.sub(deletedDelta))
+			}
+		}
+
+		//the moved records now count towards the new service type
+		err := ApplyClusterAggregateDeltas(tx, clusterID, move.FromServiceType, fromDeltas)
+		if err != nil {
+			return nil, err
+		}
+		err = ApplyClusterAggregateDeltas(tx, clusterID, serviceType, toDeltas)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(moved) > 0 {
+		err := updateQuotaChangedAt(tx, scope, scopeID, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return moved, nil
+}
+
+//Executes moveResourceQuery or deleteEmptyMoveTargetQuery for a single
+//resource. If a record was moved or deleted, its contribution to the cluster
+//aggregates is returned.
+func moveResource(tx *gorp.Transaction, scope, query string, args ...interface{}) (delta ClusterAggregateDelta, moved bool, err error) {
+	switch scope {
+	case "domain":
+		var resources []db.DomainResource
+		_, err = tx.Select(&resources, query, args...)
+		for _, res := range resources {
+			res := res
+			delta = delta.add(DomainResourceDelta(nil, &res))
+		}
+		return delta, len(resources) > 0, err
+	case "project":
+		var resources []db.ProjectResource
+		_, err = tx.Select(&resources, query, args...)
+		for _, res := range resources {
+			res := res
+			delta = delta.add(ProjectResourceDelta(nil, &res))
+		}
+		return delta, len(resources) > 0, err
+	default:
+		return delta, false, fmt.Errorf("unknown scope: %q", scope)
+	}
+}
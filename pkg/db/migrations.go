@@ -245,4 +245,34 @@ var SQLMigrations = map[string]string{
 		  PRIMARY KEY (cluster_id, capacitor_id)
 		);
 	`,
+	"019_add_quota_changed_at.down.sql": `
+		ALTER TABLE domains DROP COLUMN quota_changed_at;
+		ALTER TABLE projects DROP COLUMN quota_changed_at;
+	`,
+	"019_add_quota_changed_at.up.sql": `
+		ALTER TABLE domains ADD COLUMN quota_changed_at TIMESTAMP; -- defaults to NULL to indicate that quota was never changed through the API
+		ALTER TABLE projects ADD COLUMN quota_changed_at TIMESTAMP; -- same
+	`,
+	"020_add_cluster_aggregates.down.sql": `
+		DROP TABLE cluster_aggregates;
+	`,
+	"020_add_cluster_aggregates.up.sql": `
+		CREATE TABLE cluster_aggregates (
+		  cluster_id     TEXT   NOT NULL,
+		  service_type   TEXT   NOT NULL,
+		  resource_name  TEXT   NOT NULL,
+		  domains_quota  BIGINT NOT NULL DEFAULT 0,
+		  projects_quota BIGINT NOT NULL DEFAULT 0,
+		  usage          BIGINT NOT NULL DEFAULT 0,
+		  burst_usage    BIGINT NOT NULL DEFAULT 0,
+		  PRIMARY KEY (cluster_id, service_type, resource_name)
+		);
+	`,
+	"021_add_cluster_aggregates_projects_with_quota.down.sql": `
+		ALTER TABLE cluster_aggregates DROP COLUMN projects_with_quota;
+	`,
+	"021_add_cluster_aggregates_projects_with_quota.up.sql": `
+		ALTER TABLE cluster_aggregates ADD COLUMN projects_with_quota BIGINT NOT NULL DEFAULT 0;
+		DELETE FROM cluster_aggregates;
+	`,
 }
@@ -34,6 +34,20 @@ type ClusterCapacitor struct {
 	SerializedMetrics  string     `db:"serialized_metrics"`
 }
 
+//ClusterAggregate contains a record from the `cluster_aggregates` table.
+//These records cache sums over the `domain_resources` and `project_resources`
+//of a cluster; see package datamodel for how they are maintained.
+type ClusterAggregate struct {
+	ClusterID         string `db:"cluster_id"`
+	ServiceType       string `db:"service_type"`
+	ResourceName      string `db:"resource_name"`
+	DomainsQuota      int64  `db:"domains_quota"`
+	ProjectsQuota     int64  `db:"projects_quota"`
+	ProjectsWithQuota int64  `db:"projects_with_quota"`
+	Usage             int64  `db:"usage"`
+	BurstUsage        int64  `db:"burst_usage"`
+}
+
 //ClusterService contains a record from the `cluster_services` table.
 type ClusterService struct {
 	ID        int64      `db:"id"`
@@ -53,10 +67,11 @@ type ClusterResource struct {
 
 //Domain contains a record from the `domains` table.
 type Domain struct {
-	ID        int64  `db:"id"`
-	ClusterID string `db:"cluster_id"`
-	Name      string `db:"name"`
-	UUID      string `db:"uuid"`
+	ID             int64      `db:"id"`
+	ClusterID      string     `db:"cluster_id"`
+	Name           string     `db:"name"`
+	UUID           string     `db:"uuid"`
+	QuotaChangedAt *time.Time `db:"quota_changed_at"` //pointer type to allow for NULL value
 }
 
 //DomainService contains a record from the `domain_services` table.
@@ -75,12 +90,13 @@ type DomainResource struct {
 
 //Project contains a record from the `projects` table.
 type Project struct {
-	ID          int64  `db:"id"`
-	DomainID    int64  `db:"domain_id"`
-	Name        string `db:"name"`
-	UUID        string `db:"uuid"`
-	ParentUUID  string `db:"parent_uuid"`
-	HasBursting bool   `db:"has_bursting"`
+	ID             int64      `db:"id"`
+	DomainID       int64      `db:"domain_id"`
+	Name           string     `db:"name"`
+	UUID           string     `db:"uuid"`
+	ParentUUID     string     `db:"parent_uuid"`
+	HasBursting    bool       `db:"has_bursting"`
+	QuotaChangedAt *time.Time `db:"quota_changed_at"` //pointer type to allow for NULL value
 }
 
 //ProjectService contains a record from the `project_services` table.
@@ -127,6 +143,7 @@ type ProjectRate struct {
 //It's available as an exported function because the unit tests need to call
 //this while bypassing the normal Init() logic.
 func InitGorp() {
+	DB.AddTableWithName(ClusterAggregate{}, "cluster_aggregates").SetKeys(false, "cluster_id", "service_type", "resource_name")
 	DB.AddTableWithName(ClusterCapacitor{}, "cluster_capacitors").SetKeys(false, "cluster_id", "capacitor_id")
 	DB.AddTableWithName(ClusterService{}, "cluster_services").SetKeys(true, "id")
 	DB.AddTableWithName(ClusterResource{}, "cluster_resources").SetKeys(false, "service_id", "name")
@@ -0,0 +1,387 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+type barbicanPlugin struct {
+	cfg core.ServiceConfiguration
+	//project-scoped tokens are cached since creating a new token for each
+	//project on each scrape would put unnecessary load on Keystone
+	projectTokens      map[string]*tokens.Token
+	projectTokensMutex sync.Mutex
+}
+
+var barbicanResources = []limes.ResourceInfo{
+	{
+		Name:     "consumers",
+		Unit:     limes.UnitNone,
+		Category: "secrets",
+	},
+	{
+		Name:     "containers",
+		Unit:     limes.UnitNone,
+		Category: "secrets",
+	},
+	{
+		Name:     "orders",
+		Unit:     limes.UnitNone,
+		Category: "certificates",
+	},
+	{
+		Name:     "secrets",
+		Unit:     limes.UnitNone,
+		Category: "secrets",
+	},
+}
+
+func init() {
+	core.RegisterQuotaPlugin(func(c core.ServiceConfiguration, scrapeSubresources map[string]bool) core.QuotaPlugin {
+		return &barbicanPlugin{cfg: c, projectTokens: make(map[string]*tokens.Token)}
+	})
+}
+
+//Init implements the core.QuotaPlugin interface.
+func (p *barbicanPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
+	return nil
+}
+
+//ServiceInfo implements the core.QuotaPlugin interface.
+func (p *barbicanPlugin) ServiceInfo() limes.ServiceInfo {
+	return limes.ServiceInfo{
+		Type:        "key-manager",
+		ProductName: "barbican",
+		Area:        "security",
+	}
+}
+
+//Resources implements the core.QuotaPlugin interface.
+func (p *barbicanPlugin) Resources() []limes.ResourceInfo {
+	return barbicanResources
+}
+
+//Rates implements the core.QuotaPlugin interface.
+func (p *barbicanPlugin) Rates() []limes.RateInfo {
+	return nil
+}
+
+//ScrapeRates implements the core.QuotaPlugin interface.
+func (p *barbicanPlugin) ScrapeRates(client *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, prevSerializedState string) (result map[string]*big.Int, serializedState string, err error) {
+	return nil, "", nil
+}
+
+//Scrape implements the core.QuotaPlugin interface.
+func (p *barbicanPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string) (map[string]core.ResourceData, string, error) {
+	client, err := newBarbicanClient(provider, eo)
+	if err != nil {
+		return nil, "", err
+	}
+
+	quotas, err := client.GetProjectQuota(projectUUID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//Barbican's list endpoints only show data for the token's project (and
+	//cannot be filtered by project), so we need a token for the target project
+	projectClient, err := p.projectClient(client, projectUUID)
+	if err != nil {
+		return nil, "", err
+	}
+	result, err := scrapeBarbicanUsage(projectClient, quotas)
+	if err != nil {
+		//the cached token might have been revoked, so get a new one next time
+		p.projectTokensMutex.Lock()
+		delete(p.projectTokens, projectUUID)
+		p.projectTokensMutex.Unlock()
+		return nil, "", err
+	}
+	return result, "", nil
+}
+
+func scrapeBarbicanUsage(projectClient *barbicanClient, quotas map[string]int64) (map[string]core.ResourceData, error) {
+	result := make(map[string]core.ResourceData, len(barbicanResources))
+	for _, resourceName := range []string{"containers", "orders", "secrets"} {
+		count, err := projectClient.CountItems(projectClient.ServiceURL("v1", resourceName))
+		if err != nil {
+			return nil, err
+		}
+		result[resourceName] = core.ResourceData{Quota: quotas[resourceName], Usage: count}
+	}
+	consumerCount, err := projectClient.CountConsumers()
+	if err != nil {
+		return nil, err
+	}
+	result["consumers"] = core.ResourceData{Quota: quotas["consumers"], Usage: consumerCount}
+	return result, nil
+}
+
+//projectClient returns a client that uses a token scoped to the given project,
+//reusing a cached token unless it is about to expire.
+func (p *barbicanPlugin) projectClient(client *barbicanClient, projectUUID string) (*barbicanClient, error) {
+	p.projectTokensMutex.Lock()
+	token := p.projectTokens[projectUUID]
+	p.projectTokensMutex.Unlock()
+
+	if token == nil || time.Until(token.ExpiresAt) < 5*time.Minute {
+		var err error
+		token, err = client.CreateProjectToken(projectUUID)
+		if err != nil {
+			return nil, err
+		}
+		p.projectTokensMutex.Lock()
+		p.projectTokens[projectUUID] = token
+		p.projectTokensMutex.Unlock()
+	}
+	return client.WithToken(token.ID), nil
+}
+
+//SetQuota implements the core.QuotaPlugin interface.
+func (p *barbicanPlugin) SetQuota(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, quotas map[string]uint64) error {
+	client, err := newBarbicanClient(provider, eo)
+	if err != nil {
+		return err
+	}
+
+	quotasToSet := make(map[string]int64, len(barbicanResources))
+	for _, res := range barbicanResources {
+		quota, exists := quotas[res.Name]
+		if exists {
+			quotasToSet[res.Name] = int64(quota)
+		}
+	}
+	return client.SetQuota(projectUUID, quotasToSet)
+}
+
+//DescribeMetrics implements the core.QuotaPlugin interface.
+func (p *barbicanPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	//not used by this plugin
+}
+
+//CollectMetrics implements the core.QuotaPlugin interface.
+func (p *barbicanPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, domainUUID, projectUUID, serializedMetrics string) error {
+	//not used by this plugin
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Gophercloud client for Barbican
+
+type barbicanClient struct {
+	*gophercloud.ServiceClient
+	eo gophercloud.EndpointOpts
+}
+
+func newBarbicanClient(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) (*barbicanClient, error) {
+	serviceType := "key-manager"
+	eoBarbican := eo
+	eoBarbican.ApplyDefaults(serviceType)
+
+	url, err := provider.EndpointLocator(eoBarbican)
+	if err != nil {
+		return nil, err
+	}
+	return &barbicanClient{
+		ServiceClient: &gophercloud.ServiceClient{
+			ProviderClient: provider,
+			Endpoint:       url,
+			Type:           serviceType,
+		},
+		eo: eo,
+	}, nil
+}
+
+//CreateProjectToken creates a token scoped to the given project. The Limes
+//service user needs a role assignment in the project for this to work.
+func (c barbicanClient) CreateProjectToken(projectUUID string) (*tokens.Token, error) {
+	identityClient, err := openstack.NewIdentityV3(c.ProviderClient, c.eo)
+	if err != nil {
+		return nil, err
+	}
+	authOpts := tokens.AuthOptions{
+		TokenID: c.ProviderClient.Token(),
+		Scope:   tokens.Scope{ProjectID: projectUUID},
+	}
+	return tokens.Create(identityClient, &authOpts).ExtractToken()
+}
+
+//WithToken returns a client for the same endpoint that uses the given token.
+func (c barbicanClient) WithToken(tokenID string) *barbicanClient {
+	provider := &gophercloud.ProviderClient{
+		IdentityBase:     c.ProviderClient.IdentityBase,
+		IdentityEndpoint: c.ProviderClient.IdentityEndpoint,
+		HTTPClient:       c.ProviderClient.HTTPClient,
+		UserAgent:        c.ProviderClient.UserAgent,
+	}
+	provider.SetToken(tokenID)
+	return &barbicanClient{
+		ServiceClient: &gophercloud.ServiceClient{
+			ProviderClient: provider,
+			Endpoint:       c.Endpoint,
+			Type:           c.Type,
+		},
+		eo: c.eo,
+	}
+}
+
+//GetProjectQuota returns the quotas for the given project from Barbican's
+//project quotas API. For resources without a project-specific quota, the
+//default quota is reported, as obtained from GetEffectiveQuota().
+func (c barbicanClient) GetProjectQuota(projectUUID string) (map[string]int64, error) {
+	url := c.ServiceURL("v1", "project-quotas", projectUUID)
+
+	var result gophercloud.Result
+	_, result.Err = c.Get(url, &result.Body, &gophercloud.RequestOpts{
+		OkCodes: []int{http.StatusOK},
+	})
+	var data struct {
+		ProjectQuotas map[string]*int64 `json:"project_quotas"`
+	}
+	//404 means that the project does not have project-specific quotas
+	if _, ok := result.Err.(gophercloud.ErrDefault404); !ok {
+		err := result.ExtractInto(&data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	quotas := make(map[string]int64, len(barbicanResources))
+	var defaultQuotas map[string]int64
+	for _, res := range barbicanResources {
+		value := data.ProjectQuotas[res.Name]
+		if value != nil {
+			quotas[res.Name] = *value
+			continue
+		}
+		if defaultQuotas == nil {
+			var err error
+			defaultQuotas, err = c.GetEffectiveQuota()
+			if err != nil {
+				return nil, err
+			}
+		}
+		quotas[res.Name] = defaultQuotas[res.Name]
+	}
+	return quotas, nil
+}
+
+//GetEffectiveQuota returns the quotas that are in effect for the token's
+//project. Unlike the project quotas API, this takes Barbican's default quotas
+//into account for projects that do not have project-specific quotas.
+func (c barbicanClient) GetEffectiveQuota() (map[string]int64, error) {
+	url := c.ServiceURL("v1", "quotas")
+
+	var result gophercloud.Result
+	_, result.Err = c.Get(url, &result.Body, &gophercloud.RequestOpts{
+		OkCodes: []int{http.StatusOK},
+	})
+	var data struct {
+		Quotas map[string]*int64 `json:"quotas"`
+	}
+	err := result.ExtractInto(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := make(map[string]int64, len(barbicanResources))
+	for _, res := range barbicanResources {
+		value := data.Quotas[res.Name]
+		if value == nil {
+			return nil, fmt.Errorf("effective quota for %s is missing in Barbican response", res.Name)
+		}
+		quotas[res.Name] = *value
+	}
+	return quotas, nil
+}
+
+func (c barbicanClient) SetQuota(projectUUID string, quotas map[string]int64) error {
+	url := c.ServiceURL("v1", "project-quotas", projectUUID)
+	body := map[string]interface{}{"project_quotas": quotas}
+	_, err := c.Put(url, body, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{http.StatusNoContent},
+	})
+	return err
+}
+
+//CountItems uses the `total` field of Barbican's list endpoints to count the
+//items in a collection without listing all of them.
+func (c barbicanClient) CountItems(collectionURL string) (uint64, error) {
+	var data struct {
+		Total uint64 `json:"total"`
+	}
+	err := c.getList(collectionURL, 0, 1, &data)
+	return data.Total, err
+}
+
+//CountConsumers counts the consumers of all containers in the token's
+//project. (Consumers are not a top-level collection in Barbican's API.)
+func (c barbicanClient) CountConsumers() (uint64, error) {
+	const pageSize = 100
+	var count uint64
+	for offset := 0; ; offset += pageSize {
+		var data struct {
+			Containers []struct {
+				ContainerRef string `json:"container_ref"`
+			} `json:"containers"`
+			Total int `json:"total"`
+		}
+		err := c.getList(c.ServiceURL("v1", "containers"), offset, pageSize, &data)
+		if err != nil {
+			return 0, err
+		}
+		for _, container := range data.Containers {
+			consumerCount, err := c.CountItems(container.ContainerRef + "/consumers")
+			if err != nil {
+				return 0, err
+			}
+			count += consumerCount
+		}
+		if len(data.Containers) < pageSize || offset+pageSize >= data.Total {
+			return count, nil
+		}
+	}
+}
+
+func (c barbicanClient) getList(collectionURL string, offset, limit int, data interface{}) error {
+	query := url.Values{
+		"offset": {strconv.Itoa(offset)},
+		"limit":  {strconv.Itoa(limit)},
+	}
+	var result gophercloud.Result
+	_, result.Err = c.Get(collectionURL+"?"+query.Encode(), &result.Body, &gophercloud.RequestOpts{
+		OkCodes: []int{http.StatusOK},
+	})
+	return result.ExtractInto(data)
+}
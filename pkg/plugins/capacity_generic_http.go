@@ -0,0 +1,164 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+type capacityGenericHTTPPlugin struct {
+	cfg                 core.CapacitorConfiguration
+	scrapeSubcapacities map[string]map[string]bool
+	httpClient          *http.Client
+}
+
+func init() {
+	core.RegisterCapacityPlugin(func(c core.CapacitorConfiguration, scrapeSubcapacities map[string]map[string]bool) core.CapacityPlugin {
+		return &capacityGenericHTTPPlugin{cfg: c, scrapeSubcapacities: scrapeSubcapacities}
+	})
+}
+
+//Init implements the core.CapacityPlugin interface.
+func (p *capacityGenericHTTPPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
+	cfg := p.cfg.GenericHTTP.APIConfig
+	if cfg.URL == "" {
+		return errors.New("missing configuration parameter: url")
+	}
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	p.httpClient = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	return nil
+}
+
+//ID implements the core.CapacityPlugin interface.
+func (p *capacityGenericHTTPPlugin) ID() string {
+	return "generic-http"
+}
+
+type capacityGenericHTTPData struct {
+	Capacity uint64 `json:"capacity"`
+	PerAZ    map[string]struct {
+		Capacity uint64 `json:"capacity"`
+		Usage    uint64 `json:"usage"`
+	} `json:"per_az"`
+	Subcapacities []interface{} `json:"subcapacities"`
+}
+
+//Scrape implements the core.CapacityPlugin interface.
+func (p *capacityGenericHTTPPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) (map[string]map[string]core.CapacityData, string, error) {
+	var data map[string]map[string]capacityGenericHTTPData
+	err := p.getJSON(provider, &data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := make(map[string]map[string]core.CapacityData, len(data))
+	for serviceType, serviceData := range data {
+		serviceResult := make(map[string]core.CapacityData, len(serviceData))
+		for resourceName, resData := range serviceData {
+			capaData := core.CapacityData{Capacity: resData.Capacity}
+			if len(resData.PerAZ) > 0 {
+				capaData.CapacityPerAZ = make(map[string]*core.CapacityDataForAZ, len(resData.PerAZ))
+				for az, azData := range resData.PerAZ {
+					capaData.CapacityPerAZ[az] = &core.CapacityDataForAZ{
+						Capacity: azData.Capacity,
+						Usage:    azData.Usage,
+					}
+				}
+			}
+			if p.scrapeSubcapacities[serviceType][resourceName] {
+				capaData.Subcapacities = resData.Subcapacities
+			}
+			serviceResult[resourceName] = capaData
+		}
+		result[serviceType] = serviceResult
+	}
+	return result, "", nil
+}
+
+//getJSON retrieves the capacity data from the configured URL. If Keystone auth
+//is enabled and the token has expired, the request is retried once after
+//reauthenticating.
+func (p *capacityGenericHTTPPlugin) getJSON(provider *gophercloud.ProviderClient, data interface{}) error {
+	url := p.cfg.GenericHTTP.APIConfig.URL
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		token := ""
+		if p.cfg.GenericHTTP.KeystoneAuth {
+			token = provider.Token()
+			req.Header.Set("X-Auth-Token", token)
+		}
+
+		resp, err := p.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("GET %s failed: %s", url, err.Error())
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("GET %s failed: %s", url, err.Error())
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && p.cfg.GenericHTTP.KeystoneAuth && attempt == 1 {
+			err := provider.Reauthenticate(token)
+			if err != nil {
+				return fmt.Errorf("GET %s failed: cannot reauthenticate: %s", url, err.Error())
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET %s failed: expected 200, got %d: %s", url, resp.StatusCode, string(body))
+		}
+
+		err = json.Unmarshal(body, data)
+		if err != nil {
+			return fmt.Errorf("GET %s returned invalid JSON: %s", url, err.Error())
+		}
+		return nil
+	}
+}
+
+//DescribeMetrics implements the core.CapacityPlugin interface.
+func (p *capacityGenericHTTPPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	//not used by this plugin
+}
+
+//CollectMetrics implements the core.CapacityPlugin interface.
+func (p *capacityGenericHTTPPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, serializedMetrics string) error {
+	//not used by this plugin
+	return nil
+}
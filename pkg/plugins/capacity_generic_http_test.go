@@ -0,0 +1,106 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+func TestCapacityGenericHTTPPlugin(t *testing.T) {
+	validToken := "first-token"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Auth-Token") != validToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"shared": map[string]interface{}{
+				"things": map[string]interface{}{
+					"capacity": 42,
+					"per_az": map[string]interface{}{
+						"az-one": map[string]interface{}{"capacity": 20, "usage": 5},
+						"az-two": map[string]interface{}{"capacity": 22, "usage": 7},
+					},
+					"subcapacities": []interface{}{
+						map[string]interface{}{"name": "pool-one", "capacity": 42},
+					},
+				},
+				"capacity": map[string]interface{}{"capacity": 1024},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	//the provider client reauthenticates by switching to the valid token
+	provider := &gophercloud.ProviderClient{}
+	provider.SetToken("expired-token")
+	provider.ReauthFunc = func() error {
+		provider.SetToken(validToken)
+		return nil
+	}
+
+	cfg := core.CapacitorConfiguration{ID: "generic-http"}
+	cfg.GenericHTTP.APIConfig.URL = srv.URL + "/capacity"
+	cfg.GenericHTTP.KeystoneAuth = true
+	plugin := &capacityGenericHTTPPlugin{
+		cfg:                 cfg,
+		scrapeSubcapacities: map[string]map[string]bool{"shared": {"things": true}},
+	}
+	err := plugin.Init(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, _, err := plugin.Scrape(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]core.CapacityData{
+		"shared": {
+			"things": {
+				Capacity: 42,
+				CapacityPerAZ: map[string]*core.CapacityDataForAZ{
+					"az-one": {Capacity: 20, Usage: 5},
+					"az-two": {Capacity: 22, Usage: 7},
+				},
+				Subcapacities: []interface{}{
+					map[string]interface{}{"name": "pool-one", "capacity": 42.0},
+				},
+			},
+			"capacity": {Capacity: 1024},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, result)
+	}
+
+	//without Keystone auth, the request fails
+	plugin.cfg.GenericHTTP.KeystoneAuth = false
+	_, _, err = plugin.Scrape(provider, gophercloud.EndpointOpts{})
+	if err == nil {
+		t.Error("expected Scrape to fail without token, but got no error")
+	}
+}
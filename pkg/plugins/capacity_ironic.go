@@ -0,0 +1,331 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/hypervisors"
+	flavorsmodule "github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/pagination"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+type capacityIronicPlugin struct {
+	cfg                 core.CapacitorConfiguration
+	ftt                 novaFlavorTranslationTable
+	scrapeSubcapacities map[string]bool
+}
+
+//ironicNodeCounts is used in the serialized metrics of capacityIronicPlugin.
+type ironicNodeCounts struct {
+	Available   uint64 `json:"available"`
+	Provisioned uint64 `json:"provisioned"`
+	Maintenance uint64 `json:"maintenance"`
+}
+
+type capacityIronicSerializedMetrics struct {
+	//key = resource name
+	NodeCounts         map[string]*ironicNodeCounts `json:"nodes"`
+	UnmatchedNodeCount uint64                       `json:"unmatched_nodes"`
+}
+
+func init() {
+	core.RegisterCapacityPlugin(func(c core.CapacitorConfiguration, scrapeSubcapacities map[string]map[string]bool) core.CapacityPlugin {
+		ftt := newNovaFlavorTranslationTable(c.Ironic.FlavorAliases)
+		return &capacityIronicPlugin{c, ftt, scrapeSubcapacities["compute"]}
+	})
+}
+
+//Init implements the core.CapacityPlugin interface.
+func (p *capacityIronicPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
+	return nil
+}
+
+//ID implements the core.CapacityPlugin interface.
+func (p *capacityIronicPlugin) ID() string {
+	return "ironic"
+}
+
+//Scrape implements the core.CapacityPlugin interface.
+func (p *capacityIronicPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) (map[string]map[string]core.CapacityData, string, error) {
+	novaClient, err := openstack.NewComputeV2(provider, eo)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//find baremetal flavors and the resource classes that they select
+	resourceNameForResourceClass, err := p.collectBaremetalFlavors(novaClient)
+	if err != nil {
+		return nil, "", err
+	}
+	result := make(map[string]*core.CapacityData)
+	metrics := capacityIronicSerializedMetrics{
+		NodeCounts: make(map[string]*ironicNodeCounts),
+	}
+	for _, resourceName := range resourceNameForResourceClass {
+		result[resourceName] = &core.CapacityData{
+			Capacity:      0,
+			CapacityPerAZ: map[string]*core.CapacityDataForAZ{},
+		}
+		metrics.NodeCounts[resourceName] = &ironicNodeCounts{}
+	}
+
+	//Ironic nodes appear as hypervisors in Nova (with the node UUID as
+	//hypervisor hostname), so we can find the AZ of each node by looking at
+	//which compute host manages it
+	azForNodeID, err := getAZForIronicNodes(novaClient)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//count Ironic nodes
+	ironicClient, err := newIronicClient(provider, eo)
+	if err != nil {
+		return nil, "", err
+	}
+	nodes, err := ironicClient.GetNodes()
+	if err != nil {
+		return nil, "", err
+	}
+	//sort nodes by name for deterministic order of subcapacities
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	for _, node := range nodes {
+		//do not consider nodes that have not been made available for provisioning yet
+		provisionState := node.StableProvisionState()
+		if !isAvailableProvisionState[provisionState] {
+			continue
+		}
+
+		resourceName, exists := resourceNameForResourceClass[placementResourceClassForIronicNode(node)]
+		if !exists {
+			logg.Error("Ironic node %q (%s) with resource class %q does not match any baremetal flavor", node.Name, node.ID, node.ResourceClass)
+			metrics.UnmatchedNodeCount++
+			continue
+		}
+		logg.Debug("Ironic node %q (%s) matches resource %s", node.Name, node.ID, resourceName)
+
+		nodeAZ := azForNodeID[node.ID]
+		if nodeAZ == "" {
+			logg.Info("Ironic node %q (%s) does not match any compute host from host aggregates", node.Name, node.ID)
+			nodeAZ = "unknown"
+		}
+		data := result[resourceName]
+		if _, ok := data.CapacityPerAZ[nodeAZ]; !ok {
+			data.CapacityPerAZ[nodeAZ] = &core.CapacityDataForAZ{}
+		}
+
+		//nodes in maintenance cannot be provisioned, but nodes that are already
+		//provisioned still count towards capacity (since they count towards usage)
+		var state string
+		counts := metrics.NodeCounts[resourceName]
+		switch {
+		case provisionState != "available":
+			state = "provisioned"
+			counts.Provisioned++
+			data.Capacity++
+			data.CapacityPerAZ[nodeAZ].Capacity++
+			data.CapacityPerAZ[nodeAZ].Usage++
+		case node.Maintenance:
+			state = "maintenance"
+			counts.Maintenance++
+		default:
+			state = "available"
+			counts.Available++
+			data.Capacity++
+			data.CapacityPerAZ[nodeAZ].Capacity++
+		}
+
+		if p.scrapeSubcapacities[resourceName] {
+			sub := map[string]interface{}{
+				"id":          node.ID,
+				"name":        node.Name,
+				"az":          nodeAZ,
+				"state":       state,
+				"maintenance": node.Maintenance,
+			}
+			if node.InstanceID != nil && *node.InstanceID != "" {
+				sub["instance_id"] = *node.InstanceID
+			}
+			data.Subcapacities = append(data.Subcapacities, sub)
+		}
+	}
+
+	//remove pointers from `result`
+	result2 := make(map[string]core.CapacityData, len(result))
+	for resourceName, data := range result {
+		result2[resourceName] = *data
+	}
+
+	serializedMetrics, _ := json.Marshal(metrics)
+	return map[string]map[string]core.CapacityData{"compute": result2}, string(serializedMetrics), nil
+}
+
+//Returns a mapping of Placement resource class to Limes resource name for
+//all flavors that select baremetal nodes through an extra spec like
+//"resources:CUSTOM_BAREMETAL_LARGE=1".
+func (p *capacityIronicPlugin) collectBaremetalFlavors(novaClient *gophercloud.ServiceClient) (map[string]string, error) {
+	var flavors []flavorsmodule.Flavor
+	opts := flavorsmodule.ListOpts{AccessType: flavorsmodule.AllAccess}
+	err := flavorsmodule.ListDetail(novaClient, opts).EachPage(func(page pagination.Page) (bool, error) {
+		pageFlavors, err := flavorsmodule.ExtractFlavors(page)
+		if err != nil {
+			return false, err
+		}
+		flavors = append(flavors, pageFlavors...)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	for _, flavor := range flavors {
+		extraSpecs, err := getFlavorExtras(novaClient, flavor.ID)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range extraSpecs {
+			if !strings.HasPrefix(key, "resources:CUSTOM_") || value != "1" {
+				continue
+			}
+			resourceClass := strings.TrimPrefix(key, "resources:")
+			resourceName := p.ftt.LimesResourceNameForFlavor(flavor.Name)
+			if otherResourceName, exists := result[resourceClass]; exists && otherResourceName != resourceName {
+				logg.Error("resource class %s is selected by more than one flavor (%s and %s), ignoring the latter", resourceClass, otherResourceName, resourceName)
+				continue
+			}
+			result[resourceClass] = resourceName
+		}
+	}
+	return result, nil
+}
+
+func getAZForIronicNodes(novaClient *gophercloud.ServiceClient) (map[string]string, error) {
+	page, err := hypervisors.List(novaClient).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	var hypervisorData struct {
+		Hypervisors []novaHypervisor `json:"hypervisors"`
+	}
+	err = page.(hypervisors.HypervisorPage).ExtractInto(&hypervisorData)
+	if err != nil {
+		return nil, err
+	}
+
+	azs, _, err := getAggregates(novaClient)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	for _, hypervisor := range hypervisorData.Hypervisors {
+		for azName, az := range azs {
+			if az.ContainsComputeHost[hypervisor.Service.Host] {
+				result[hypervisor.HypervisorHostname] = azName
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+var nonResourceClassCharsRx = regexp.MustCompile(`[^A-Z0-9_]`)
+
+//Returns the Placement resource class that Nova derives from the resource
+//class of the given Ironic node, e.g. "baremetal.large" -> "CUSTOM_BAREMETAL_LARGE".
+func placementResourceClassForIronicNode(node ironicNode) string {
+	if node.ResourceClass == "" {
+		return ""
+	}
+	return "CUSTOM_" + nonResourceClassCharsRx.ReplaceAllString(strings.ToUpper(node.ResourceClass), "_")
+}
+
+var (
+	ironicNodesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "limes_ironic_nodes",
+			Help: "Number of Ironic nodes matching a baremetal flavor, by state (available, provisioned or maintenance).",
+		},
+		[]string{"os_cluster", "resource", "state"},
+	)
+	ironicNodesWithoutFlavorGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "limes_ironic_nodes_without_flavor",
+			Help: "Number of available/active Ironic nodes whose resource class is not selected by any baremetal flavor.",
+		},
+		[]string{"os_cluster"},
+	)
+)
+
+//DescribeMetrics implements the core.CapacityPlugin interface.
+func (p *capacityIronicPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	ironicNodesGauge.Describe(ch)
+	ironicNodesWithoutFlavorGauge.Describe(ch)
+}
+
+//CollectMetrics implements the core.CapacityPlugin interface.
+func (p *capacityIronicPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, serializedMetrics string) error {
+	if serializedMetrics == "" {
+		return nil
+	}
+	var metrics capacityIronicSerializedMetrics
+	err := json.Unmarshal([]byte(serializedMetrics), &metrics)
+	if err != nil {
+		return err
+	}
+
+	descCh := make(chan *prometheus.Desc, 1)
+	ironicNodesGauge.Describe(descCh)
+	ironicNodesDesc := <-descCh
+	ironicNodesWithoutFlavorGauge.Describe(descCh)
+	ironicNodesWithoutFlavorDesc := <-descCh
+
+	for resourceName, counts := range metrics.NodeCounts {
+		for state, count := range map[string]uint64{
+			"available":   counts.Available,
+			"provisioned": counts.Provisioned,
+			"maintenance": counts.Maintenance,
+		} {
+			ch <- prometheus.MustNewConstMetric(
+				ironicNodesDesc,
+				prometheus.GaugeValue, float64(count),
+				clusterID, resourceName, state,
+			)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(
+		ironicNodesWithoutFlavorDesc,
+		prometheus.GaugeValue, float64(metrics.UnmatchedNodeCount),
+		clusterID,
+	)
+	return nil
+}
@@ -0,0 +1,151 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+func TestCapacityIronicPlugin(t *testing.T) {
+	flavorExtraSpecs := map[string]map[string]string{
+		"1": {"resources:CUSTOM_BAREMETAL_SMALL": "1", "resources:VCPU": "0"},
+		"2": {"resources:CUSTOM_BAREMETAL_LARGE": "1", "resources:VCPU": "0"},
+		"3": {"hw:cpu_policy": "dedicated"},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/flavors/detail", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, map[string]interface{}{"flavors": []map[string]interface{}{
+			{"id": "1", "name": "bm.small", "vcpus": 8, "ram": 32768, "disk": 500},
+			{"id": "2", "name": "bm.large-v2", "vcpus": 64, "ram": 524288, "disk": 2000},
+			{"id": "3", "name": "vm.small", "vcpus": 2, "ram": 4096, "disk": 20},
+		}})
+	})
+	for flavorID, extraSpecs := range flavorExtraSpecs {
+		extraSpecs := extraSpecs
+		mux.HandleFunc("/compute/flavors/"+flavorID+"/os-extra_specs", func(w http.ResponseWriter, r *http.Request) {
+			respondJSON(w, http.StatusOK, map[string]interface{}{"extra_specs": extraSpecs})
+		})
+	}
+	mux.HandleFunc("/compute/os-hypervisors/detail", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, map[string]interface{}{"hypervisors": []map[string]interface{}{
+			{"id": 1, "hypervisor_hostname": "node-uuid-1", "service": map[string]interface{}{"id": 1, "host": "ironic-compute-a"}},
+			{"id": 2, "hypervisor_hostname": "node-uuid-2", "service": map[string]interface{}{"id": 1, "host": "ironic-compute-a"}},
+			{"id": 3, "hypervisor_hostname": "node-uuid-3", "service": map[string]interface{}{"id": 1, "host": "ironic-compute-b"}},
+			{"id": 4, "hypervisor_hostname": "node-uuid-4", "service": map[string]interface{}{"id": 1, "host": "ironic-compute-b"}},
+			{"id": 5, "hypervisor_hostname": "node-uuid-5", "service": map[string]interface{}{"id": 1, "host": "ironic-compute-b"}},
+		}})
+	})
+	mux.HandleFunc("/compute/os-aggregates", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, map[string]interface{}{"aggregates": []map[string]interface{}{
+			{"name": "az-a", "availability_zone": "az-a", "hosts": []string{"ironic-compute-a"}},
+			{"name": "az-b", "availability_zone": "az-b", "hosts": []string{"ironic-compute-b"}},
+		}})
+	})
+	mux.HandleFunc("/baremetal/nodes/detail", func(w http.ResponseWriter, r *http.Request) {
+		var nodes []json.RawMessage
+		if r.URL.Query().Get("marker") == "" {
+			for _, node := range []string{
+				`{"uuid": "node-uuid-1", "name": "node1", "provision_state": "active", "instance_uuid": "instance-uuid-1", "resource_class": "baremetal.small", "maintenance": false}`,
+				`{"uuid": "node-uuid-2", "name": "node2", "provision_state": "available", "resource_class": "baremetal.small", "maintenance": false}`,
+				`{"uuid": "node-uuid-3", "name": "node3", "provision_state": "available", "resource_class": "baremetal.small", "maintenance": true}`,
+				`{"uuid": "node-uuid-4", "name": "node4", "provision_state": "cleaning", "target_provision_state": "available", "resource_class": "baremetal-large", "maintenance": false}`,
+				`{"uuid": "node-uuid-5", "name": "node5", "provision_state": "active", "instance_uuid": "instance-uuid-5", "resource_class": "baremetal-large", "maintenance": true}`,
+				//not considered: not available for provisioning yet
+				`{"uuid": "node-uuid-6", "name": "node6", "provision_state": "manageable", "resource_class": "baremetal.small", "maintenance": false}`,
+				//unmatched: no flavor for this resource class
+				`{"uuid": "node-uuid-7", "name": "node7", "provision_state": "available", "resource_class": "baremetal.gpu", "maintenance": false}`,
+			} {
+				nodes = append(nodes, json.RawMessage(node))
+			}
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"nodes": nodes})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	provider := &gophercloud.ProviderClient{
+		EndpointLocator: func(eo gophercloud.EndpointOpts) (string, error) {
+			return srv.URL + "/" + eo.Type + "/", nil
+		},
+	}
+	cfg := core.CapacitorConfiguration{ID: "ironic"}
+	cfg.Ironic.FlavorAliases = map[string][]string{"bm.large": {"bm.large-v2"}}
+	plugin := &capacityIronicPlugin{
+		cfg:                 cfg,
+		ftt:                 newNovaFlavorTranslationTable(cfg.Ironic.FlavorAliases),
+		scrapeSubcapacities: map[string]bool{"instances_bm.large": true},
+	}
+	err := plugin.Init(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, serializedMetrics, err := plugin.Scrape(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]core.CapacityData{
+		"compute": {
+			"instances_bm.small": {
+				Capacity: 2,
+				CapacityPerAZ: map[string]*core.CapacityDataForAZ{
+					"az-a": {Capacity: 2, Usage: 1},
+					"az-b": {Capacity: 0, Usage: 0},
+				},
+			},
+			"instances_bm.large": {
+				Capacity: 2,
+				CapacityPerAZ: map[string]*core.CapacityDataForAZ{
+					"az-b": {Capacity: 2, Usage: 1},
+				},
+				Subcapacities: []interface{}{
+					map[string]interface{}{"id": "node-uuid-4", "name": "node4", "az": "az-b", "state": "available", "maintenance": false},
+					map[string]interface{}{"id": "node-uuid-5", "name": "node5", "az": "az-b", "state": "provisioned", "maintenance": true, "instance_id": "instance-uuid-5"},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, result)
+	}
+
+	var metrics capacityIronicSerializedMetrics
+	err = json.Unmarshal([]byte(serializedMetrics), &metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMetrics := capacityIronicSerializedMetrics{
+		NodeCounts: map[string]*ironicNodeCounts{
+			"instances_bm.small": {Available: 1, Provisioned: 1, Maintenance: 1},
+			"instances_bm.large": {Available: 1, Provisioned: 1, Maintenance: 0},
+		},
+		UnmatchedNodeCount: 1,
+	}
+	if !reflect.DeepEqual(metrics, expectedMetrics) {
+		t.Errorf("expected metrics %#v, but got %#v", expectedMetrics, metrics)
+	}
+}
@@ -0,0 +1,140 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+type capacityKubernetesPlugin struct {
+	cfg                 core.CapacitorConfiguration
+	scrapeSubcapacities map[string]bool
+	client              *kubernetesClient
+}
+
+//kubernetesCapacityResources maps resources of the "kubernetes" service to
+//the keys in a node's status.allocatable.
+var kubernetesCapacityResources = map[string]string{
+	"requests_cpu":               "cpu",
+	"requests_ephemeral_storage": "ephemeral-storage",
+	"requests_memory":            "memory",
+}
+
+func init() {
+	core.RegisterCapacityPlugin(func(c core.CapacitorConfiguration, scrapeSubcapacities map[string]map[string]bool) core.CapacityPlugin {
+		return &capacityKubernetesPlugin{cfg: c, scrapeSubcapacities: scrapeSubcapacities["kubernetes"]}
+	})
+}
+
+//Init implements the core.CapacityPlugin interface.
+func (p *capacityKubernetesPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) (err error) {
+	p.client, err = newKubernetesClient(p.cfg.Kubernetes.APIConfig, p.cfg.Kubernetes.TokenPath)
+	return err
+}
+
+//ID implements the core.CapacityPlugin interface.
+func (p *capacityKubernetesPlugin) ID() string {
+	return "kubernetes"
+}
+
+//Scrape implements the core.CapacityPlugin interface.
+func (p *capacityKubernetesPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) (map[string]map[string]core.CapacityData, string, error) {
+	nodes, err := p.client.ListNodes(p.cfg.Kubernetes.NodeSelector)
+	if err != nil {
+		return nil, "", err
+	}
+	//sort nodes by name for deterministic order of subcapacities
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Metadata.Name < nodes[j].Metadata.Name
+	})
+
+	result := make(map[string]core.CapacityData, len(kubernetesCapacityResources))
+	for resourceName := range kubernetesCapacityResources {
+		result[resourceName] = core.CapacityData{CapacityPerAZ: make(map[string]*core.CapacityDataForAZ)}
+	}
+
+	for _, node := range nodes {
+		if p.isExcluded(node) {
+			continue
+		}
+		az := node.Metadata.Labels["topology.kubernetes.io/zone"]
+		if az == "" {
+			az = "unknown"
+		}
+
+		for _, res := range kubernetesResources {
+			key, exists := kubernetesCapacityResources[res.Name]
+			if !exists {
+				continue
+			}
+			data := result[res.Name]
+			var capacity uint64
+			if allocatable, exists := node.Status.Allocatable[key]; exists {
+				capacity, err = convertKubernetesQuantity(allocatable, res.Unit, false)
+				if err != nil {
+					return nil, "", fmt.Errorf("cannot parse status.allocatable[%q] of node %s: %s", key, node.Metadata.Name, err.Error())
+				}
+			}
+
+			data.Capacity += capacity
+			if data.CapacityPerAZ[az] == nil {
+				data.CapacityPerAZ[az] = &core.CapacityDataForAZ{}
+			}
+			data.CapacityPerAZ[az].Capacity += capacity
+			if p.scrapeSubcapacities[res.Name] {
+				data.Subcapacities = append(data.Subcapacities, map[string]interface{}{
+					"name":     node.Metadata.Name,
+					"az":       az,
+					"capacity": capacity,
+				})
+			}
+			result[res.Name] = data
+		}
+	}
+
+	return map[string]map[string]core.CapacityData{"kubernetes": result}, "", nil
+}
+
+func (p *capacityKubernetesPlugin) isExcluded(node kubernetesNode) bool {
+	for _, taint := range node.Spec.Taints {
+		for _, key := range p.cfg.Kubernetes.ExcludeTaints {
+			if taint.Key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//DescribeMetrics implements the core.CapacityPlugin interface.
+func (p *capacityKubernetesPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	//not used by this plugin
+}
+
+//CollectMetrics implements the core.CapacityPlugin interface.
+func (p *capacityKubernetesPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, serializedMetrics string) error {
+	//not used by this plugin
+	return nil
+}
@@ -0,0 +1,129 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+func makeKubernetesNode(t *testing.T, nodeJSON string) kubernetesNode {
+	t.Helper()
+	var node kubernetesNode
+	err := json.Unmarshal([]byte(nodeJSON), &node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+func TestCapacityKubernetesPlugin(t *testing.T) {
+	k8s := &fakeKubernetes{
+		Nodes: []kubernetesNode{
+			makeKubernetesNode(t, `{
+				"metadata": {"name": "node2", "labels": {"topology.kubernetes.io/zone": "az-one", "pool": "workers"}},
+				"status": {"allocatable": {"cpu": "3500m", "memory": "8Gi", "ephemeral-storage": "100Gi"}}
+			}`),
+			makeKubernetesNode(t, `{
+				"metadata": {"name": "node1", "labels": {"topology.kubernetes.io/zone": "az-one", "pool": "workers"}},
+				"status": {"allocatable": {"cpu": "4", "memory": "16Gi", "ephemeral-storage": "100Gi"}}
+			}`),
+			makeKubernetesNode(t, `{
+				"metadata": {"name": "node3", "labels": {"topology.kubernetes.io/zone": "az-two", "pool": "workers"}},
+				"status": {"allocatable": {"cpu": "8", "memory": "32Gi", "ephemeral-storage": "200Gi"}}
+			}`),
+			//excluded by taint
+			makeKubernetesNode(t, `{
+				"metadata": {"name": "node4", "labels": {"topology.kubernetes.io/zone": "az-two", "pool": "workers"}},
+				"spec": {"taints": [{"key": "dedicated", "value": "gpu", "effect": "NoSchedule"}]},
+				"status": {"allocatable": {"cpu": "64", "memory": "256Gi", "ephemeral-storage": "1000Gi"}}
+			}`),
+			//excluded by node selector
+			makeKubernetesNode(t, `{
+				"metadata": {"name": "master1", "labels": {"topology.kubernetes.io/zone": "az-one", "pool": "masters"}},
+				"status": {"allocatable": {"cpu": "2", "memory": "4Gi", "ephemeral-storage": "50Gi"}}
+			}`),
+		},
+	}
+	srv := httptest.NewServer(k8s)
+	defer srv.Close()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	err := ioutil.WriteFile(tokenPath, []byte("secret-token"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := core.CapacitorConfiguration{ID: "kubernetes"}
+	cfg.Kubernetes.APIConfig.URL = srv.URL
+	cfg.Kubernetes.TokenPath = tokenPath
+	cfg.Kubernetes.NodeSelector = "pool=workers"
+	cfg.Kubernetes.ExcludeTaints = []string{"dedicated"}
+	plugin := &capacityKubernetesPlugin{cfg: cfg, scrapeSubcapacities: map[string]bool{"requests_cpu": true}}
+	err = plugin.Init(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, _, err := plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]core.CapacityData{
+		"kubernetes": {
+			"requests_cpu": {
+				Capacity: 15,
+				CapacityPerAZ: map[string]*core.CapacityDataForAZ{
+					"az-one": {Capacity: 7},
+					"az-two": {Capacity: 8},
+				},
+				Subcapacities: []interface{}{
+					map[string]interface{}{"name": "node1", "az": "az-one", "capacity": uint64(4)},
+					map[string]interface{}{"name": "node2", "az": "az-one", "capacity": uint64(3)},
+					map[string]interface{}{"name": "node3", "az": "az-two", "capacity": uint64(8)},
+				},
+			},
+			"requests_ephemeral_storage": {
+				Capacity: 400,
+				CapacityPerAZ: map[string]*core.CapacityDataForAZ{
+					"az-one": {Capacity: 200},
+					"az-two": {Capacity: 200},
+				},
+			},
+			"requests_memory": {
+				Capacity: 56 * 1024,
+				CapacityPerAZ: map[string]*core.CapacityDataForAZ{
+					"az-one": {Capacity: 24 * 1024},
+					"az-two": {Capacity: 32 * 1024},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, result)
+	}
+}
@@ -118,6 +118,14 @@ func (p *capacityManilaPlugin) Scrape(provider *gophercloud.ProviderClient, eo g
 	caps := map[string]core.CapacityData{
 		"share_networks": {Capacity: cfg.ShareNetworks},
 	}
+	//share groups do not consume backend resources of their own, so their
+	//capacity can only be given in the configuration
+	if cfg.ShareGroups > 0 {
+		caps["share_groups"] = core.CapacityData{Capacity: cfg.ShareGroups}
+	}
+	if cfg.ShareGroupSnapshots > 0 {
+		caps["share_group_snapshots"] = core.CapacityData{Capacity: cfg.ShareGroupSnapshots}
+	}
 	for _, shareType := range p.cfg.Manila.ShareTypes {
 		capForType, err := p.scrapeForShareType(shareType, client, azForServiceHost)
 		if err != nil {
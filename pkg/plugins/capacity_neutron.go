@@ -0,0 +1,142 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+type capacityNeutronPlugin struct {
+	cfg                 core.CapacitorConfiguration
+	reportSubcapacities bool
+}
+
+func init() {
+	core.RegisterCapacityPlugin(func(c core.CapacitorConfiguration, scrapeSubcapacities map[string]map[string]bool) core.CapacityPlugin {
+		return &capacityNeutronPlugin{c, scrapeSubcapacities["network"]["floating_ips"]}
+	})
+}
+
+//Init implements the core.CapacityPlugin interface.
+func (p *capacityNeutronPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
+	if len(p.cfg.Neutron.ExternalNetworkIDs) == 0 {
+		return errors.New("Neutron capacity plugin: missing required configuration field neutron.external_networks")
+	}
+	return nil
+}
+
+//ID implements the core.CapacityPlugin interface.
+func (p *capacityNeutronPlugin) ID() string {
+	return "neutron"
+}
+
+//Scrape implements the core.CapacityPlugin interface.
+func (p *capacityNeutronPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) (map[string]map[string]core.CapacityData, string, error) {
+	client, err := openstack.NewNetworkV2(provider, eo)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var capacity uint64
+	var subcapacities []interface{}
+	for _, networkID := range p.cfg.Neutron.ExternalNetworkIDs {
+		availability, err := getNetworkIPAvailability(client, networkID)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, subnet := range availability.Subnets {
+			//Floating IPs are only allocated from IPv4 subnets. (Also, the IP counts
+			//of IPv6 subnets are usually too large to be represented here.)
+			if subnet.IPVersion != 4 {
+				continue
+			}
+			capacity += uint64(subnet.TotalIPs)
+			if p.reportSubcapacities {
+				subcapacities = append(subcapacities, map[string]interface{}{
+					"network_id":   availability.NetworkID,
+					"network_name": availability.NetworkName,
+					"subnet_id":    subnet.SubnetID,
+					"subnet_name":  subnet.SubnetName,
+					"cidr":         subnet.CIDR,
+					"capacity":     uint64(subnet.TotalIPs),
+					"usage":        uint64(subnet.UsedIPs),
+				})
+			}
+		}
+	}
+
+	return map[string]map[string]core.CapacityData{
+		"network": {
+			"floating_ips": core.CapacityData{
+				Capacity:      capacity,
+				Subcapacities: subcapacities,
+			},
+		},
+	}, "", nil
+}
+
+//DescribeMetrics implements the core.CapacityPlugin interface.
+func (p *capacityNeutronPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	//not used by this plugin
+}
+
+//CollectMetrics implements the core.CapacityPlugin interface.
+func (p *capacityNeutronPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, serializedMetrics string) error {
+	//not used by this plugin
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Gophercloud client for Neutron's network-ip-availabilities API
+
+type neutronNetworkIPAvailability struct {
+	NetworkID   string `json:"network_id"`
+	NetworkName string `json:"network_name"`
+	Subnets     []struct {
+		SubnetID   string `json:"subnet_id"`
+		SubnetName string `json:"subnet_name"`
+		CIDR       string `json:"cidr"`
+		IPVersion  int    `json:"ip_version"`
+		//these are float64 because they can be extremely large for IPv6 subnets
+		TotalIPs float64 `json:"total_ips"`
+		UsedIPs  float64 `json:"used_ips"`
+	} `json:"subnet_ip_availability"`
+}
+
+func getNetworkIPAvailability(client *gophercloud.ServiceClient, networkID string) (neutronNetworkIPAvailability, error) {
+	var result gophercloud.Result
+	url := client.ServiceURL("network-ip-availabilities", networkID)
+	_, result.Err = client.Get(url, &result.Body, &gophercloud.RequestOpts{
+		OkCodes: []int{http.StatusOK},
+	})
+
+	var data struct {
+		Availability neutronNetworkIPAvailability `json:"network_ip_availability"`
+	}
+	err := result.ExtractInto(&data)
+	return data.Availability, err
+}
@@ -0,0 +1,114 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+func TestCapacityNeutronPlugin(t *testing.T) {
+	availabilities := map[string]map[string]interface{}{
+		"network-one": {
+			"network_id":   "network-one",
+			"network_name": "FloatingIP-external-one",
+			"subnet_ip_availability": []map[string]interface{}{
+				{"subnet_id": "subnet-one", "subnet_name": "one-v4", "cidr": "198.51.100.0/24", "ip_version": 4, "total_ips": 253, "used_ips": 42},
+				{"subnet_id": "subnet-two", "subnet_name": "one-v6", "cidr": "2001:db8::/64", "ip_version": 6, "total_ips": 18446744073709551614.0, "used_ips": 2},
+			},
+		},
+		"network-two": {
+			"network_id":   "network-two",
+			"network_name": "FloatingIP-external-two",
+			"subnet_ip_availability": []map[string]interface{}{
+				{"subnet_id": "subnet-three", "subnet_name": "two-v4", "cidr": "203.0.113.0/25", "ip_version": 4, "total_ips": 125, "used_ips": 100},
+			},
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		networkID := strings.TrimPrefix(r.URL.Path, "/v2.0/network-ip-availabilities/")
+		data, exists := availabilities[networkID]
+		if r.Method != "GET" || !exists {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"network_ip_availability": data})
+	}))
+	defer srv.Close()
+
+	provider := &gophercloud.ProviderClient{
+		EndpointLocator: func(gophercloud.EndpointOpts) (string, error) { return srv.URL + "/", nil },
+	}
+	cfg := core.CapacitorConfiguration{ID: "neutron"}
+	cfg.Neutron.ExternalNetworkIDs = []string{"network-one", "network-two"}
+	plugin := &capacityNeutronPlugin{cfg: cfg, reportSubcapacities: true}
+	err := plugin.Init(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, _, err := plugin.Scrape(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]core.CapacityData{
+		"network": {
+			"floating_ips": {
+				Capacity: 378,
+				Subcapacities: []interface{}{
+					map[string]interface{}{
+						"network_id":   "network-one",
+						"network_name": "FloatingIP-external-one",
+						"subnet_id":    "subnet-one",
+						"subnet_name":  "one-v4",
+						"cidr":         "198.51.100.0/24",
+						"capacity":     uint64(253),
+						"usage":        uint64(42),
+					},
+					map[string]interface{}{
+						"network_id":   "network-two",
+						"network_name": "FloatingIP-external-two",
+						"subnet_id":    "subnet-three",
+						"subnet_name":  "two-v4",
+						"cidr":         "203.0.113.0/25",
+						"capacity":     uint64(125),
+						"usage":        uint64(100),
+					},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, result)
+	}
+
+	//unknown networks are an error
+	plugin.cfg.Neutron.ExternalNetworkIDs = []string{"network-three"}
+	_, _, err = plugin.Scrape(provider, gophercloud.EndpointOpts{})
+	if err == nil {
+		t.Error("expected Scrape to fail for unknown network, but got no error")
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
@@ -39,12 +40,13 @@ import (
 )
 
 type capacityPrometheusPlugin struct {
-	cfg core.CapacitorConfiguration
+	cfg                 core.CapacitorConfiguration
+	scrapeSubcapacities map[string]map[string]bool
 }
 
 func init() {
 	core.RegisterCapacityPlugin(func(c core.CapacitorConfiguration, scrapeSubcapacities map[string]map[string]bool) core.CapacityPlugin {
-		return &capacityPrometheusPlugin{c}
+		return &capacityPrometheusPlugin{c, scrapeSubcapacities}
 	})
 }
 
@@ -53,8 +55,31 @@ func prometheusClient(cfg core.PrometheusAPIConfiguration) (prom_v1.API, error)
 		return nil, errors.New("missing configuration parameter: url")
 	}
 
-	roundTripper := prom_api.DefaultRoundTripper
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	//take a copy of the default transport instead of modifying it, since it is
+	//shared by all clients
+	defaultTransport, ok := prom_api.DefaultRoundTripper.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("expected roundTripper of type \"*http.Transport\", got %T", prom_api.DefaultRoundTripper)
+	}
+	transport := defaultTransport.Clone()
+	transport.TLSClientConfig = tlsConfig
+	var roundTripper http.RoundTripper = transport
+
+	client, err := prom_api.NewClient(prom_api.Config{Address: cfg.URL, RoundTripper: roundTripper})
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to Prometheus at %s: %s", cfg.URL, err.Error())
+	}
+	return prom_v1.NewAPI(client), nil
+}
 
+//newTLSConfig builds the TLS configuration for the client certificate and
+//server CA certificate given in the API configuration.
+func newTLSConfig(cfg core.PrometheusAPIConfiguration) (*tls.Config, error) {
 	tlsConfig := &tls.Config{}
 	//If one of the following is set, so must be the other one
 	if cfg.ClientCertificatePath != "" || cfg.ClientCertificateKeyPath != "" {
@@ -81,31 +106,28 @@ func prometheusClient(cfg core.PrometheusAPIConfiguration) (prom_v1.API, error)
 		certPool.AppendCertsFromPEM(serverCACert)
 		tlsConfig.RootCAs = certPool
 	}
-
-	if transport, ok := roundTripper.(*http.Transport); ok {
-		transport.TLSClientConfig = tlsConfig
-	} else {
-		return nil, fmt.Errorf("expected roundTripper of type \"*http.Transport\", got %T", roundTripper)
-	}
-
-	client, err := prom_api.NewClient(prom_api.Config{Address: cfg.URL, RoundTripper: roundTripper})
-	if err != nil {
-		return nil, fmt.Errorf("cannot connect to Prometheus at %s: %s", cfg.URL, err.Error())
-	}
-	return prom_v1.NewAPI(client), nil
+	return tlsConfig, nil
 }
 
-func prometheusGetSingleValue(client prom_v1.API, queryStr string, defaultValue *float64) (float64, error) {
+func prometheusGetVector(client prom_v1.API, queryStr string) (model.Vector, error) {
 	value, warnings, err := client.Query(context.Background(), queryStr, time.Now())
 	for _, warning := range warnings {
 		logg.Info("Prometheus query produced warning: %s", warning)
 	}
 	if err != nil {
-		return 0, fmt.Errorf("Prometheus query failed: %s: %s", queryStr, err.Error())
+		return nil, fmt.Errorf("Prometheus query failed: %s: %s", queryStr, err.Error())
 	}
 	resultVector, ok := value.(model.Vector)
 	if !ok {
-		return 0, fmt.Errorf("Prometheus query failed: %s: unexpected type %T", queryStr, value)
+		return nil, fmt.Errorf("Prometheus query failed: %s: unexpected type %T", queryStr, value)
+	}
+	return resultVector, nil
+}
+
+func prometheusGetSingleValue(client prom_v1.API, queryStr string, defaultValue *float64) (float64, error) {
+	resultVector, err := prometheusGetVector(client, queryStr)
+	if err != nil {
+		return 0, err
 	}
 
 	switch resultVector.Len() {
@@ -122,8 +144,37 @@ func prometheusGetSingleValue(client prom_v1.API, queryStr string, defaultValue
 	}
 }
 
+//prometheusGetValuesByLabel returns the values of a vector query, grouped by
+//the value of the given label.
+func prometheusGetValuesByLabel(client prom_v1.API, queryStr, labelName string) (map[string]float64, error) {
+	resultVector, err := prometheusGetVector(client, queryStr)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]float64, resultVector.Len())
+	for _, sample := range resultVector {
+		labelValue := string(sample.Metric[model.LabelName(labelName)])
+		if labelValue == "" {
+			return nil, fmt.Errorf("Prometheus query returned result without %q label: %s", labelName, queryStr)
+		}
+		result[labelValue] += float64(sample.Value)
+	}
+	return result, nil
+}
+
 //Init implements the core.CapacityPlugin interface.
 func (p *capacityPrometheusPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
+	for serviceType, queries := range p.cfg.Prometheus.Queries {
+		for resourceName, query := range queries {
+			if query.Query == "" {
+				return fmt.Errorf("missing query for %s/%s", serviceType, resourceName)
+			}
+			if query.UsageQuery != "" && query.AZLabel == "" {
+				return fmt.Errorf("usage_query for %s/%s requires az_label to be set", serviceType, resourceName)
+			}
+		}
+	}
 	return nil
 }
 
@@ -143,17 +194,73 @@ func (p *capacityPrometheusPlugin) Scrape(provider *gophercloud.ProviderClient,
 	for serviceType, queries := range p.cfg.Prometheus.Queries {
 		serviceResult := make(map[string]core.CapacityData)
 		for resourceName, query := range queries {
-			value, err := prometheusGetSingleValue(client, query, nil)
+			data, err := p.scrapeResource(client, query, p.scrapeSubcapacities[serviceType][resourceName])
 			if err != nil {
 				return nil, "", err
 			}
-			serviceResult[resourceName] = core.CapacityData{Capacity: uint64(value)}
+			serviceResult[resourceName] = data
 		}
 		result[serviceType] = serviceResult
 	}
 	return result, "", nil
 }
 
+func (p *capacityPrometheusPlugin) scrapeResource(client prom_v1.API, query core.PrometheusCapacityQuery, reportSubcapacities bool) (core.CapacityData, error) {
+	var result core.CapacityData
+
+	if query.AZLabel == "" {
+		value, err := prometheusGetSingleValue(client, query.Query, nil)
+		if err != nil {
+			return core.CapacityData{}, err
+		}
+		result.Capacity = uint64(value)
+	} else {
+		capacityPerAZ, err := prometheusGetValuesByLabel(client, query.Query, query.AZLabel)
+		if err != nil {
+			return core.CapacityData{}, err
+		}
+		usagePerAZ := make(map[string]float64)
+		if query.UsageQuery != "" {
+			usagePerAZ, err = prometheusGetValuesByLabel(client, query.UsageQuery, query.AZLabel)
+			if err != nil {
+				return core.CapacityData{}, err
+			}
+		}
+
+		result.CapacityPerAZ = make(map[string]*core.CapacityDataForAZ, len(capacityPerAZ))
+		for az, capacity := range capacityPerAZ {
+			result.Capacity += uint64(capacity)
+			result.CapacityPerAZ[az] = &core.CapacityDataForAZ{
+				Capacity: uint64(capacity),
+				Usage:    uint64(usagePerAZ[az]),
+			}
+		}
+	}
+
+	if reportSubcapacities && query.SubcapacitiesQuery != "" {
+		resultVector, err := prometheusGetVector(client, query.SubcapacitiesQuery)
+		if err != nil {
+			return core.CapacityData{}, err
+		}
+		//sort by labels for deterministic output
+		sort.Slice(resultVector, func(i, j int) bool {
+			return resultVector[i].Metric.String() < resultVector[j].Metric.String()
+		})
+		for _, sample := range resultVector {
+			subcapacity := make(map[string]interface{}, len(sample.Metric)+1)
+			for labelName, labelValue := range sample.Metric {
+				if labelName != model.MetricNameLabel {
+					subcapacity[string(labelName)] = string(labelValue)
+				}
+			}
+			subcapacity["capacity"] = uint64(sample.Value)
+			result.Subcapacities = append(result.Subcapacities, subcapacity)
+		}
+	}
+
+	return result, nil
+}
+
 //DescribeMetrics implements the core.CapacityPlugin interface.
 func (p *capacityPrometheusPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
 	//not used by this plugin
@@ -0,0 +1,140 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/sapcc/limes/pkg/core"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//fakePrometheus implements the query endpoint of the Prometheus API, with
+//fixed results for each query.
+type fakePrometheus map[string][]map[string]interface{}
+
+func (fp fakePrometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v1/query" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	samples, exists := fp[r.Form.Get("query")]
+	if !exists {
+		http.Error(w, "unexpected query", http.StatusBadRequest)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   map[string]interface{}{"resultType": "vector", "result": samples},
+	})
+}
+
+func promSample(value string, labels map[string]string) map[string]interface{} {
+	return map[string]interface{}{"metric": labels, "value": []interface{}{1600000000, value}}
+}
+
+const capacityPrometheusTestConfig = `
+queries:
+  shared:
+    things: sum(things_capacity)
+    capacity:
+      query: sum by (az) (capacity_bytes)
+      az_label: az
+      usage_query: sum by (az) (capacity_used_bytes)
+      subcapacities_query: sum by (az, pool) (capacity_bytes)
+`
+
+func TestCapacityPrometheusPlugin(t *testing.T) {
+	srv := httptest.NewServer(fakePrometheus{
+		"sum(things_capacity)": {
+			promSample("42", nil),
+		},
+		"sum by (az) (capacity_bytes)": {
+			promSample("100", map[string]string{"az": "az-one"}),
+			promSample("200", map[string]string{"az": "az-two"}),
+		},
+		"sum by (az) (capacity_used_bytes)": {
+			promSample("10", map[string]string{"az": "az-one"}),
+		},
+		"sum by (az, pool) (capacity_bytes)": {
+			promSample("200", map[string]string{"az": "az-two", "pool": "pool-two"}),
+			promSample("100", map[string]string{"az": "az-one", "pool": "pool-one"}),
+		},
+	})
+	defer srv.Close()
+
+	var cfg core.CapacitorConfiguration
+	err := yaml.Unmarshal([]byte(capacityPrometheusTestConfig), &cfg.Prometheus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Prometheus.APIConfig.URL = srv.URL
+	plugin := &capacityPrometheusPlugin{
+		cfg:                 cfg,
+		scrapeSubcapacities: map[string]map[string]bool{"shared": {"capacity": true}},
+	}
+	err = plugin.Init(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, _, err := plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]core.CapacityData{
+		"shared": {
+			"things": {Capacity: 42},
+			"capacity": {
+				Capacity: 300,
+				CapacityPerAZ: map[string]*core.CapacityDataForAZ{
+					"az-one": {Capacity: 100, Usage: 10},
+					"az-two": {Capacity: 200, Usage: 0},
+				},
+				Subcapacities: []interface{}{
+					map[string]interface{}{"az": "az-one", "pool": "pool-one", "capacity": uint64(100)},
+					map[string]interface{}{"az": "az-two", "pool": "pool-two", "capacity": uint64(200)},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, result)
+	}
+
+	//usage_query cannot be used without az_label
+	plugin.cfg.Prometheus.Queries["shared"]["things"] = core.PrometheusCapacityQuery{
+		Query:      "sum(things_capacity)",
+		UsageQuery: "sum(things_usage)",
+	}
+	err = plugin.Init(nil, gophercloud.EndpointOpts{})
+	if err == nil {
+		t.Error("expected Init to fail for usage_query without az_label, but got no error")
+	}
+}
@@ -0,0 +1,374 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+type capacitySwiftPlugin struct {
+	cfg                 core.CapacitorConfiguration
+	reportSubcapacities bool
+	httpClient          *http.Client
+}
+
+func init() {
+	core.RegisterCapacityPlugin(func(c core.CapacitorConfiguration, scrapeSubcapacities map[string]map[string]bool) core.CapacityPlugin {
+		return &capacitySwiftPlugin{cfg: c, reportSubcapacities: scrapeSubcapacities["object-store"]["capacity"]}
+	})
+}
+
+//Init implements the core.CapacityPlugin interface.
+func (p *capacitySwiftPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
+	cfg := p.cfg.Swift
+	if len(cfg.Nodes) == 0 && cfg.AggregatedURL == "" {
+		return errors.New("Swift capacity plugin: missing required configuration field swift.nodes or swift.aggregated_url")
+	}
+	if len(cfg.Nodes) > 0 && cfg.AggregatedURL != "" {
+		return errors.New("Swift capacity plugin: configuration fields swift.nodes and swift.aggregated_url are mutually exclusive")
+	}
+	for _, node := range cfg.Nodes {
+		if node.URL == "" {
+			return errors.New("Swift capacity plugin: missing required configuration field swift.nodes[].url")
+		}
+	}
+	if cfg.Replicas <= 0 && cfg.RingPath == "" {
+		return errors.New("Swift capacity plugin: missing required configuration field swift.replicas or swift.ring_path")
+	}
+	if cfg.Replicas < 0 {
+		return errors.New("Swift capacity plugin: configuration field swift.replicas must not be negative")
+	}
+	switch cfg.AZFrom {
+	case "", "region", "zone":
+	default:
+		return fmt.Errorf(`Swift capacity plugin: invalid value for swift.per_az: %q (expected "region", "zone" or nothing)`, cfg.AZFrom)
+	}
+
+	p.httpClient = &http.Client{Timeout: 30 * time.Second}
+	return nil
+}
+
+//ID implements the core.CapacityPlugin interface.
+func (p *capacitySwiftPlugin) ID() string {
+	return "swift"
+}
+
+//swiftReconDevice is an entry in the response of GET /recon/diskusage.
+type swiftReconDevice struct {
+	Device string `json:"device"`
+	//These are interface{} because recon reports errors in these fields as
+	//strings (e.g. "mounted": "[Errno 5] Input/output error", "size": "").
+	Mounted interface{} `json:"mounted"`
+	Size    interface{} `json:"size"`
+	Used    interface{} `json:"used"`
+}
+
+//swiftStorageNode contains the disk usage of one storage node.
+type swiftStorageNode struct {
+	Name    string             `json:"node"`
+	Region  string             `json:"region"`
+	Zone    string             `json:"zone"`
+	Devices []swiftReconDevice `json:"diskusage"`
+}
+
+type capacitySwiftSerializedMetrics struct {
+	RawCapacity uint64  `json:"raw_capacity"`
+	RawUsage    uint64  `json:"raw_usage"`
+	Replicas    float64 `json:"replicas"`
+}
+
+//Scrape implements the core.CapacityPlugin interface.
+func (p *capacitySwiftPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) (map[string]map[string]core.CapacityData, string, error) {
+	replicas := p.cfg.Swift.Replicas
+	if p.cfg.Swift.RingPath != "" {
+		var err error
+		replicas, err = readSwiftRingReplicaCount(p.cfg.Swift.RingPath)
+		if errors.Is(err, errSwiftRingFormatUnsupported) && p.cfg.Swift.Replicas > 0 {
+			logg.Info("Swift capacity plugin: %s; falling back to swift.replicas = %g", err.Error(), p.cfg.Swift.Replicas)
+			replicas, err = p.cfg.Swift.Replicas, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	nodes, err := p.getStorageNodes()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var (
+		rawCapacity   float64
+		rawUsage      float64
+		capacityPerAZ map[string]*core.CapacityDataForAZ
+		subcapacities []interface{}
+	)
+	if p.cfg.Swift.AZFrom != "" {
+		capacityPerAZ = make(map[string]*core.CapacityDataForAZ)
+	}
+
+	for _, node := range nodes {
+		var nodeCapacity, nodeUsage float64
+		for _, dev := range node.Devices {
+			//only consider mounted devices with valid numbers
+			size, sizeOK := dev.Size.(float64)
+			used, usedOK := dev.Used.(float64)
+			if dev.Mounted != true || !sizeOK || !usedOK {
+				continue
+			}
+			nodeCapacity += size
+			nodeUsage += used
+		}
+		rawCapacity += nodeCapacity
+		rawUsage += nodeUsage
+
+		if capacityPerAZ != nil {
+			az := node.Region
+			if p.cfg.Swift.AZFrom == "zone" {
+				az = node.Zone
+			}
+			if az == "" {
+				az = "unknown"
+			}
+			if capacityPerAZ[az] == nil {
+				capacityPerAZ[az] = &core.CapacityDataForAZ{}
+			}
+			//these are summed up as raw values and divided by the replica count below
+			capacityPerAZ[az].Capacity += uint64(nodeCapacity)
+			capacityPerAZ[az].Usage += uint64(nodeUsage)
+		}
+
+		if p.reportSubcapacities {
+			subcapacities = append(subcapacities, map[string]interface{}{
+				"node":         node.Name,
+				"region":       node.Region,
+				"zone":         node.Zone,
+				"raw_capacity": uint64(nodeCapacity),
+				"raw_usage":    uint64(nodeUsage),
+			})
+		}
+	}
+
+	for _, azData := range capacityPerAZ {
+		azData.Capacity = uint64(float64(azData.Capacity) / replicas)
+		azData.Usage = uint64(float64(azData.Usage) / replicas)
+	}
+
+	serializedMetrics, _ := json.Marshal(capacitySwiftSerializedMetrics{
+		RawCapacity: uint64(rawCapacity),
+		RawUsage:    uint64(rawUsage),
+		Replicas:    replicas,
+	})
+	return map[string]map[string]core.CapacityData{
+		"object-store": {
+			"capacity": core.CapacityData{
+				Capacity:      uint64(rawCapacity / replicas),
+				CapacityPerAZ: capacityPerAZ,
+				Subcapacities: subcapacities,
+			},
+		},
+	}, string(serializedMetrics), nil
+}
+
+//errSwiftRingFormatUnsupported is returned by readSwiftRingReplicaCount for
+//rings that are not in the v1 format.
+var errSwiftRingFormatUnsupported = errors.New("unsupported file format")
+
+//readSwiftRingReplicaCount reads the replica count from a Swift ring file
+//(e.g. /etc/swift/object.ring.gz). Only the v1 "R1NG" format is supported,
+//neither the pickle format of very old Swift releases nor the v2 format that
+//newer Swift releases can write (its section index is not parsed here).
+//
+//The replica count in the ring metadata is rounded up to an integer, so the
+//actual (possibly fractional) replica count is computed from the size of the
+//partition-to-device tables that follow the metadata.
+func readSwiftRingReplicaCount(path string) (float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read Swift ring %s: %s", path, err.Error())
+	}
+
+	var header struct {
+		Magic        [4]byte
+		Version      uint16
+		MetadataSize uint32
+	}
+	err = binary.Read(reader, binary.BigEndian, &header)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read Swift ring %s: %s", path, err.Error())
+	}
+	if string(header.Magic[:]) != "R1NG" {
+		return 0, fmt.Errorf("cannot read Swift ring %s: %w", path, errSwiftRingFormatUnsupported)
+	}
+	if header.Version != 1 {
+		return 0, fmt.Errorf("cannot read Swift ring %s: %w (version %d)", path, errSwiftRingFormatUnsupported, header.Version)
+	}
+
+	var metadata struct {
+		ReplicaCount uint64 `json:"replica_count"`
+		PartShift    uint   `json:"part_shift"`
+		DevIDBytes   uint64 `json:"dev_id_bytes"`
+	}
+	err = json.NewDecoder(io.LimitReader(reader, int64(header.MetadataSize))).Decode(&metadata)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read Swift ring %s: %s", path, err.Error())
+	}
+	if metadata.DevIDBytes == 0 {
+		metadata.DevIDBytes = 2 //default in older Swift releases
+	}
+	if metadata.PartShift > 32 {
+		return 0, fmt.Errorf("cannot read Swift ring %s: invalid part_shift %d", path, metadata.PartShift)
+	}
+
+	//the rest of the file contains one table per replica, each with one device
+	//ID per partition (the last table is shorter for fractional replica counts)
+	tableBytes, err := io.Copy(ioutil.Discard, reader)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read Swift ring %s: %s", path, err.Error())
+	}
+	partitionCount := uint64(1) << (32 - metadata.PartShift)
+	replicas := float64(tableBytes) / float64(partitionCount*metadata.DevIDBytes)
+	if replicas <= float64(metadata.ReplicaCount)-1 || replicas > float64(metadata.ReplicaCount) {
+		return 0, fmt.Errorf("cannot read Swift ring %s: expected %d replicas, but found %g", path, metadata.ReplicaCount, replicas)
+	}
+	return replicas, nil
+}
+
+func (p *capacitySwiftPlugin) getStorageNodes() ([]swiftStorageNode, error) {
+	if p.cfg.Swift.AggregatedURL != "" {
+		var nodes []swiftStorageNode
+		err := p.getJSON(p.cfg.Swift.AggregatedURL, &nodes)
+		return nodes, err
+	}
+
+	nodes := make([]swiftStorageNode, len(p.cfg.Swift.Nodes))
+	for idx, nodeCfg := range p.cfg.Swift.Nodes {
+		nodes[idx] = swiftStorageNode{
+			Name:   nodeCfg.URL,
+			Region: nodeCfg.Region,
+			Zone:   nodeCfg.Zone,
+		}
+		url := strings.TrimSuffix(nodeCfg.URL, "/") + "/recon/diskusage"
+		err := p.getJSON(url, &nodes[idx].Devices)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+func (p *capacitySwiftPlugin) getJSON(url string, data interface{}) error {
+	resp, err := p.httpClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("GET %s failed: %s", url, err.Error())
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("GET %s failed: %s", url, err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s failed: expected 200, got %d: %s", url, resp.StatusCode, string(body))
+	}
+
+	err = json.Unmarshal(body, data)
+	if err != nil {
+		return fmt.Errorf("GET %s returned invalid JSON: %s", url, err.Error())
+	}
+	return nil
+}
+
+var (
+	swiftRawCapacityGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "limes_swift_raw_capacity_bytes",
+			Help: "Total size of all mounted disks on all Swift storage nodes.",
+		},
+		[]string{"os_cluster"},
+	)
+	swiftRawUsageGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "limes_swift_raw_usage_bytes",
+			Help: "Used space on all mounted disks on all Swift storage nodes.",
+		},
+		[]string{"os_cluster"},
+	)
+	swiftReplicasGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "limes_swift_replicas",
+			Help: "Replica count that Swift's raw capacity is divided by.",
+		},
+		[]string{"os_cluster"},
+	)
+)
+
+//DescribeMetrics implements the core.CapacityPlugin interface.
+func (p *capacitySwiftPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	swiftRawCapacityGauge.Describe(ch)
+	swiftRawUsageGauge.Describe(ch)
+	swiftReplicasGauge.Describe(ch)
+}
+
+//CollectMetrics implements the core.CapacityPlugin interface.
+func (p *capacitySwiftPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, serializedMetrics string) error {
+	if serializedMetrics == "" {
+		return nil
+	}
+	var metrics capacitySwiftSerializedMetrics
+	err := json.Unmarshal([]byte(serializedMetrics), &metrics)
+	if err != nil {
+		return err
+	}
+
+	descCh := make(chan *prometheus.Desc, 1)
+	for gauge, value := range map[*prometheus.GaugeVec]float64{
+		swiftRawCapacityGauge: float64(metrics.RawCapacity),
+		swiftRawUsageGauge:    float64(metrics.RawUsage),
+		swiftReplicasGauge:    metrics.Replicas,
+	} {
+		gauge.Describe(descCh)
+		ch <- prometheus.MustNewConstMetric(
+			<-descCh,
+			prometheus.GaugeValue, value,
+			clusterID,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+func fakeSwiftRecon(diskusage string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/recon/diskusage" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(diskusage))
+	}))
+}
+
+func TestCapacitySwiftPlugin(t *testing.T) {
+	node1 := fakeSwiftRecon(`[
+		{"device": "sdb", "mounted": true, "size": 6000, "used": 1500, "avail": 4500},
+		{"device": "sdc", "mounted": true, "size": 6000, "used": 3000, "avail": 3000},
+		{"device": "sdd", "mounted": false, "size": "", "used": "", "avail": ""}
+	]`)
+	defer node1.Close()
+	node2 := fakeSwiftRecon(`[
+		{"device": "sdb", "mounted": true, "size": 9000, "used": 300, "avail": 8700},
+		{"device": "sdc", "mounted": "[Errno 5] Input/output error", "size": "", "used": "", "avail": ""}
+	]`)
+	defer node2.Close()
+
+	cfg := core.CapacitorConfiguration{ID: "swift"}
+	cfg.Swift.Nodes = []core.SwiftStorageNodeConfiguration{
+		{URL: node1.URL, Region: "region-one", Zone: "zone-one"},
+		{URL: node2.URL + "/", Region: "region-one", Zone: "zone-two"},
+	}
+	cfg.Swift.Replicas = 3
+	cfg.Swift.AZFrom = "zone"
+
+	plugin := &capacitySwiftPlugin{cfg: cfg, reportSubcapacities: true}
+	err := plugin.Init(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _, err := plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]core.CapacityData{
+		"object-store": {
+			"capacity": {
+				Capacity: 7000,
+				CapacityPerAZ: map[string]*core.CapacityDataForAZ{
+					"zone-one": {Capacity: 4000, Usage: 1500},
+					"zone-two": {Capacity: 3000, Usage: 100},
+				},
+				Subcapacities: []interface{}{
+					map[string]interface{}{"node": node1.URL, "region": "region-one", "zone": "zone-one", "raw_capacity": uint64(12000), "raw_usage": uint64(4500)},
+					map[string]interface{}{"node": node2.URL + "/", "region": "region-one", "zone": "zone-two", "raw_capacity": uint64(9000), "raw_usage": uint64(300)},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, result)
+	}
+
+	//per-region breakdown without subcapacities
+	plugin.cfg.Swift.AZFrom = "region"
+	plugin.reportSubcapacities = false
+	result, _, err = plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]map[string]core.CapacityData{
+		"object-store": {
+			"capacity": {
+				Capacity: 7000,
+				CapacityPerAZ: map[string]*core.CapacityDataForAZ{
+					"region-one": {Capacity: 7000, Usage: 1600},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, result)
+	}
+
+	//unreachable nodes are an error
+	node2.Close()
+	_, _, err = plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err == nil {
+		t.Error("expected Scrape to fail for unreachable node, but got no error")
+	}
+}
+
+func TestCapacitySwiftPluginAggregated(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, []map[string]interface{}{
+			{
+				"node":      "storage1",
+				"region":    "region-one",
+				"zone":      "zone-one",
+				"diskusage": []map[string]interface{}{{"device": "sdb", "mounted": true, "size": 5000, "used": 1000}},
+			},
+			{
+				"node":      "storage2",
+				"diskusage": []map[string]interface{}{{"device": "sdb", "mounted": true, "size": 2500, "used": 500}},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	cfg := core.CapacitorConfiguration{ID: "swift"}
+	cfg.Swift.AggregatedURL = srv.URL
+	cfg.Swift.Replicas = 2.5
+	plugin := &capacitySwiftPlugin{cfg: cfg}
+	err := plugin.Init(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _, err := plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]core.CapacityData{
+		"object-store": {
+			"capacity": {Capacity: 3000},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, result)
+	}
+
+	//invalid configurations
+	for _, modify := range []func(*core.CapacitorConfiguration){
+		func(c *core.CapacitorConfiguration) { c.Swift.AggregatedURL = "" },
+		func(c *core.CapacitorConfiguration) { c.Swift.Replicas = 0 },
+		func(c *core.CapacitorConfiguration) { c.Swift.Replicas = -1 },
+		func(c *core.CapacitorConfiguration) { c.Swift.AZFrom = "rack" },
+	} {
+		invalidCfg := cfg
+		modify(&invalidCfg)
+		err := (&capacitySwiftPlugin{cfg: invalidCfg}).Init(nil, gophercloud.EndpointOpts{})
+		if err == nil {
+			t.Errorf("expected Init to fail for %#v, but got no error", invalidCfg.Swift)
+		}
+	}
+}
+
+//writeSwiftRing writes a ring file in the format of Swift's
+//RingData.serialize_v1(), with the given number of device IDs per replica.
+func writeSwiftRing(t *testing.T, path, metadata string, tableSizes ...int) {
+	t.Helper()
+	writeSwiftRingWithVersion(t, path, 1, metadata, tableSizes...)
+}
+
+func writeSwiftRingWithVersion(t *testing.T, path string, version uint16, metadata string, tableSizes ...int) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("R1NG"))
+	binary.Write(gz, binary.BigEndian, version)
+	binary.Write(gz, binary.BigEndian, uint32(len(metadata)))
+	gz.Write([]byte(metadata))
+	for _, size := range tableSizes {
+		gz.Write(make([]byte, 2*size))
+	}
+	gz.Close()
+	err := ioutil.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCapacitySwiftPluginWithRing(t *testing.T) {
+	node := fakeSwiftRecon(`[{"device": "sdb", "mounted": true, "size": 10000, "used": 2500, "avail": 7500}]`)
+	defer node.Close()
+
+	//16 partitions, 2.5 replicas
+	ringPath := filepath.Join(t.TempDir(), "object.ring.gz")
+	writeSwiftRing(t, ringPath, `{"devs":[],"part_shift":28,"replica_count":3,"byteorder":"little"}`, 16, 16, 8)
+
+	cfg := core.CapacitorConfiguration{ID: "swift"}
+	cfg.Swift.Nodes = []core.SwiftStorageNodeConfiguration{{URL: node.URL}}
+	cfg.Swift.RingPath = ringPath
+	plugin := &capacitySwiftPlugin{cfg: cfg}
+	err := plugin.Init(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, serializedMetrics, err := plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]core.CapacityData{
+		"object-store": {
+			"capacity": {Capacity: 4000},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, result)
+	}
+	expectedMetrics := `{"raw_capacity":10000,"raw_usage":2500,"replicas":2.5}`
+	if serializedMetrics != expectedMetrics {
+		t.Errorf("expected serialized metrics %s, but got %s", expectedMetrics, serializedMetrics)
+	}
+
+	//the ring is read on every scrape, so that rebalances are picked up
+	writeSwiftRing(t, ringPath, `{"devs":[],"part_shift":28,"replica_count":2,"byteorder":"little"}`, 16, 16)
+	result, _, err = plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capa := result["object-store"]["capacity"].Capacity; capa != 5000 {
+		t.Errorf("expected capacity 5000 after ring change, but got %d", capa)
+	}
+
+	//replica tables that do not match the replica count are an error
+	writeSwiftRing(t, ringPath, `{"devs":[],"part_shift":28,"replica_count":3,"byteorder":"little"}`, 16, 16)
+	_, _, err = plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err == nil {
+		t.Error("expected Scrape to fail for inconsistent ring, but got no error")
+	}
+
+	//rings in the old pickle format are not supported
+	err = ioutil.WriteFile(ringPath, []byte("not a gzip file"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err == nil {
+		t.Error("expected Scrape to fail for invalid ring, but got no error")
+	}
+
+	//v2 rings are not supported either...
+	writeSwiftRingWithVersion(t, ringPath, 2, `{"devs":[],"part_shift":28,"replica_count":2,"byteorder":"little"}`, 16, 16)
+	_, _, err = plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err == nil {
+		t.Error("expected Scrape to fail for v2 ring, but got no error")
+	}
+
+	//...but swift.replicas can be given as a fallback for them
+	cfg.Swift.Replicas = 4
+	plugin = &capacitySwiftPlugin{cfg: cfg}
+	err = plugin.Init(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _, err = plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capa := result["object-store"]["capacity"].Capacity; capa != 2500 {
+		t.Errorf("expected capacity 2500 with fallback replica count, but got %d", capa)
+	}
+
+	//the fallback is not used for readable v1 rings
+	writeSwiftRing(t, ringPath, `{"devs":[],"part_shift":28,"replica_count":2,"byteorder":"little"}`, 16, 16)
+	result, _, err = plugin.Scrape(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capa := result["object-store"]["capacity"].Capacity; capa != 5000 {
+		t.Errorf("expected capacity 5000 from ring, but got %d", capa)
+	}
+}
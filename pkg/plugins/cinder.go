@@ -66,7 +66,7 @@ func (p *cinderPlugin) ServiceInfo() limes.ServiceInfo {
 
 //Resources implements the core.QuotaPlugin interface.
 func (p *cinderPlugin) Resources() []limes.ResourceInfo {
-	result := make([]limes.ResourceInfo, 0, 3*len(p.cfg.VolumeV2.VolumeTypes))
+	result := make([]limes.ResourceInfo, 0, 3*len(p.cfg.VolumeV2.VolumeTypes)+4)
 	for _, volumeType := range p.cfg.VolumeV2.VolumeTypes {
 		category := p.makeResourceName("volumev2", volumeType)
 		result = append(result,
@@ -87,6 +87,34 @@ func (p *cinderPlugin) Resources() []limes.ResourceInfo {
 			},
 		)
 	}
+
+	//these quotas are not specific to any volume type, so they go in the same
+	//category as the resources for the first volume type
+	result = append(result,
+		limes.ResourceInfo{
+			Name:     "backup_gigabytes",
+			Unit:     limes.UnitGibibytes,
+			Category: "volumev2",
+		},
+		limes.ResourceInfo{
+			Name:     "backups",
+			Unit:     limes.UnitNone,
+			Category: "volumev2",
+		},
+		limes.ResourceInfo{
+			Name:     "groups",
+			Unit:     limes.UnitNone,
+			Category: "volumev2",
+		},
+		limes.ResourceInfo{
+			//this is a limit on the size of each individual volume, not a quota in
+			//the usual sense, so it is only reported for informational purposes
+			Name:              "per_volume_gigabytes",
+			Unit:              limes.UnitGibibytes,
+			Category:          "volumev2",
+			ExternallyManaged: true,
+		},
+	)
 	return result
 }
 
@@ -212,6 +240,9 @@ func (p *cinderPlugin) Scrape(provider *gophercloud.ProviderClient, eo gopherclo
 			volumeData[volumeType],
 		)
 	}
+	for _, name := range []string{"backup_gigabytes", "backups", "groups", "per_volume_gigabytes"} {
+		rd[name] = data.QuotaSet[name].ToResourceData(nil)
+	}
 	return rd, "", nil
 }
 
@@ -235,6 +266,10 @@ func (p *cinderPlugin) SetQuota(provider *gophercloud.ProviderClient, eo gopherc
 		requestData.QuotaSet["volumes_"+volumeType] = quotaVolumes
 		requestData.QuotaSet["volumes"] += quotaVolumes
 	}
+	//NOTE: per_volume_gigabytes is externally managed and thus not set here
+	for _, name := range []string{"backup_gigabytes", "backups", "groups"} {
+		requestData.QuotaSet[name] = quotas[name]
+	}
 
 	client, err := openstack.NewBlockStorageV2(provider, eo)
 	if err != nil {
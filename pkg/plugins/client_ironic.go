@@ -57,6 +57,8 @@ type ironicNode struct {
 	ProvisionState       string  `json:"provision_state"`
 	TargetProvisionState *string `json:"target_provision_state"`
 	InstanceID           *string `json:"instance_uuid"`
+	ResourceClass        string  `json:"resource_class"`
+	Maintenance          bool    `json:"maintenance"`
 	Properties           struct {
 		Cores           veryFlexibleUint64 `json:"cpus"`
 		DiskGiB         veryFlexibleUint64 `json:"local_gb"`
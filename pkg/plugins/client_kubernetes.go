@@ -0,0 +1,320 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+//kubernetesClient is a minimal client for the Kubernetes API. (We do not use
+//the official client library because of its huge dependency tree.)
+type kubernetesClient struct {
+	baseURL    string
+	tokenPath  string
+	httpClient *http.Client
+}
+
+func newKubernetesClient(apiConfig core.PrometheusAPIConfiguration, tokenPath string) (*kubernetesClient, error) {
+	if apiConfig.URL == "" {
+		return nil, errors.New("missing configuration parameter: url")
+	}
+	tlsConfig, err := newTLSConfig(apiConfig)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &kubernetesClient{
+		baseURL:    strings.TrimSuffix(apiConfig.URL, "/"),
+		tokenPath:  tokenPath,
+		httpClient: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}, nil
+}
+
+//kubernetesStatusError is returned by kubernetesClient when the API responds
+//with an unexpected status code.
+type kubernetesStatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+//Error implements the builtin/error interface.
+func (e kubernetesStatusError) Error() string {
+	return fmt.Sprintf("%s %s failed: expected 2xx, got %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+func isKubernetesNotFound(err error) bool {
+	var statusErr kubernetesStatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+//do executes a request against the Kubernetes API. If `body` is not nil, it
+//is marshalled into JSON and sent with the given content type. If `result` is
+//not nil, the response body is unmarshalled into it.
+func (c *kubernetesClient) do(method, path string, query url.Values, contentType string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+
+	reqURL := c.baseURL + path
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, reqURL, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.tokenPath != "" {
+		//read the token on every request since it may be rotated
+		token, err := ioutil.ReadFile(c.tokenPath)
+		if err != nil {
+			return fmt.Errorf("cannot read token: %s", err.Error())
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %s", method, path, err.Error())
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %s", method, path, err.Error())
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return kubernetesStatusError{method, path, resp.StatusCode, strings.TrimSpace(string(respBody))}
+	}
+	if result == nil {
+		return nil
+	}
+	err = json.Unmarshal(respBody, result)
+	if err != nil {
+		return fmt.Errorf("%s %s returned invalid JSON: %s", method, path, err.Error())
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// API objects
+
+type kubernetesObjectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type kubernetesNamespace struct {
+	Metadata kubernetesObjectMeta `json:"metadata"`
+}
+
+type kubernetesResourceQuota struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Metadata   kubernetesObjectMeta `json:"metadata"`
+	Spec       struct {
+		Hard map[string]string `json:"hard"`
+	} `json:"spec"`
+	Status struct {
+		Hard map[string]string `json:"hard,omitempty"`
+		Used map[string]string `json:"used,omitempty"`
+	} `json:"status"`
+}
+
+type kubernetesNode struct {
+	Metadata kubernetesObjectMeta `json:"metadata"`
+	Spec     struct {
+		Taints []struct {
+			Key    string `json:"key"`
+			Value  string `json:"value"`
+			Effect string `json:"effect"`
+		} `json:"taints"`
+	} `json:"spec"`
+	Status struct {
+		Allocatable map[string]string `json:"allocatable"`
+	} `json:"status"`
+}
+
+//ListNamespaces lists all namespaces matching the given label selector.
+func (c *kubernetesClient) ListNamespaces(labelSelector string) ([]kubernetesNamespace, error) {
+	var data struct {
+		Items []kubernetesNamespace `json:"items"`
+	}
+	err := c.do(http.MethodGet, "/api/v1/namespaces", url.Values{"labelSelector": {labelSelector}}, "", nil, &data)
+	return data.Items, err
+}
+
+//ListNodes lists all nodes matching the given label selector (or all nodes
+//if the label selector is empty).
+func (c *kubernetesClient) ListNodes(labelSelector string) ([]kubernetesNode, error) {
+	var query url.Values
+	if labelSelector != "" {
+		query = url.Values{"labelSelector": {labelSelector}}
+	}
+	var data struct {
+		Items []kubernetesNode `json:"items"`
+	}
+	err := c.do(http.MethodGet, "/api/v1/nodes", query, "", nil, &data)
+	return data.Items, err
+}
+
+//GetResourceQuota returns the ResourceQuota with the given name, or nil if it
+//does not exist.
+func (c *kubernetesClient) GetResourceQuota(namespace, name string) (*kubernetesResourceQuota, error) {
+	var rq kubernetesResourceQuota
+	err := c.do(http.MethodGet, resourceQuotaPath(namespace, name), nil, "", nil, &rq)
+	if isKubernetesNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rq, nil
+}
+
+//SetResourceQuotaHard updates the spec.hard field of the ResourceQuota with
+//the given name, or creates the ResourceQuota if it does not exist yet.
+func (c *kubernetesClient) SetResourceQuotaHard(namespace, name string, hard map[string]string) error {
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{"hard": hard},
+	}
+	err := c.do(http.MethodPatch, resourceQuotaPath(namespace, name), nil, "application/merge-patch+json", patch, nil)
+	if !isKubernetesNotFound(err) {
+		return err
+	}
+
+	rq := kubernetesResourceQuota{
+		APIVersion: "v1",
+		Kind:       "ResourceQuota",
+		Metadata:   kubernetesObjectMeta{Name: name, Namespace: namespace},
+	}
+	rq.Spec.Hard = hard
+	path := fmt.Sprintf("/api/v1/namespaces/%s/resourcequotas", url.PathEscape(namespace))
+	return c.do(http.MethodPost, path, nil, "application/json", rq, nil)
+}
+
+func resourceQuotaPath(namespace, name string) string {
+	return fmt.Sprintf("/api/v1/namespaces/%s/resourcequotas/%s", url.PathEscape(namespace), url.PathEscape(name))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// quantities
+
+var kubernetesQuantityRx = regexp.MustCompile(`^([+-]?[0-9.]+)(?:([eE][+-]?[0-9]+)|([numkMGTPE]|[KMGTPE]i))?$`)
+
+var kubernetesQuantitySuffixes = map[string]*big.Rat{
+	"":   big.NewRat(1, 1),
+	"n":  big.NewRat(1, 1000000000),
+	"u":  big.NewRat(1, 1000000),
+	"m":  big.NewRat(1, 1000),
+	"k":  big.NewRat(1000, 1),
+	"M":  new(big.Rat).SetInt64(1e6),
+	"G":  new(big.Rat).SetInt64(1e9),
+	"T":  new(big.Rat).SetInt64(1e12),
+	"P":  new(big.Rat).SetInt64(1e15),
+	"E":  new(big.Rat).SetInt64(1e18),
+	"Ki": new(big.Rat).SetInt64(1 << 10),
+	"Mi": new(big.Rat).SetInt64(1 << 20),
+	"Gi": new(big.Rat).SetInt64(1 << 30),
+	"Ti": new(big.Rat).SetInt64(1 << 40),
+	"Pi": new(big.Rat).SetInt64(1 << 50),
+	"Ei": new(big.Rat).SetInt64(1 << 60),
+}
+
+//parseKubernetesQuantity parses a quantity string like "500m" or "1.5Gi".
+func parseKubernetesQuantity(str string) (*big.Rat, error) {
+	match := kubernetesQuantityRx.FindStringSubmatch(strings.TrimSpace(str))
+	if match == nil {
+		return nil, fmt.Errorf("invalid quantity: %q", str)
+	}
+	number, ok := new(big.Rat).SetString(match[1])
+	if !ok {
+		return nil, fmt.Errorf("invalid quantity: %q", str)
+	}
+
+	if exponent := match[2]; exponent != "" {
+		//decimal exponent, e.g. "1e3" (the big.Rat parser understands this notation)
+		result, ok := new(big.Rat).SetString(match[1] + exponent)
+		if !ok {
+			return nil, fmt.Errorf("invalid quantity: %q", str)
+		}
+		return result, nil
+	}
+	return number.Mul(number, kubernetesQuantitySuffixes[match[3]]), nil
+}
+
+//convertKubernetesQuantity parses a quantity string and converts it into a
+//value of the given unit. The quantity is assumed to be measured in the base
+//unit of the given unit (e.g. bytes for limes.UnitMebibytes). Fractional values
+//are rounded up if `roundUp` is true, and down otherwise. Negative values are
+//clamped to 0.
+func convertKubernetesQuantity(str string, unit limes.Unit, roundUp bool) (uint64, error) {
+	quantity, err := parseKubernetesQuantity(str)
+	if err != nil {
+		return 0, err
+	}
+	if quantity.Sign() < 0 {
+		return 0, nil
+	}
+	_, multiple := unit.Base()
+	quantity.Quo(quantity, new(big.Rat).SetInt(new(big.Int).SetUint64(multiple)))
+
+	result, remainder := new(big.Int).QuoRem(quantity.Num(), quantity.Denom(), new(big.Int))
+	if roundUp && remainder.Sign() != 0 {
+		result.Add(result, big.NewInt(1))
+	}
+	if !result.IsUint64() {
+		return 0, fmt.Errorf("quantity out of range: %q", str)
+	}
+	return result.Uint64(), nil
+}
+
+//formatKubernetesQuantity formats a value of the given unit as a quantity
+//string in the base unit of that unit.
+func formatKubernetesQuantity(value uint64, unit limes.Unit) string {
+	_, multiple := unit.Base()
+	result := new(big.Int).Mul(new(big.Int).SetUint64(value), new(big.Int).SetUint64(multiple))
+	return result.String()
+}
@@ -0,0 +1,263 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+type genericHTTPPlugin struct {
+	cfg       core.ServiceConfiguration
+	resources []limes.ResourceInfo
+}
+
+func init() {
+	core.RegisterConfigurableQuotaPlugin("generic-http", func(c core.ServiceConfiguration, scrapeSubresources map[string]bool) core.QuotaPlugin {
+		return &genericHTTPPlugin{cfg: c}
+	})
+}
+
+//Init implements the core.QuotaPlugin interface.
+func (p *genericHTTPPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
+	client, err := p.newClient(provider, eo)
+	if err != nil {
+		return err
+	}
+	resources, err := client.GetResources()
+	if err != nil {
+		return fmt.Errorf("cannot list resources: %s", err.Error())
+	}
+	if len(resources) == 0 {
+		return errors.New("service does not advertise any resources")
+	}
+
+	p.resources = make([]limes.ResourceInfo, len(resources))
+	for idx, res := range resources {
+		if res.Name == "" {
+			return fmt.Errorf("resource #%d does not have a name", idx)
+		}
+		if !isValidUnit(res.Unit) {
+			return fmt.Errorf("resource %s has unknown unit %q", res.Name, res.Unit)
+		}
+		p.resources[idx] = limes.ResourceInfo{
+			Name:              res.Name,
+			Unit:              res.Unit,
+			Category:          res.Category,
+			ExternallyManaged: res.ExternallyManaged,
+			NoQuota:           res.NoQuota,
+		}
+	}
+	return nil
+}
+
+//ServiceInfo implements the core.QuotaPlugin interface.
+func (p *genericHTTPPlugin) ServiceInfo() limes.ServiceInfo {
+	productName := p.cfg.GenericHTTP.ProductName
+	if productName == "" {
+		productName = p.cfg.Type
+	}
+	return limes.ServiceInfo{
+		Type:        p.cfg.Type,
+		ProductName: productName,
+		Area:        p.cfg.GenericHTTP.Area,
+	}
+}
+
+//Resources implements the core.QuotaPlugin interface.
+func (p *genericHTTPPlugin) Resources() []limes.ResourceInfo {
+	return p.resources
+}
+
+//Rates implements the core.QuotaPlugin interface.
+func (p *genericHTTPPlugin) Rates() []limes.RateInfo {
+	return nil
+}
+
+//ScrapeRates implements the core.QuotaPlugin interface.
+func (p *genericHTTPPlugin) ScrapeRates(client *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, prevSerializedState string) (result map[string]*big.Int, serializedState string, err error) {
+	return nil, "", nil
+}
+
+//Scrape implements the core.QuotaPlugin interface.
+func (p *genericHTTPPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string) (map[string]core.ResourceData, string, error) {
+	client, err := p.newClient(provider, eo)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := client.GetProject(domainUUID, projectUUID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := make(map[string]core.ResourceData, len(p.resources))
+	for _, res := range p.resources {
+		resData, exists := data[res.Name]
+		if !exists {
+			return nil, "", fmt.Errorf("no data reported for resource %s", res.Name)
+		}
+		quota := int64(-1)
+		if resData.Quota != nil {
+			quota = *resData.Quota
+		}
+		result[res.Name] = core.ResourceData{
+			Quota:         quota,
+			Usage:         resData.Usage,
+			PhysicalUsage: resData.PhysicalUsage,
+			Subresources:  resData.Subresources,
+		}
+	}
+	return result, "", nil
+}
+
+//SetQuota implements the core.QuotaPlugin interface.
+func (p *genericHTTPPlugin) SetQuota(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, quotas map[string]uint64) error {
+	client, err := p.newClient(provider, eo)
+	if err != nil {
+		return err
+	}
+
+	quotasToSet := make(map[string]uint64, len(p.resources))
+	for _, res := range p.resources {
+		if res.NoQuota || res.ExternallyManaged {
+			continue
+		}
+		quota, exists := quotas[res.Name]
+		if exists {
+			quotasToSet[res.Name] = quota
+		}
+	}
+	return client.SetProjectQuota(domainUUID, projectUUID, quotasToSet)
+}
+
+//DescribeMetrics implements the core.QuotaPlugin interface.
+func (p *genericHTTPPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	//not used by this plugin
+}
+
+//CollectMetrics implements the core.QuotaPlugin interface.
+func (p *genericHTTPPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, domainUUID, projectUUID, serializedMetrics string) error {
+	//not used by this plugin
+	return nil
+}
+
+func (p *genericHTTPPlugin) newClient(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) (*genericHTTPClient, error) {
+	endpointURL := p.cfg.GenericHTTP.URL
+	if endpointURL == "" {
+		eo.ApplyDefaults(p.cfg.Type)
+		var err error
+		endpointURL, err = provider.EndpointLocator(eo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &genericHTTPClient{
+		ServiceClient: &gophercloud.ServiceClient{
+			ProviderClient: provider,
+			Endpoint:       gophercloud.NormalizeURL(endpointURL),
+			Type:           p.cfg.Type,
+		},
+	}, nil
+}
+
+func isValidUnit(unit limes.Unit) bool {
+	switch unit {
+	case limes.UnitNone, limes.UnitBytes, limes.UnitKibibytes, limes.UnitMebibytes,
+		limes.UnitGibibytes, limes.UnitTebibytes, limes.UnitPebibytes, limes.UnitExbibytes:
+		return true
+	default:
+		return false
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Gophercloud client for services implementing the generic quota API
+
+type genericHTTPClient struct {
+	*gophercloud.ServiceClient
+}
+
+type genericHTTPResource struct {
+	Name              string     `json:"name"`
+	Unit              limes.Unit `json:"unit"`
+	Category          string     `json:"category"`
+	ExternallyManaged bool       `json:"externally_managed"`
+	NoQuota           bool       `json:"no_quota"`
+}
+
+type genericHTTPResourceData struct {
+	//pointer because a missing value means "no quota" (i.e. unlimited)
+	Quota         *int64        `json:"quota"`
+	Usage         uint64        `json:"usage"`
+	PhysicalUsage *uint64       `json:"physical_usage"`
+	Subresources  []interface{} `json:"subresources"`
+}
+
+func (c genericHTTPClient) GetResources() ([]genericHTTPResource, error) {
+	var result gophercloud.Result
+	_, result.Err = c.Get(c.ServiceURL("resources"), &result.Body, &gophercloud.RequestOpts{
+		OkCodes: []int{http.StatusOK},
+	})
+	var data struct {
+		Resources []genericHTTPResource `json:"resources"`
+	}
+	err := result.ExtractInto(&data)
+	return data.Resources, err
+}
+
+func (c genericHTTPClient) GetProject(domainUUID, projectUUID string) (map[string]genericHTTPResourceData, error) {
+	var result gophercloud.Result
+	_, result.Err = c.Get(c.projectURL(domainUUID, projectUUID), &result.Body, &gophercloud.RequestOpts{
+		OkCodes: []int{http.StatusOK},
+	})
+	var data struct {
+		Resources map[string]genericHTTPResourceData `json:"resources"`
+	}
+	err := result.ExtractInto(&data)
+	return data.Resources, err
+}
+
+func (c genericHTTPClient) SetProjectQuota(domainUUID, projectUUID string, quotas map[string]uint64) error {
+	type resourceQuota struct {
+		Quota uint64 `json:"quota"`
+	}
+	resources := make(map[string]resourceQuota, len(quotas))
+	for resourceName, quota := range quotas {
+		resources[resourceName] = resourceQuota{quota}
+	}
+	body := map[string]interface{}{"resources": resources}
+	_, err := c.Put(c.projectURL(domainUUID, projectUUID), body, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{http.StatusOK, http.StatusAccepted, http.StatusNoContent},
+	})
+	return err
+}
+
+func (c genericHTTPClient) projectURL(domainUUID, projectUUID string) string {
+	query := url.Values{"domain_id": {domainUUID}}
+	return c.ServiceURL("projects", url.PathEscape(projectUUID)) + "?" + query.Encode()
+}
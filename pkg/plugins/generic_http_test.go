@@ -0,0 +1,148 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+//fakeGenericHTTPService implements the REST contract that is expected by
+//genericHTTPPlugin.
+type fakeGenericHTTPService struct {
+	Quotas map[string]int64
+	Usage  map[string]uint64
+}
+
+func (s *fakeGenericHTTPService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1/resources":
+		respondJSON(w, http.StatusOK, map[string]interface{}{"resources": []map[string]interface{}{
+			{"name": "things", "category": "stuff"},
+			{"name": "capacity", "unit": "MiB", "category": "stuff"},
+			{"name": "other_things", "no_quota": true},
+		}})
+
+	case r.URL.Path == "/v1/projects/uuid-for-project" && r.URL.Query().Get("domain_id") == "uuid-for-domain":
+		switch r.Method {
+		case "GET":
+			resources := make(map[string]interface{})
+			for resourceName, usage := range s.Usage {
+				data := map[string]interface{}{"usage": usage}
+				if quota, exists := s.Quotas[resourceName]; exists {
+					data["quota"] = quota
+				}
+				resources[resourceName] = data
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{"resources": resources})
+		case "PUT":
+			var data struct {
+				Resources map[string]struct {
+					Quota int64 `json:"quota"`
+				} `json:"resources"`
+			}
+			err := json.NewDecoder(r.Body).Decode(&data)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for resourceName, resData := range data.Resources {
+				s.Quotas[resourceName] = resData.Quota
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func TestGenericHTTPPlugin(t *testing.T) {
+	service := &fakeGenericHTTPService{
+		Quotas: map[string]int64{"things": 10, "capacity": 1024},
+		Usage:  map[string]uint64{"things": 2, "capacity": 100, "other_things": 5},
+	}
+	srv := httptest.NewServer(service)
+	defer srv.Close()
+	provider := &gophercloud.ProviderClient{}
+	eo := gophercloud.EndpointOpts{}
+
+	cfg := core.ServiceConfiguration{Type: "unittest", Plugin: "generic-http"}
+	cfg.GenericHTTP.Area = "testing"
+	cfg.GenericHTTP.URL = srv.URL + "/v1"
+	plugin := &genericHTTPPlugin{cfg: cfg}
+	err := plugin.Init(provider, eo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := plugin.ServiceInfo()
+	if info.Type != "unittest" || info.ProductName != "unittest" || info.Area != "testing" {
+		t.Errorf("unexpected ServiceInfo: %#v", info)
+	}
+	expectedResources := []limes.ResourceInfo{
+		{Name: "things", Unit: limes.UnitNone, Category: "stuff"},
+		{Name: "capacity", Unit: limes.UnitMebibytes, Category: "stuff"},
+		{Name: "other_things", Unit: limes.UnitNone, NoQuota: true},
+	}
+	if len(plugin.Resources()) != len(expectedResources) {
+		t.Fatalf("expected resources %#v, but got %#v", expectedResources, plugin.Resources())
+	}
+	for idx, res := range plugin.Resources() {
+		if res != expectedResources[idx] {
+			t.Errorf("expected resource %#v, but got %#v", expectedResources[idx], res)
+		}
+	}
+
+	expectScrape(t, plugin, provider, eo, "uuid-for-project", map[string]core.ResourceData{
+		"things":       {Quota: 10, Usage: 2},
+		"capacity":     {Quota: 1024, Usage: 100},
+		"other_things": {Quota: -1, Usage: 5},
+	})
+
+	//quotas for resources without quota are not sent to the service
+	err = plugin.SetQuota(provider, eo, "uuid-for-domain", "uuid-for-project", map[string]uint64{"things": 20, "capacity": 2048, "other_things": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := service.Quotas["other_things"]; exists {
+		t.Error("expected no quota to be set for other_things")
+	}
+	expectScrape(t, plugin, provider, eo, "uuid-for-project", map[string]core.ResourceData{
+		"things":       {Quota: 20, Usage: 2},
+		"capacity":     {Quota: 2048, Usage: 100},
+		"other_things": {Quota: -1, Usage: 5},
+	})
+
+	//Scrape fails when the service does not report all advertised resources
+	delete(service.Usage, "capacity")
+	_, _, err = plugin.Scrape(provider, eo, "uuid-for-domain", "uuid-for-project")
+	if err == nil {
+		t.Error("expected Scrape to fail for missing resource, but got no error")
+	}
+}
@@ -0,0 +1,182 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/gophercloud/gophercloud/pagination"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+type glancePlugin struct {
+	cfg       core.ServiceConfiguration
+	resources []limes.ResourceInfo
+}
+
+var glanceResources = []limes.ResourceInfo{
+	{
+		Name: "image_count_total",
+		Unit: limes.UnitNone,
+	},
+	{
+		Name: "image_count_uploading",
+		Unit: limes.UnitNone,
+	},
+	{
+		Name: "image_size_total",
+		Unit: limes.UnitMebibytes,
+	},
+	{
+		Name: "image_stage_total",
+		Unit: limes.UnitMebibytes,
+	},
+}
+
+func init() {
+	core.RegisterQuotaPlugin(func(c core.ServiceConfiguration, scrapeSubresources map[string]bool) core.QuotaPlugin {
+		return &glancePlugin{cfg: c}
+	})
+}
+
+//Init implements the core.QuotaPlugin interface.
+func (p *glancePlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
+	//Glance does not have a quota API of its own, so quotas can only be managed
+	//when they are enforced through Keystone unified limits
+	p.resources = make([]limes.ResourceInfo, len(glanceResources))
+	for idx, res := range glanceResources {
+		res.ExternallyManaged = p.cfg.QuotaBackend == ""
+		p.resources[idx] = res
+	}
+	return nil
+}
+
+//ServiceInfo implements the core.QuotaPlugin interface.
+func (p *glancePlugin) ServiceInfo() limes.ServiceInfo {
+	return limes.ServiceInfo{
+		Type:        "image",
+		ProductName: "glance",
+		Area:        "storage",
+	}
+}
+
+//Resources implements the core.QuotaPlugin interface.
+func (p *glancePlugin) Resources() []limes.ResourceInfo {
+	return p.resources
+}
+
+//Rates implements the core.QuotaPlugin interface.
+func (p *glancePlugin) Rates() []limes.RateInfo {
+	return nil
+}
+
+//ScrapeRates implements the core.QuotaPlugin interface.
+func (p *glancePlugin) ScrapeRates(client *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, prevSerializedState string) (result map[string]*big.Int, serializedState string, err error) {
+	return nil, "", nil
+}
+
+//Scrape implements the core.QuotaPlugin interface.
+func (p *glancePlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string) (map[string]core.ResourceData, string, error) {
+	//Glance does not have a quota API, so this is the same as ScrapeUsage()
+	return p.ScrapeUsage(provider, eo, domainUUID, projectUUID)
+}
+
+//ScrapeUsage implements the core.UsageOnlyQuotaPlugin interface.
+func (p *glancePlugin) ScrapeUsage(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string) (map[string]core.ResourceData, string, error) {
+	client, err := openstack.NewImageServiceV2(provider, eo)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//This mirrors how Glance computes usage for its unified limits: Image data
+	//that is still being uploaded or imported counts towards
+	//"image_stage_total", all other image data counts towards "image_size_total".
+	var (
+		countTotal     uint64
+		countUploading uint64
+		bytesTotal     uint64
+		bytesStaged    uint64
+	)
+	//hidden images are not included in the default listing, so we need to list them separately
+	for _, hidden := range []bool{false, true} {
+		opts := images.ListOpts{Owner: projectUUID, Hidden: hidden}
+		err := images.List(client, opts).EachPage(func(page pagination.Page) (bool, error) {
+			imageList, err := images.ExtractImages(page)
+			if err != nil {
+				return false, err
+			}
+			for _, image := range imageList {
+				if image.SizeBytes < 0 {
+					//should not happen, but avoid underflow when summing up
+					image.SizeBytes = 0
+				}
+				countTotal++
+				switch string(image.Status) {
+				case "uploading", "importing":
+					countUploading++
+					bytesStaged += uint64(image.SizeBytes)
+				case "saving":
+					countUploading++
+					bytesTotal += uint64(image.SizeBytes)
+				default:
+					bytesTotal += uint64(image.SizeBytes)
+				}
+			}
+			return true, nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return map[string]core.ResourceData{
+		"image_count_total":     {Quota: -1, Usage: countTotal},
+		"image_count_uploading": {Quota: -1, Usage: countUploading},
+		"image_size_total":      {Quota: -1, Usage: roundUpToMebibytes(bytesTotal)},
+		"image_stage_total":     {Quota: -1, Usage: roundUpToMebibytes(bytesStaged)},
+	}, "", nil
+}
+
+//SetQuota implements the core.QuotaPlugin interface.
+func (p *glancePlugin) SetQuota(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, quotas map[string]uint64) error {
+	return errors.New("Glance quotas can only be set through Keystone unified limits (see quota_backend option)")
+}
+
+//DescribeMetrics implements the core.QuotaPlugin interface.
+func (p *glancePlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	//not used by this plugin
+}
+
+//CollectMetrics implements the core.QuotaPlugin interface.
+func (p *glancePlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, domainUUID, projectUUID, serializedMetrics string) error {
+	//not used by this plugin
+	return nil
+}
+
+func roundUpToMebibytes(bytes uint64) uint64 {
+	const mebibyte = 1 << 20
+	return (bytes + mebibyte - 1) / mebibyte
+}
@@ -0,0 +1,41 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+//expectScrape runs plugin.Scrape() for the given project (in the domain
+//"uuid-for-domain") and checks that it returns exactly the expected result.
+func expectScrape(t *testing.T, plugin core.QuotaPlugin, provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, projectUUID string, expected map[string]core.ResourceData) {
+	t.Helper()
+	result, _, err := plugin.Scrape(provider, eo, "uuid-for-domain", projectUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, result)
+	}
+}
@@ -0,0 +1,328 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"net/url"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+//keystoneLimitsPlugin wraps the QuotaPlugin for a service whose quota is
+//enforced through Keystone unified limits. Usage is still scraped by the
+//wrapped plugin (without using the service's quota API, which may not exist
+//anymore), but quota is read from and written into Keystone's project limits.
+type keystoneLimitsPlugin struct {
+	inner     core.QuotaPlugin
+	cfg       core.KeystoneLimitsConfiguration
+	serviceID string
+}
+
+func init() {
+	core.RegisterQuotaBackend("keystone", func(plugin core.QuotaPlugin, c core.ServiceConfiguration) core.QuotaPlugin {
+		return &keystoneLimitsPlugin{inner: plugin, cfg: c.KeystoneLimits}
+	})
+}
+
+//Init implements the core.QuotaPlugin interface.
+func (p *keystoneLimitsPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
+	if _, ok := p.inner.(core.UsageOnlyQuotaPlugin); !ok {
+		return fmt.Errorf("service %s cannot use Keystone unified limits because its plugin cannot scrape usage without the service's quota API", p.inner.ServiceInfo().Type)
+	}
+	err := p.inner.Init(provider, eo)
+	if err != nil {
+		return err
+	}
+
+	p.serviceID = p.cfg.ServiceID
+	if p.serviceID != "" {
+		return nil
+	}
+	client, err := newKeystoneLimitsClient(provider, eo)
+	if err != nil {
+		return err
+	}
+	serviceType := p.inner.ServiceInfo().Type
+	p.serviceID, err = client.GetServiceID(serviceType)
+	if err != nil {
+		return fmt.Errorf("cannot find Keystone service ID for service type %q: %s", serviceType, err.Error())
+	}
+	return nil
+}
+
+//ServiceInfo implements the core.QuotaPlugin interface.
+func (p *keystoneLimitsPlugin) ServiceInfo() limes.ServiceInfo {
+	return p.inner.ServiceInfo()
+}
+
+//Resources implements the core.QuotaPlugin interface.
+func (p *keystoneLimitsPlugin) Resources() []limes.ResourceInfo {
+	return p.inner.Resources()
+}
+
+//Rates implements the core.QuotaPlugin interface.
+func (p *keystoneLimitsPlugin) Rates() []limes.RateInfo {
+	return p.inner.Rates()
+}
+
+//ScrapeRates implements the core.QuotaPlugin interface.
+func (p *keystoneLimitsPlugin) ScrapeRates(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, prevSerializedState string) (result map[string]*big.Int, serializedState string, err error) {
+	return p.inner.ScrapeRates(provider, eo, domainUUID, projectUUID, prevSerializedState)
+}
+
+//Scrape implements the core.QuotaPlugin interface.
+func (p *keystoneLimitsPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string) (map[string]core.ResourceData, string, error) {
+	result, serializedMetrics, err := p.inner.(core.UsageOnlyQuotaPlugin).ScrapeUsage(provider, eo, domainUUID, projectUUID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client, err := newKeystoneLimitsClient(provider, eo)
+	if err != nil {
+		return nil, "", err
+	}
+	registeredLimits, err := client.ListRegisteredLimits(p.serviceID, p.cfg.RegionID)
+	if err != nil {
+		return nil, "", err
+	}
+	projectLimits, err := client.ListLimits(p.serviceID, p.cfg.RegionID, projectUUID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, res := range p.Resources() {
+		if res.NoQuota {
+			continue
+		}
+		data := result[res.Name]
+		keystoneName := p.keystoneResourceName(res.Name)
+		if limit, exists := projectLimits[keystoneName]; exists {
+			data.Quota = limit.ResourceLimit
+		} else if limit, exists := registeredLimits[keystoneName]; exists {
+			data.Quota = limit.DefaultLimit
+		} else {
+			//services using oslo.limit treat a missing registered limit as a limit of 0
+			data.Quota = 0
+		}
+		result[res.Name] = data
+	}
+
+	return result, serializedMetrics, nil
+}
+
+//SetQuota implements the core.QuotaPlugin interface.
+func (p *keystoneLimitsPlugin) SetQuota(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, quotas map[string]uint64) error {
+	client, err := newKeystoneLimitsClient(provider, eo)
+	if err != nil {
+		return err
+	}
+	projectLimits, err := client.ListLimits(p.serviceID, p.cfg.RegionID, projectUUID)
+	if err != nil {
+		return err
+	}
+
+	var newLimits []keystoneLimit
+	for _, res := range p.Resources() {
+		if res.NoQuota || res.ExternallyManaged {
+			continue
+		}
+		quota, exists := quotas[res.Name]
+		if !exists {
+			continue
+		}
+		if quota > keystoneMaxResourceLimit {
+			return fmt.Errorf("cannot set quota for %s to %d: Keystone does not support limits above %d",
+				res.Name, quota, keystoneMaxResourceLimit)
+		}
+
+		keystoneName := p.keystoneResourceName(res.Name)
+		limit, exists := projectLimits[keystoneName]
+		switch {
+		case !exists:
+			newLimits = append(newLimits, keystoneLimit{
+				ServiceID:     p.serviceID,
+				RegionID:      p.cfg.RegionID,
+				ProjectID:     projectUUID,
+				ResourceName:  keystoneName,
+				ResourceLimit: int64(quota),
+			})
+		case limit.ResourceLimit != int64(quota):
+			err := client.UpdateLimit(limit.ID, int64(quota))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(newLimits) == 0 {
+		return nil
+	}
+	return client.CreateLimits(newLimits)
+}
+
+//DescribeMetrics implements the core.QuotaPlugin interface.
+func (p *keystoneLimitsPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	p.inner.DescribeMetrics(ch)
+}
+
+//CollectMetrics implements the core.QuotaPlugin interface.
+func (p *keystoneLimitsPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, domainUUID, projectUUID, serializedMetrics string) error {
+	return p.inner.CollectMetrics(ch, clusterID, domainUUID, projectUUID, serializedMetrics)
+}
+
+func (p *keystoneLimitsPlugin) keystoneResourceName(resourceName string) string {
+	if name, exists := p.cfg.ResourceNames[resourceName]; exists {
+		return name
+	}
+	return resourceName
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Gophercloud client for Keystone unified limits
+
+//Keystone stores limits in a 32-bit integer column.
+const keystoneMaxResourceLimit = math.MaxInt32
+
+type keystoneLimitsClient struct {
+	*gophercloud.ServiceClient
+}
+
+func newKeystoneLimitsClient(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) (*keystoneLimitsClient, error) {
+	client, err := openstack.NewIdentityV3(provider, eo)
+	if err != nil {
+		return nil, err
+	}
+	return &keystoneLimitsClient{client}, nil
+}
+
+type keystoneLimit struct {
+	ID            string `json:"id,omitempty"`
+	ServiceID     string `json:"service_id"`
+	RegionID      string `json:"region_id,omitempty"`
+	ProjectID     string `json:"project_id"`
+	ResourceName  string `json:"resource_name"`
+	ResourceLimit int64  `json:"resource_limit"`
+}
+
+type keystoneRegisteredLimit struct {
+	ID           string `json:"id"`
+	ServiceID    string `json:"service_id"`
+	RegionID     string `json:"region_id"`
+	ResourceName string `json:"resource_name"`
+	DefaultLimit int64  `json:"default_limit"`
+}
+
+func (c keystoneLimitsClient) GetServiceID(serviceType string) (string, error) {
+	query := url.Values{"type": {serviceType}}
+	var data struct {
+		Services []struct {
+			ID string `json:"id"`
+		} `json:"services"`
+	}
+	err := c.getJSON(c.ServiceURL("services")+"?"+query.Encode(), &data)
+	if err != nil {
+		return "", err
+	}
+	switch len(data.Services) {
+	case 0:
+		return "", errors.New("no such service")
+	case 1:
+		return data.Services[0].ID, nil
+	default:
+		return "", errors.New("multiple services found (please configure keystone_limits.service_id)")
+	}
+}
+
+//ListRegisteredLimits returns the registered limits for the given service,
+//keyed by resource name.
+func (c keystoneLimitsClient) ListRegisteredLimits(serviceID, regionID string) (map[string]keystoneRegisteredLimit, error) {
+	query := url.Values{"service_id": {serviceID}}
+	if regionID != "" {
+		query.Set("region_id", regionID)
+	}
+	var data struct {
+		RegisteredLimits []keystoneRegisteredLimit `json:"registered_limits"`
+	}
+	err := c.getJSON(c.ServiceURL("registered_limits")+"?"+query.Encode(), &data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]keystoneRegisteredLimit, len(data.RegisteredLimits))
+	for _, limit := range data.RegisteredLimits {
+		result[limit.ResourceName] = limit
+	}
+	return result, nil
+}
+
+//ListLimits returns the project limits for the given service and project,
+//keyed by resource name.
+func (c keystoneLimitsClient) ListLimits(serviceID, regionID, projectID string) (map[string]keystoneLimit, error) {
+	query := url.Values{"service_id": {serviceID}, "project_id": {projectID}}
+	if regionID != "" {
+		query.Set("region_id", regionID)
+	}
+	var data struct {
+		Limits []keystoneLimit `json:"limits"`
+	}
+	err := c.getJSON(c.ServiceURL("limits")+"?"+query.Encode(), &data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]keystoneLimit, len(data.Limits))
+	for _, limit := range data.Limits {
+		result[limit.ResourceName] = limit
+	}
+	return result, nil
+}
+
+func (c keystoneLimitsClient) CreateLimits(limits []keystoneLimit) error {
+	body := map[string]interface{}{"limits": limits}
+	_, err := c.Post(c.ServiceURL("limits"), body, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{http.StatusCreated},
+	})
+	return err
+}
+
+func (c keystoneLimitsClient) UpdateLimit(limitID string, resourceLimit int64) error {
+	body := map[string]interface{}{"limit": map[string]interface{}{"resource_limit": resourceLimit}}
+	_, err := c.Patch(c.ServiceURL("limits", limitID), body, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{http.StatusOK},
+	})
+	return err
+}
+
+func (c keystoneLimitsClient) getJSON(url string, data interface{}) error {
+	var result gophercloud.Result
+	_, result.Err = c.Get(url, &result.Body, &gophercloud.RequestOpts{
+		OkCodes: []int{http.StatusOK},
+	})
+	return result.ExtractInto(data)
+}
@@ -0,0 +1,210 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/sapcc/limes/pkg/core"
+	"github.com/sapcc/limes/pkg/test"
+)
+
+//fakeKeystone implements the subset of the Keystone v3 API that is used by
+//keystoneLimitsPlugin.
+type fakeKeystone struct {
+	RegisteredLimits []keystoneRegisteredLimit
+	Limits           []keystoneLimit
+}
+
+func (k *fakeKeystone) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v3/services":
+		var services []map[string]string
+		if query.Get("type") == "unittest" {
+			services = append(services, map[string]string{"id": "service-unittest", "type": "unittest"})
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"services": services})
+
+	case r.Method == "GET" && r.URL.Path == "/v3/registered_limits":
+		result := []keystoneRegisteredLimit{}
+		for _, limit := range k.RegisteredLimits {
+			if limit.ServiceID == query.Get("service_id") {
+				result = append(result, limit)
+			}
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"registered_limits": result})
+
+	case r.Method == "GET" && r.URL.Path == "/v3/limits":
+		result := []keystoneLimit{}
+		for _, limit := range k.Limits {
+			if limit.ServiceID == query.Get("service_id") && limit.ProjectID == query.Get("project_id") {
+				result = append(result, limit)
+			}
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"limits": result})
+
+	case r.Method == "POST" && r.URL.Path == "/v3/limits":
+		var data struct {
+			Limits []keystoneLimit `json:"limits"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, limit := range data.Limits {
+			limit.ID = fmt.Sprintf("limit-%d", len(k.Limits)+1)
+			k.Limits = append(k.Limits, limit)
+		}
+		respondJSON(w, http.StatusCreated, map[string]interface{}{"limits": k.Limits})
+
+	case r.Method == "PATCH" && strings.HasPrefix(r.URL.Path, "/v3/limits/"):
+		var data struct {
+			Limit struct {
+				ResourceLimit int64 `json:"resource_limit"`
+			} `json:"limit"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for idx, limit := range k.Limits {
+			if limit.ID == strings.TrimPrefix(r.URL.Path, "/v3/limits/") {
+				k.Limits[idx].ResourceLimit = data.Limit.ResourceLimit
+				respondJSON(w, http.StatusOK, map[string]interface{}{"limit": k.Limits[idx]})
+				return
+			}
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func respondJSON(w http.ResponseWriter, code int, data interface{}) {
+	buf, _ := json.Marshal(data)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(buf)
+}
+
+func TestKeystoneLimitsPlugin(t *testing.T) {
+	keystone := &fakeKeystone{
+		RegisteredLimits: []keystoneRegisteredLimit{
+			{ID: "reglimit-1", ServiceID: "service-unittest", ResourceName: "capacity", DefaultLimit: 10},
+		},
+	}
+	srv := httptest.NewServer(keystone)
+	defer srv.Close()
+	provider := &gophercloud.ProviderClient{IdentityBase: srv.URL + "/"}
+	eo := gophercloud.EndpointOpts{}
+
+	//plugins that need their service's quota API to scrape usage are rejected
+	plugin := &keystoneLimitsPlugin{inner: test.NewPlugin("unittest")}
+	err := plugin.Init(provider, eo)
+	if err == nil {
+		t.Error("expected Init to fail for plugin without ScrapeUsage, but got no error")
+	}
+
+	plugin = &keystoneLimitsPlugin{
+		inner: usageOnlyTestPlugin{test.NewPlugin("unittest")},
+		cfg: core.KeystoneLimitsConfiguration{
+			ResourceNames: map[string]string{"things": "class:THINGS"},
+		},
+	}
+	err = plugin.Init(provider, eo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plugin.serviceID != "service-unittest" {
+		t.Errorf("expected service ID %q, but got %q", "service-unittest", plugin.serviceID)
+	}
+
+	//without project limits, quota is taken from the registered limits (or 0
+	//if there is none), but usage is still taken from the wrapped plugin
+	zero := uint64(0)
+	thingsSubresources := []interface{}{
+		map[string]interface{}{"index": 0},
+		map[string]interface{}{"index": 1},
+	}
+	expectScrape(t, plugin, provider, eo, "uuid-for-project", map[string]core.ResourceData{
+		"capacity":         {Quota: 10, Usage: 0, PhysicalUsage: &zero},
+		"capacity_portion": {Quota: 0, Usage: 0},
+		"things":           {Quota: 0, Usage: 2, Subresources: thingsSubresources},
+	})
+
+	//first SetQuota creates project limits
+	err = plugin.SetQuota(provider, eo, "uuid-for-domain", "uuid-for-project", map[string]uint64{"capacity": 20, "things": 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keystone.Limits) != 2 {
+		t.Fatalf("expected 2 project limits, but got %#v", keystone.Limits)
+	}
+	expectScrape(t, plugin, provider, eo, "uuid-for-project", map[string]core.ResourceData{
+		"capacity":         {Quota: 20, Usage: 0, PhysicalUsage: &zero},
+		"capacity_portion": {Quota: 0, Usage: 0},
+		"things":           {Quota: 5, Usage: 2, Subresources: thingsSubresources},
+	})
+
+	//second SetQuota updates existing project limits
+	err = plugin.SetQuota(provider, eo, "uuid-for-domain", "uuid-for-project", map[string]uint64{"capacity": 20, "things": 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keystone.Limits) != 2 {
+		t.Fatalf("expected 2 project limits, but got %#v", keystone.Limits)
+	}
+	expectScrape(t, plugin, provider, eo, "uuid-for-project", map[string]core.ResourceData{
+		"capacity":         {Quota: 20, Usage: 0, PhysicalUsage: &zero},
+		"capacity_portion": {Quota: 0, Usage: 0},
+		"things":           {Quota: 7, Usage: 2, Subresources: thingsSubresources},
+	})
+
+	//quota values beyond the range of Keystone's limits are rejected
+	err = plugin.SetQuota(provider, eo, "uuid-for-domain", "uuid-for-project", map[string]uint64{"capacity": 1 << 40, "things": 7})
+	if err == nil {
+		t.Error("expected SetQuota to fail for oversized quota value, but got no error")
+	}
+}
+
+//usageOnlyTestPlugin is a test.Plugin for a service whose quota API has been
+//removed in favor of Keystone unified limits.
+type usageOnlyTestPlugin struct {
+	*test.Plugin
+}
+
+func (p usageOnlyTestPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string) (map[string]core.ResourceData, string, error) {
+	return nil, "", errors.New("quota API is not available")
+}
+
+func (p usageOnlyTestPlugin) ScrapeUsage(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string) (map[string]core.ResourceData, string, error) {
+	return p.Plugin.Scrape(provider, eo, domainUUID, projectUUID)
+}
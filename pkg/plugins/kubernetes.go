@@ -0,0 +1,219 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+type kubernetesPlugin struct {
+	cfg    core.KubernetesConfiguration
+	client *kubernetesClient
+}
+
+var kubernetesResources = []limes.ResourceInfo{
+	{
+		Name:     "persistentvolumeclaims",
+		Unit:     limes.UnitNone,
+		Category: "storage",
+	},
+	{
+		Name:     "requests_cpu",
+		Unit:     limes.UnitNone,
+		Category: "compute",
+	},
+	{
+		Name:     "requests_ephemeral_storage",
+		Unit:     limes.UnitGibibytes,
+		Category: "compute",
+	},
+	{
+		Name:     "requests_memory",
+		Unit:     limes.UnitMebibytes,
+		Category: "compute",
+	},
+	{
+		Name:     "requests_storage",
+		Unit:     limes.UnitGibibytes,
+		Category: "storage",
+	},
+}
+
+//kubernetesQuotaKeys maps each of the kubernetesResources to the key in the
+//ResourceQuota's spec.hard and status.used.
+var kubernetesQuotaKeys = map[string]string{
+	"persistentvolumeclaims":     "persistentvolumeclaims",
+	"requests_cpu":               "requests.cpu",
+	"requests_ephemeral_storage": "requests.ephemeral-storage",
+	"requests_memory":            "requests.memory",
+	"requests_storage":           "requests.storage",
+}
+
+func init() {
+	core.RegisterQuotaPlugin(func(c core.ServiceConfiguration, scrapeSubresources map[string]bool) core.QuotaPlugin {
+		return &kubernetesPlugin{cfg: c.Kubernetes}
+	})
+}
+
+//Init implements the core.QuotaPlugin interface.
+func (p *kubernetesPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) (err error) {
+	if p.cfg.ProjectLabel == "" {
+		p.cfg.ProjectLabel = "openstack.org/project-id"
+	}
+	if p.cfg.ResourceQuotaName == "" {
+		p.cfg.ResourceQuotaName = "limes"
+	}
+	p.client, err = newKubernetesClient(p.cfg.APIConfig, p.cfg.TokenPath)
+	return err
+}
+
+//ServiceInfo implements the core.QuotaPlugin interface.
+func (p *kubernetesPlugin) ServiceInfo() limes.ServiceInfo {
+	return limes.ServiceInfo{
+		Type:        "kubernetes",
+		ProductName: "kubernetes",
+		Area:        "compute",
+	}
+}
+
+//Resources implements the core.QuotaPlugin interface.
+func (p *kubernetesPlugin) Resources() []limes.ResourceInfo {
+	return kubernetesResources
+}
+
+//Rates implements the core.QuotaPlugin interface.
+func (p *kubernetesPlugin) Rates() []limes.RateInfo {
+	return nil
+}
+
+//ScrapeRates implements the core.QuotaPlugin interface.
+func (p *kubernetesPlugin) ScrapeRates(client *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, prevSerializedState string) (result map[string]*big.Int, serializedState string, err error) {
+	return nil, "", nil
+}
+
+//Scrape implements the core.QuotaPlugin interface.
+func (p *kubernetesPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string) (map[string]core.ResourceData, string, error) {
+	result := make(map[string]core.ResourceData, len(kubernetesResources))
+
+	namespace, err := p.findNamespace(projectUUID)
+	if err != nil {
+		return nil, "", err
+	}
+	if namespace == "" {
+		//project does not have a namespace, so it cannot use anything
+		for _, res := range kubernetesResources {
+			result[res.Name] = core.ResourceData{Quota: 0, Usage: 0}
+		}
+		return result, "", nil
+	}
+
+	rq, err := p.client.GetResourceQuota(namespace, p.cfg.ResourceQuotaName)
+	if err != nil {
+		return nil, "", err
+	}
+	if rq == nil {
+		//namespace does not have a ResourceQuota yet, so usage is not tracked
+		//(it will be created on the first SetQuota)
+		for _, res := range kubernetesResources {
+			result[res.Name] = core.ResourceData{Quota: -1, Usage: 0}
+		}
+		return result, "", nil
+	}
+
+	for _, res := range kubernetesResources {
+		key := kubernetesQuotaKeys[res.Name]
+		data := core.ResourceData{Quota: -1}
+		if hard, exists := rq.Spec.Hard[key]; exists {
+			quota, err := convertKubernetesQuantity(hard, res.Unit, false)
+			if err != nil {
+				return nil, "", fmt.Errorf("cannot parse spec.hard[%q] in namespace %s: %s", key, namespace, err.Error())
+			}
+			data.Quota = int64(quota)
+		}
+		if used, exists := rq.Status.Used[key]; exists {
+			data.Usage, err = convertKubernetesQuantity(used, res.Unit, true)
+			if err != nil {
+				return nil, "", fmt.Errorf("cannot parse status.used[%q] in namespace %s: %s", key, namespace, err.Error())
+			}
+		}
+		result[res.Name] = data
+	}
+	return result, "", nil
+}
+
+//SetQuota implements the core.QuotaPlugin interface.
+func (p *kubernetesPlugin) SetQuota(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, quotas map[string]uint64) error {
+	namespace, err := p.findNamespace(projectUUID)
+	if err != nil {
+		return err
+	}
+	if namespace == "" {
+		for _, quota := range quotas {
+			if quota != 0 {
+				return fmt.Errorf("cannot set quota: no namespace has the label %s=%s", p.cfg.ProjectLabel, projectUUID)
+			}
+		}
+		return nil
+	}
+
+	hard := make(map[string]string, len(kubernetesResources))
+	for _, res := range kubernetesResources {
+		quota, exists := quotas[res.Name]
+		if exists {
+			hard[kubernetesQuotaKeys[res.Name]] = formatKubernetesQuantity(quota, res.Unit)
+		}
+	}
+	return p.client.SetResourceQuotaHard(namespace, p.cfg.ResourceQuotaName, hard)
+}
+
+//DescribeMetrics implements the core.QuotaPlugin interface.
+func (p *kubernetesPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	//not used by this plugin
+}
+
+//CollectMetrics implements the core.QuotaPlugin interface.
+func (p *kubernetesPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, domainUUID, projectUUID, serializedMetrics string) error {
+	//not used by this plugin
+	return nil
+}
+
+//findNamespace returns the name of the namespace belonging to the given
+//project, or "" if there is none.
+func (p *kubernetesPlugin) findNamespace(projectUUID string) (string, error) {
+	namespaces, err := p.client.ListNamespaces(p.cfg.ProjectLabel + "=" + projectUUID)
+	if err != nil {
+		return "", err
+	}
+	switch len(namespaces) {
+	case 0:
+		return "", nil
+	case 1:
+		return namespaces[0].Metadata.Name, nil
+	default:
+		return "", fmt.Errorf("found %d namespaces with the label %s=%s, but expected at most one",
+			len(namespaces), p.cfg.ProjectLabel, projectUUID)
+	}
+}
@@ -0,0 +1,278 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+func TestConvertKubernetesQuantity(t *testing.T) {
+	testCases := []struct {
+		Input   string
+		Unit    limes.Unit
+		RoundUp bool
+		Output  uint64
+	}{
+		{"4", limes.UnitNone, false, 4},
+		{"500m", limes.UnitNone, false, 0},
+		{"500m", limes.UnitNone, true, 1},
+		{"2500m", limes.UnitNone, true, 3},
+		{"1.5", limes.UnitNone, true, 2},
+		{"1e3", limes.UnitNone, false, 1000},
+		{"1k", limes.UnitNone, false, 1000},
+		{"128Mi", limes.UnitMebibytes, false, 128},
+		{"1Gi", limes.UnitMebibytes, false, 1024},
+		{"1.5Gi", limes.UnitMebibytes, false, 1536},
+		{"1G", limes.UnitMebibytes, false, 953},
+		{"1G", limes.UnitMebibytes, true, 954},
+		{"1Ei", limes.UnitGibibytes, false, 1 << 30},
+		{"1E", limes.UnitBytes, false, 1000000000000000000},
+		{"-5", limes.UnitNone, false, 0},
+	}
+	for _, tc := range testCases {
+		actual, err := convertKubernetesQuantity(tc.Input, tc.Unit, tc.RoundUp)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", tc.Input, err.Error())
+			continue
+		}
+		if actual != tc.Output {
+			t.Errorf("expected %q to convert into %d %s (roundUp = %t), but got %d", tc.Input, tc.Output, tc.Unit, tc.RoundUp, actual)
+		}
+	}
+
+	for _, input := range []string{"", "abc", "1Xi", "1.2.3", "1e"} {
+		_, err := convertKubernetesQuantity(input, limes.UnitNone, false)
+		if err == nil {
+			t.Errorf("expected error for %q, but got none", input)
+		}
+	}
+
+	if actual := formatKubernetesQuantity(128, limes.UnitMebibytes); actual != "134217728" {
+		t.Errorf("expected 128 MiB to format as %q, but got %q", "134217728", actual)
+	}
+}
+
+//fakeKubernetes implements the subset of the Kubernetes API that is used by
+//kubernetesPlugin.
+type fakeKubernetes struct {
+	Namespaces     []kubernetesNamespace
+	Nodes          []kubernetesNode
+	ResourceQuotas map[string]*kubernetesResourceQuota //key = namespace
+}
+
+func (k *fakeKubernetes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret-token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/"), "/")
+
+	switch {
+	case r.Method == "GET" && len(path) == 1 && path[0] == "namespaces":
+		items := []kubernetesNamespace{}
+		selector := strings.SplitN(r.URL.Query().Get("labelSelector"), "=", 2)
+		for _, ns := range k.Namespaces {
+			if len(selector) == 2 && ns.Metadata.Labels[selector[0]] == selector[1] {
+				items = append(items, ns)
+			}
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+
+	case r.Method == "GET" && len(path) == 1 && path[0] == "nodes":
+		items := []kubernetesNode{}
+		selector := strings.SplitN(r.URL.Query().Get("labelSelector"), "=", 2)
+		for _, node := range k.Nodes {
+			if len(selector) < 2 || node.Metadata.Labels[selector[0]] == selector[1] {
+				items = append(items, node)
+			}
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+
+	case r.Method == "GET" && len(path) == 4 && path[2] == "resourcequotas":
+		rq := k.ResourceQuotas[path[1]]
+		if rq == nil || rq.Metadata.Name != path[3] {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		respondJSON(w, http.StatusOK, rq)
+
+	case r.Method == "PATCH" && len(path) == 4 && path[2] == "resourcequotas":
+		rq := k.ResourceQuotas[path[1]]
+		if rq == nil || rq.Metadata.Name != path[3] {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+			http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+			return
+		}
+		var patch kubernetesResourceQuota
+		err := json.NewDecoder(r.Body).Decode(&patch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for key, value := range patch.Spec.Hard {
+			rq.Spec.Hard[key] = value
+		}
+		respondJSON(w, http.StatusOK, rq)
+
+	case r.Method == "POST" && len(path) == 3 && path[2] == "resourcequotas":
+		var rq kubernetesResourceQuota
+		err := json.NewDecoder(r.Body).Decode(&rq)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		k.ResourceQuotas[path[1]] = &rq
+		respondJSON(w, http.StatusCreated, rq)
+
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func TestKubernetesPlugin(t *testing.T) {
+	k8s := &fakeKubernetes{
+		Namespaces: []kubernetesNamespace{{
+			Metadata: kubernetesObjectMeta{Name: "team-one", Labels: map[string]string{"openstack.org/project-id": "uuid-for-project"}},
+		}},
+		ResourceQuotas: make(map[string]*kubernetesResourceQuota),
+	}
+	srv := httptest.NewServer(k8s)
+	defer srv.Close()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	err := ioutil.WriteFile(tokenPath, []byte("secret-token\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg core.ServiceConfiguration
+	cfg.Kubernetes.APIConfig.URL = srv.URL
+	cfg.Kubernetes.TokenPath = tokenPath
+	plugin := &kubernetesPlugin{cfg: cfg.Kubernetes}
+	err = plugin.Init(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//without ResourceQuota, quota is infinite and usage is not known
+	expectScrape(t, plugin, nil, gophercloud.EndpointOpts{}, "uuid-for-project", map[string]core.ResourceData{
+		"persistentvolumeclaims":     {Quota: -1},
+		"requests_cpu":               {Quota: -1},
+		"requests_ephemeral_storage": {Quota: -1},
+		"requests_memory":            {Quota: -1},
+		"requests_storage":           {Quota: -1},
+	})
+
+	//first SetQuota creates the ResourceQuota
+	err = plugin.SetQuota(nil, gophercloud.EndpointOpts{}, "uuid-for-domain", "uuid-for-project", map[string]uint64{
+		"persistentvolumeclaims":     10,
+		"requests_cpu":               4,
+		"requests_ephemeral_storage": 50,
+		"requests_memory":            2048,
+		"requests_storage":           100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rq := k8s.ResourceQuotas["team-one"]
+	if rq == nil || rq.Metadata.Name != "limes" {
+		t.Fatalf("expected ResourceQuota \"limes\" to be created, but got %#v", rq)
+	}
+	expectedHard := map[string]string{
+		"persistentvolumeclaims":     "10",
+		"requests.cpu":               "4",
+		"requests.ephemeral-storage": "53687091200",
+		"requests.memory":            "2147483648",
+		"requests.storage":           "107374182400",
+	}
+	if !reflect.DeepEqual(rq.Spec.Hard, expectedHard) {
+		t.Errorf("expected spec.hard = %#v, but got %#v", expectedHard, rq.Spec.Hard)
+	}
+
+	//simulate the ResourceQuota controller
+	rq.Status.Used = map[string]string{
+		"persistentvolumeclaims":     "3",
+		"requests.cpu":               "1500m",
+		"requests.ephemeral-storage": "10Gi",
+		"requests.memory":            "1536Mi",
+		"requests.storage":           "20Gi",
+	}
+	expectScrape(t, plugin, nil, gophercloud.EndpointOpts{}, "uuid-for-project", map[string]core.ResourceData{
+		"persistentvolumeclaims":     {Quota: 10, Usage: 3},
+		"requests_cpu":               {Quota: 4, Usage: 2},
+		"requests_ephemeral_storage": {Quota: 50, Usage: 10},
+		"requests_memory":            {Quota: 2048, Usage: 1536},
+		"requests_storage":           {Quota: 100, Usage: 20},
+	})
+
+	//second SetQuota updates the existing ResourceQuota
+	err = plugin.SetQuota(nil, gophercloud.EndpointOpts{}, "uuid-for-domain", "uuid-for-project", map[string]uint64{
+		"persistentvolumeclaims":     10,
+		"requests_cpu":               8,
+		"requests_ephemeral_storage": 50,
+		"requests_memory":            2048,
+		"requests_storage":           100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rq.Spec.Hard["requests.cpu"] != "8" {
+		t.Errorf("expected requests.cpu = 8, but got %q", rq.Spec.Hard["requests.cpu"])
+	}
+
+	//projects without namespace have zero quota, and only zero quota can be set
+	expectScrape(t, plugin, nil, gophercloud.EndpointOpts{}, "uuid-for-other-project", map[string]core.ResourceData{
+		"persistentvolumeclaims":     {Quota: 0},
+		"requests_cpu":               {Quota: 0},
+		"requests_ephemeral_storage": {Quota: 0},
+		"requests_memory":            {Quota: 0},
+		"requests_storage":           {Quota: 0},
+	})
+	err = plugin.SetQuota(nil, gophercloud.EndpointOpts{}, "uuid-for-domain", "uuid-for-other-project", map[string]uint64{"requests_cpu": 0})
+	if err != nil {
+		t.Error(err)
+	}
+	err = plugin.SetQuota(nil, gophercloud.EndpointOpts{}, "uuid-for-domain", "uuid-for-other-project", map[string]uint64{"requests_cpu": 1})
+	if err == nil {
+		t.Error("expected SetQuota to fail for project without namespace, but got no error")
+	}
+
+	//requests fail when the token cannot be read
+	os.Remove(tokenPath)
+	_, _, err = plugin.Scrape(nil, gophercloud.EndpointOpts{}, "uuid-for-domain", "uuid-for-project")
+	if err == nil {
+		t.Error("expected Scrape to fail without token, but got no error")
+	}
+}
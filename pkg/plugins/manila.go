@@ -38,13 +38,16 @@ import (
 )
 
 type manilaPlugin struct {
-	cfg              core.ServiceConfiguration
-	hasReplicaQuotas bool
+	cfg core.ServiceConfiguration
+	//computed by Init() from the microversions supported by Manila
+	microversion        string
+	hasReplicaQuotas    bool
+	hasShareGroupQuotas bool
 }
 
 func init() {
 	core.RegisterQuotaPlugin(func(c core.ServiceConfiguration, scrapeSubresources map[string]bool) core.QuotaPlugin {
-		return &manilaPlugin{c, false}
+		return &manilaPlugin{cfg: c}
 	})
 }
 
@@ -65,7 +68,20 @@ func (p *manilaPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud
 	if microversion == 0 {
 		return errors.New(`cannot find API microversion: no version of the form "2.x" found in advertisement`)
 	}
+
+	//use the highest microversion that we know about and that Manila supports
+	switch {
+	case microversion >= 53:
+		p.microversion = "2.53" //for replica quotas
+	case microversion >= 40:
+		p.microversion = "2.40" //for share group quotas
+	case microversion >= 39:
+		p.microversion = "2.39" //for share-type-specific quotas
+	default:
+		return fmt.Errorf("Manila API microversion 2.39 or newer is required, but only 2.%d is supported", microversion)
+	}
 	p.hasReplicaQuotas = microversion >= 53
+	p.hasShareGroupQuotas = microversion >= 40
 
 	return nil
 }
@@ -103,12 +119,26 @@ func (p *manilaPlugin) ServiceInfo() limes.ServiceInfo {
 
 //Resources implements the core.QuotaPlugin interface.
 func (p *manilaPlugin) Resources() []limes.ResourceInfo {
-	result := make([]limes.ResourceInfo, 0, 1+4*len(p.cfg.ShareV2.ShareTypes))
+	result := make([]limes.ResourceInfo, 0, 3+4*len(p.cfg.ShareV2.ShareTypes))
 	result = append(result, limes.ResourceInfo{
 		Name:     "share_networks",
 		Unit:     limes.UnitNone,
 		Category: "sharev2",
 	})
+	if p.hasShareGroupQuotas {
+		result = append(result,
+			limes.ResourceInfo{
+				Name:     "share_groups",
+				Unit:     limes.UnitNone,
+				Category: "sharev2",
+			},
+			limes.ResourceInfo{
+				Name:     "share_group_snapshots",
+				Unit:     limes.UnitNone,
+				Category: "sharev2",
+			},
+		)
+	}
 	for _, shareType := range p.cfg.ShareV2.ShareTypes {
 		stName := shareType.Name
 		category := p.makeResourceName("sharev2", stName)
@@ -160,6 +190,8 @@ type manilaQuotaSet struct {
 	ReplicaGigabytes    uint64  `json:"-"`
 	Replicas            uint64  `json:"-"`
 	ShareNetworks       *uint64 `json:"share_networks,omitempty"`
+	ShareGroups         *uint64 `json:"share_groups,omitempty"`
+	ShareGroupSnapshots *uint64 `json:"share_group_snapshots,omitempty"`
 	ReplicaGigabytesPtr *uint64 `json:"replica_gigabytes,omitempty"`
 	ReplicasPtr         *uint64 `json:"share_replicas,omitempty"`
 }
@@ -175,12 +207,7 @@ func (p *manilaPlugin) Scrape(provider *gophercloud.ProviderClient, eo gopherclo
 	if err != nil {
 		return nil, "", err
 	}
-	//share-type-specific quotas need 2.39, replica quotas need 2.53
-	if p.hasReplicaQuotas {
-		client.Microversion = "2.53"
-	} else {
-		client.Microversion = "2.39"
-	}
+	client.Microversion = p.microversion
 
 	quotaSets := make(map[string]manilaQuotaSetDetail)
 	for _, shareType := range p.cfg.ShareV2.ShareTypes {
@@ -190,7 +217,7 @@ func (p *manilaPlugin) Scrape(provider *gophercloud.ProviderClient, eo gopherclo
 		}
 	}
 
-	//the share_networks quota is only shown when querying for no share_type in particular
+	//the share_networks and share group quotas are only shown when querying for no share_type in particular
 	quotaSets[""], err = manilaCollectQuota(client, projectUUID, "")
 	if err != nil {
 		return nil, "", err
@@ -207,6 +234,10 @@ func (p *manilaPlugin) Scrape(provider *gophercloud.ProviderClient, eo gopherclo
 	result := map[string]core.ResourceData{
 		"share_networks": quotaSets[""].ShareNetworks.ToResourceData(nil),
 	}
+	if p.hasShareGroupQuotas {
+		result["share_groups"] = quotaSets[""].ShareGroups.ToResourceData(nil)
+		result["share_group_snapshots"] = quotaSets[""].ShareGroupSnapshots.ToResourceData(nil)
+	}
 	for idx, shareType := range p.cfg.ShareV2.ShareTypes {
 		stName := shareType.Name
 		gigabytesPhysical := (*uint64)(nil)
@@ -248,7 +279,7 @@ func (p *manilaPlugin) SetQuota(provider *gophercloud.ProviderClient, eo gopherc
 	if err != nil {
 		return err
 	}
-	client.Microversion = "2.39" //for share-type-specific quota
+	client.Microversion = p.microversion
 	expect200 := &gophercloud.RequestOpts{OkCodes: []int{200}}
 
 	//General note: Even though it complicates the code, we need to set overall
@@ -259,6 +290,12 @@ func (p *manilaPlugin) SetQuota(provider *gophercloud.ProviderClient, eo gopherc
 	overallQuotas := manilaQuotaSet{
 		ShareNetworks: &shareNetworkQuota,
 	}
+	if p.hasShareGroupQuotas {
+		shareGroupQuota := quotas["share_groups"]
+		shareGroupSnapshotQuota := quotas["share_group_snapshots"]
+		overallQuotas.ShareGroups = &shareGroupQuota
+		overallQuotas.ShareGroupSnapshots = &shareGroupSnapshotQuota
+	}
 	shareTypeQuotas := make(map[string]manilaQuotaSet)
 	anyReplicationEnabled := false
 
@@ -335,13 +372,15 @@ func (p *manilaPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, do
 ////////////////////////////////////////////////////////////////////////////////
 
 type manilaQuotaSetDetail struct {
-	Gigabytes         manilaQuotaDetail `json:"gigabytes"`
-	Shares            manilaQuotaDetail `json:"shares"`
-	SnapshotGigabytes manilaQuotaDetail `json:"snapshot_gigabytes"`
-	Snapshots         manilaQuotaDetail `json:"snapshots"`
-	ReplicaGigabytes  manilaQuotaDetail `json:"replica_gigabytes"`
-	Replicas          manilaQuotaDetail `json:"share_replicas"`
-	ShareNetworks     manilaQuotaDetail `json:"share_networks,omitempty"`
+	Gigabytes           manilaQuotaDetail `json:"gigabytes"`
+	Shares              manilaQuotaDetail `json:"shares"`
+	SnapshotGigabytes   manilaQuotaDetail `json:"snapshot_gigabytes"`
+	Snapshots           manilaQuotaDetail `json:"snapshots"`
+	ReplicaGigabytes    manilaQuotaDetail `json:"replica_gigabytes"`
+	Replicas            manilaQuotaDetail `json:"share_replicas"`
+	ShareNetworks       manilaQuotaDetail `json:"share_networks,omitempty"`
+	ShareGroups         manilaQuotaDetail `json:"share_groups,omitempty"`
+	ShareGroupSnapshots manilaQuotaDetail `json:"share_group_snapshots,omitempty"`
 }
 
 type manilaQuotaDetail struct {
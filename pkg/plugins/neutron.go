@@ -20,13 +20,10 @@
 package plugins
 
 import (
-	"fmt"
 	"math/big"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
-	"github.com/gophercloud/gophercloud/openstack/common/extensions"
-	octavia_quotas "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/quotas"
 	neutron_quotas "github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/quotas"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/limes"
@@ -34,14 +31,11 @@ import (
 )
 
 type neutronPlugin struct {
-	cfg               core.ServiceConfiguration
-	resources         []limes.ResourceInfo
-	hasLBaaSExtension bool //TODO remove after migrating to newer Neutron
-	hasOctavia        bool
+	cfg       core.ServiceConfiguration
+	resources []limes.ResourceInfo
 }
 
 var neutronResources = []limes.ResourceInfo{
-	////////// SDN resources
 	{
 		Name:     "floating_ips",
 		Unit:     limes.UnitNone,
@@ -91,37 +85,6 @@ var neutronResources = []limes.ResourceInfo{
 		Unit:     limes.UnitNone,
 		Category: "networking",
 	},
-	////////// LBaaS resources
-	{
-		Name:     "healthmonitors",
-		Unit:     limes.UnitNone,
-		Category: "loadbalancing",
-	},
-	{
-		Name:     "l7policies",
-		Unit:     limes.UnitNone,
-		Category: "loadbalancing",
-	},
-	{
-		Name:     "listeners",
-		Unit:     limes.UnitNone,
-		Category: "loadbalancing",
-	},
-	{
-		Name:     "loadbalancers",
-		Unit:     limes.UnitNone,
-		Category: "loadbalancing",
-	},
-	{
-		Name:     "pools",
-		Unit:     limes.UnitNone,
-		Category: "loadbalancing",
-	},
-	{
-		Name:     "pool_members",
-		Unit:     limes.UnitNone,
-		Category: "loadbalancing",
-	},
 }
 
 func init() {
@@ -135,33 +98,6 @@ func init() {
 
 //Init implements the core.QuotaPlugin interface.
 func (p *neutronPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
-	client, err := openstack.NewNetworkV2(provider, eo)
-	if err != nil {
-		return err
-	}
-
-	// LBaaSv2 supported?
-	r := extensions.Get(client, "lbaasv2")
-	switch r.Result.Err.(type) {
-	case gophercloud.ErrDefault404:
-		p.hasLBaaSExtension = false
-	case nil:
-		p.hasLBaaSExtension = true
-	default:
-		return fmt.Errorf("cannot check for lbaasv2 support in Neutron: %s", r.Result.Err.Error())
-	}
-
-	// Octavia supported?
-	_, err = openstack.NewLoadBalancerV2(provider, eo)
-	switch err.(type) {
-	case *gophercloud.ErrEndpointNotFound:
-		p.hasOctavia = false
-	case nil:
-		p.hasOctavia = true
-	default:
-		return err
-	}
-
 	return nil
 }
 
@@ -228,43 +164,6 @@ var neutronResourceMeta = []neutronResourceMetadata{
 	},
 }
 
-type octaviaResourceMetadata struct {
-	LimesName         string
-	OctaviaName       string
-	LegacyOctaviaName string
-	DoNotSetQuota     bool
-}
-
-var octaviaResourceMeta = []octaviaResourceMetadata{
-	{
-		LimesName:         "loadbalancers",
-		OctaviaName:       "loadbalancer",
-		LegacyOctaviaName: "load_balancer",
-	},
-	{
-		LimesName:   "listeners",
-		OctaviaName: "listener",
-	},
-	{
-		LimesName:   "pools",
-		OctaviaName: "pool",
-	},
-	{
-		LimesName:         "healthmonitors",
-		OctaviaName:       "healthmonitor",
-		LegacyOctaviaName: "health_monitor",
-	},
-	{
-		LimesName:     "l7policies",
-		OctaviaName:   "l7policy",
-		DoNotSetQuota: true, //this quota is supported from Victoria onwards, but we have an older Octavia at the moment
-	},
-	{
-		LimesName:   "pool_members",
-		OctaviaName: "member",
-	},
-}
-
 type neutronQueryOpts struct {
 	Fields      string `q:"fields"`
 	ProjectUUID string `q:"tenant_id"`
@@ -283,14 +182,6 @@ func (p *neutronPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercl
 	if err != nil {
 		return nil, "", err
 	}
-
-	if p.hasOctavia {
-		err = p.scrapeOctaviaInto(data, provider, eo, projectUUID)
-		if err != nil {
-			return nil, "", err
-		}
-	}
-
 	return data, "", nil
 }
 
@@ -325,65 +216,6 @@ func (p *neutronPlugin) scrapeNeutronInto(result map[string]core.ResourceData, p
 	return nil
 }
 
-func (p *neutronPlugin) scrapeOctaviaInto(result map[string]core.ResourceData, provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, projectUUID string) error {
-	octaviaV2, err := openstack.NewLoadBalancerV2(provider, eo)
-	if err != nil {
-		return err
-	}
-
-	//read Octavia quota
-	var quotas struct {
-		Values map[string]int64 `json:"quota"`
-	}
-	err = octavia_quotas.Get(octaviaV2, projectUUID).ExtractInto(&quotas)
-	if err != nil {
-		return err
-	}
-
-	//read Octavia quota
-	usage, err := p.scrapeOctaviaUsage(octaviaV2, projectUUID)
-	if err != nil {
-		return err
-	}
-
-	for _, res := range octaviaResourceMeta {
-		quota, exists := quotas.Values[res.OctaviaName]
-		if !exists {
-			quota = quotas.Values[res.LegacyOctaviaName]
-		}
-		result[res.LimesName] = core.ResourceData{
-			Quota: quota,
-			Usage: usage[res.OctaviaName],
-		}
-	}
-	return nil
-}
-
-// scrapeOctaviaUsage returns Octavia quota usage for a project.
-func (p *neutronPlugin) scrapeOctaviaUsage(client *gophercloud.ServiceClient, projectID string) (map[string]uint64, error) {
-	var (
-		usage struct {
-			Values map[string]uint64 `json:"quota_usage"`
-		}
-		r gophercloud.Result
-	)
-	usage.Values = make(map[string]uint64)
-	resp, err := client.Get(client.ServiceURL("quota_usage", projectID), &r.Body, nil)
-	if err != nil {
-		return usage.Values, err
-	}
-
-	// parse response
-	_, r.Header, r.Err = gophercloud.ParseResponse(resp, err)
-
-	// read Octavia quota
-	if err := r.ExtractInto(&usage); err != nil {
-		return usage.Values, err
-	}
-
-	return usage.Values, err
-}
-
 type neutronOrOctaviaQuotaSet map[string]uint64
 
 //ToQuotaUpdateMap implements the neutron_quotas.UpdateOpts and octavia_quotas.UpdateOpts interfaces.
@@ -401,51 +233,13 @@ func (p *neutronPlugin) SetQuota(provider *gophercloud.ProviderClient, eo gopher
 			neutronQuotas[res.NeutronName] = quota
 		}
 	}
-	//even if Octavia is there, when Neutron has the LBaaS extension enabled
-	//(with the proxy driver for Octavia), it needs to know about LBaaS quotas as
-	//well since it also enforces them
-	if p.hasLBaaSExtension {
-		for _, res := range octaviaResourceMeta {
-			quota, exists := quotas[res.LimesName]
-			//NOTE: do not check DoNotSetQuota here, since Neutron knows how to deal with the "l7policy" quota
-			if exists {
-				neutronQuotas[res.OctaviaName] = quota
-			}
-		}
-	}
-
 	//set Neutron quotas
 	networkV2, err := openstack.NewNetworkV2(provider, eo)
 	if err != nil {
 		return err
 	}
 	_, err = neutron_quotas.Update(networkV2, projectUUID, neutronQuotas).Extract()
-	if err != nil {
-		return err
-	}
-
-	if p.hasOctavia {
-		//collect Octavia quotas
-		octaviaQuotas := make(neutronOrOctaviaQuotaSet)
-		for _, res := range octaviaResourceMeta {
-			quota, exists := quotas[res.LimesName]
-			if exists && !res.DoNotSetQuota {
-				octaviaQuotas[res.OctaviaName] = quota
-			}
-		}
-
-		//set Octavia quotas
-		octaviaV2, err := openstack.NewLoadBalancerV2(provider, eo)
-		if err != nil {
-			return err
-		}
-		_, err = octavia_quotas.Update(octaviaV2, projectUUID, octaviaQuotas).Extract()
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return err
 }
 
 //DescribeMetrics implements the core.QuotaPlugin interface.
@@ -0,0 +1,331 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/common/extensions"
+	octavia_quotas "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/quotas"
+	neutron_quotas "github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/quotas"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+type octaviaPlugin struct {
+	cfg               core.ServiceConfiguration
+	hasOctavia        bool
+	hasLBaaSExtension bool //TODO remove after migrating to newer Neutron
+}
+
+//NOTE: These resources used to be part of the "network" service. Existing
+//quotas are moved over by datamodel.ValidateProjectServices() and
+//datamodel.ValidateDomainServices().
+var octaviaResources = []limes.ResourceInfo{
+	{
+		Name: "healthmonitors",
+		Unit: limes.UnitNone,
+	},
+	{
+		Name: "l7policies",
+		Unit: limes.UnitNone,
+	},
+	{
+		Name: "listeners",
+		Unit: limes.UnitNone,
+	},
+	{
+		Name: "loadbalancers",
+		Unit: limes.UnitNone,
+	},
+	{
+		Name: "pools",
+		Unit: limes.UnitNone,
+	},
+	{
+		Name: "pool_members",
+		Unit: limes.UnitNone,
+	},
+}
+
+type octaviaResourceMetadata struct {
+	LimesName         string
+	OctaviaName       string
+	LegacyOctaviaName string
+	DoNotSetQuota     bool
+}
+
+var octaviaResourceMeta = []octaviaResourceMetadata{
+	{
+		LimesName:         "loadbalancers",
+		OctaviaName:       "loadbalancer",
+		LegacyOctaviaName: "load_balancer",
+	},
+	{
+		LimesName:   "listeners",
+		OctaviaName: "listener",
+	},
+	{
+		LimesName:   "pools",
+		OctaviaName: "pool",
+	},
+	{
+		LimesName:         "healthmonitors",
+		OctaviaName:       "healthmonitor",
+		LegacyOctaviaName: "health_monitor",
+	},
+	{
+		LimesName:     "l7policies",
+		OctaviaName:   "l7policy",
+		DoNotSetQuota: true, //this quota is supported from Victoria onwards, but we have an older Octavia at the moment
+	},
+	{
+		LimesName:   "pool_members",
+		OctaviaName: "member",
+	},
+}
+
+func init() {
+	core.RegisterQuotaPlugin(func(c core.ServiceConfiguration, scrapeSubresources map[string]bool) core.QuotaPlugin {
+		return &octaviaPlugin{cfg: c}
+	})
+}
+
+//Init implements the core.QuotaPlugin interface.
+func (p *octaviaPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
+	//Octavia supported?
+	_, err := openstack.NewLoadBalancerV2(provider, eo)
+	switch err.(type) {
+	case *gophercloud.ErrEndpointNotFound:
+		p.hasOctavia = false
+	case nil:
+		p.hasOctavia = true
+	default:
+		return err
+	}
+
+	//LBaaSv2 supported by Neutron?
+	networkV2, err := openstack.NewNetworkV2(provider, eo)
+	switch err.(type) {
+	case *gophercloud.ErrEndpointNotFound:
+		p.hasLBaaSExtension = false
+	case nil:
+		r := extensions.Get(networkV2, "lbaasv2")
+		switch r.Result.Err.(type) {
+		case gophercloud.ErrDefault404:
+			p.hasLBaaSExtension = false
+		case nil:
+			p.hasLBaaSExtension = true
+		default:
+			return fmt.Errorf("cannot check for lbaasv2 support in Neutron: %s", r.Result.Err.Error())
+		}
+	default:
+		return err
+	}
+
+	if !p.hasOctavia && !p.hasLBaaSExtension {
+		return errors.New("cannot find an Octavia endpoint in the service catalog, and Neutron does not have the lbaasv2 extension enabled")
+	}
+	return nil
+}
+
+//ServiceInfo implements the core.QuotaPlugin interface.
+func (p *octaviaPlugin) ServiceInfo() limes.ServiceInfo {
+	return limes.ServiceInfo{
+		Type:        "load-balancer",
+		ProductName: "octavia",
+		Area:        "loadbalancing",
+	}
+}
+
+//Resources implements the core.QuotaPlugin interface.
+func (p *octaviaPlugin) Resources() []limes.ResourceInfo {
+	return octaviaResources
+}
+
+//Rates implements the core.QuotaPlugin interface.
+func (p *octaviaPlugin) Rates() []limes.RateInfo {
+	return nil
+}
+
+//ScrapeRates implements the core.QuotaPlugin interface.
+func (p *octaviaPlugin) ScrapeRates(client *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, prevSerializedState string) (result map[string]*big.Int, serializedState string, err error) {
+	return nil, "", nil
+}
+
+//Scrape implements the core.QuotaPlugin interface.
+func (p *octaviaPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string) (map[string]core.ResourceData, string, error) {
+	if !p.hasOctavia {
+		return p.scrapeNeutronLBaaS(provider, eo, projectUUID)
+	}
+
+	octaviaV2, err := openstack.NewLoadBalancerV2(provider, eo)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//read Octavia quota
+	var quotas struct {
+		Values map[string]int64 `json:"quota"`
+	}
+	err = octavia_quotas.Get(octaviaV2, projectUUID).ExtractInto(&quotas)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//read Octavia usage
+	usage, err := p.scrapeUsage(octaviaV2, projectUUID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := make(map[string]core.ResourceData, len(octaviaResourceMeta))
+	for _, res := range octaviaResourceMeta {
+		quota, exists := quotas.Values[res.OctaviaName]
+		if !exists {
+			quota = quotas.Values[res.LegacyOctaviaName]
+		}
+		result[res.LimesName] = core.ResourceData{
+			Quota: quota,
+			Usage: usage[res.OctaviaName],
+		}
+	}
+	return result, "", nil
+}
+
+//scrapeNeutronLBaaS is used instead of Scrape() when there is no Octavia, but
+//Neutron still provides LBaaS.
+func (p *octaviaPlugin) scrapeNeutronLBaaS(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, projectUUID string) (map[string]core.ResourceData, string, error) {
+	networkV2, err := openstack.NewNetworkV2(provider, eo)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//read Neutron quota/usage
+	type neutronQuotaStruct struct {
+		Quota int64  `json:"limit"`
+		Usage uint64 `json:"used"`
+	}
+	var quotas struct {
+		Values map[string]neutronQuotaStruct `json:"quota"`
+	}
+	err = neutron_quotas.GetDetail(networkV2, projectUUID).ExtractInto(&quotas)
+	if err != nil {
+		return nil, "", err
+	}
+
+	//Neutron uses the same names as Octavia for these resources
+	result := make(map[string]core.ResourceData, len(octaviaResourceMeta))
+	for _, res := range octaviaResourceMeta {
+		values := quotas.Values[res.OctaviaName]
+		result[res.LimesName] = core.ResourceData{
+			Quota: values.Quota,
+			Usage: values.Usage,
+		}
+	}
+	return result, "", nil
+}
+
+//scrapeUsage returns Octavia quota usage for a project.
+func (p *octaviaPlugin) scrapeUsage(client *gophercloud.ServiceClient, projectID string) (map[string]uint64, error) {
+	var (
+		usage struct {
+			Values map[string]uint64 `json:"quota_usage"`
+		}
+		r gophercloud.Result
+	)
+	usage.Values = make(map[string]uint64)
+	resp, err := client.Get(client.ServiceURL("quota_usage", projectID), &r.Body, nil)
+	if err != nil {
+		return usage.Values, err
+	}
+
+	// parse response
+	_, r.Header, r.Err = gophercloud.ParseResponse(resp, err)
+
+	// read Octavia quota
+	if err := r.ExtractInto(&usage); err != nil {
+		return usage.Values, err
+	}
+
+	return usage.Values, err
+}
+
+//SetQuota implements the core.QuotaPlugin interface.
+func (p *octaviaPlugin) SetQuota(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, quotas map[string]uint64) error {
+	//when Neutron has the LBaaS extension enabled (with the proxy driver for
+	//Octavia), it needs to know about LBaaS quotas as well since it also
+	//enforces them
+	if p.hasLBaaSExtension {
+		neutronQuotas := make(neutronOrOctaviaQuotaSet)
+		for _, res := range octaviaResourceMeta {
+			quota, exists := quotas[res.LimesName]
+			//NOTE: do not check DoNotSetQuota here, since Neutron knows how to deal with the "l7policy" quota
+			if exists {
+				neutronQuotas[res.OctaviaName] = quota
+			}
+		}
+
+		networkV2, err := openstack.NewNetworkV2(provider, eo)
+		if err != nil {
+			return err
+		}
+		_, err = neutron_quotas.Update(networkV2, projectUUID, neutronQuotas).Extract()
+		if err != nil {
+			return err
+		}
+	}
+	if !p.hasOctavia {
+		return nil
+	}
+
+	//collect Octavia quotas
+	octaviaQuotas := make(neutronOrOctaviaQuotaSet)
+	for _, res := range octaviaResourceMeta {
+		quota, exists := quotas[res.LimesName]
+		if exists && !res.DoNotSetQuota {
+			octaviaQuotas[res.OctaviaName] = quota
+		}
+	}
+
+	//set Octavia quotas
+	octaviaV2, err := openstack.NewLoadBalancerV2(provider, eo)
+	if err != nil {
+		return err
+	}
+	_, err = octavia_quotas.Update(octaviaV2, projectUUID, octaviaQuotas).Extract()
+	return err
+}
+
+//DescribeMetrics implements the core.QuotaPlugin interface.
+func (p *octaviaPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	//not used by this plugin
+}
+
+//CollectMetrics implements the core.QuotaPlugin interface.
+func (p *octaviaPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, domainUUID, projectUUID, serializedMetrics string) error {
+	//not used by this plugin
+	return nil
+}
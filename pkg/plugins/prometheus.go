@@ -0,0 +1,161 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"text/template"
+
+	"github.com/gophercloud/gophercloud"
+	prom_v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+)
+
+type prometheusQuotaPlugin struct {
+	cfg          core.ServiceConfiguration
+	resources    []limes.ResourceInfo
+	usageQueries map[string]*template.Template
+	client       prom_v1.API
+}
+
+func init() {
+	core.RegisterConfigurableQuotaPlugin("prometheus", func(c core.ServiceConfiguration, scrapeSubresources map[string]bool) core.QuotaPlugin {
+		return &prometheusQuotaPlugin{cfg: c}
+	})
+}
+
+//Init implements the core.QuotaPlugin interface.
+func (p *prometheusQuotaPlugin) Init(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) error {
+	if len(p.cfg.Prometheus.Resources) == 0 {
+		return errors.New("missing configuration parameter: resources")
+	}
+
+	var err error
+	p.client, err = prometheusClient(p.cfg.Prometheus.APIConfig)
+	if err != nil {
+		return err
+	}
+
+	p.resources = make([]limes.ResourceInfo, len(p.cfg.Prometheus.Resources))
+	p.usageQueries = make(map[string]*template.Template, len(p.cfg.Prometheus.Resources))
+	for idx, res := range p.cfg.Prometheus.Resources {
+		if res.Name == "" {
+			return fmt.Errorf("missing configuration parameter: resources[%d].name", idx)
+		}
+		if res.UsageQuery == "" {
+			return fmt.Errorf("missing configuration parameter: resources[%d].usage_query", idx)
+		}
+		tmpl, err := template.New(res.Name).Option("missingkey=error").Parse(res.UsageQuery)
+		if err != nil {
+			return fmt.Errorf("cannot parse usage_query for resource %s: %s", res.Name, err.Error())
+		}
+		p.usageQueries[res.Name] = tmpl
+		p.resources[idx] = limes.ResourceInfo{
+			Name:     res.Name,
+			Unit:     res.Unit,
+			Category: res.Category,
+			NoQuota:  res.NoQuota,
+		}
+	}
+	return nil
+}
+
+//ServiceInfo implements the core.QuotaPlugin interface.
+func (p *prometheusQuotaPlugin) ServiceInfo() limes.ServiceInfo {
+	productName := p.cfg.Prometheus.ProductName
+	if productName == "" {
+		productName = p.cfg.Type
+	}
+	return limes.ServiceInfo{
+		Type:        p.cfg.Type,
+		ProductName: productName,
+		Area:        p.cfg.Prometheus.Area,
+	}
+}
+
+//Resources implements the core.QuotaPlugin interface.
+func (p *prometheusQuotaPlugin) Resources() []limes.ResourceInfo {
+	return p.resources
+}
+
+//Rates implements the core.QuotaPlugin interface.
+func (p *prometheusQuotaPlugin) Rates() []limes.RateInfo {
+	return nil
+}
+
+//ScrapeRates implements the core.QuotaPlugin interface.
+func (p *prometheusQuotaPlugin) ScrapeRates(client *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, prevSerializedState string) (result map[string]*big.Int, serializedState string, err error) {
+	return nil, "", nil
+}
+
+//Scrape implements the core.QuotaPlugin interface.
+func (p *prometheusQuotaPlugin) Scrape(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string) (map[string]core.ResourceData, string, error) {
+	templateData := struct {
+		DomainID  string
+		ProjectID string
+	}{domainUUID, projectUUID}
+
+	result := make(map[string]core.ResourceData, len(p.resources))
+	for _, res := range p.resources {
+		var query strings.Builder
+		err := p.usageQueries[res.Name].Execute(&query, templateData)
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot render usage_query for resource %s: %s", res.Name, err.Error())
+		}
+		//no data means no usage
+		defaultValue := float64(0)
+		usage, err := prometheusGetSingleValue(p.client, query.String(), &defaultValue)
+		if err != nil {
+			return nil, "", err
+		}
+		if usage < 0 {
+			usage = 0
+		}
+
+		//there is no backend that stores quota for these resources
+		result[res.Name] = core.ResourceData{
+			QuotaOnlyInLimes: true,
+			Usage:            uint64(usage),
+		}
+	}
+	return result, "", nil
+}
+
+//SetQuota implements the core.QuotaPlugin interface.
+func (p *prometheusQuotaPlugin) SetQuota(provider *gophercloud.ProviderClient, eo gophercloud.EndpointOpts, domainUUID, projectUUID string, quotas map[string]uint64) error {
+	//there is no backend to write quota into; the quota is only stored in Limes
+	return nil
+}
+
+//DescribeMetrics implements the core.QuotaPlugin interface.
+func (p *prometheusQuotaPlugin) DescribeMetrics(ch chan<- *prometheus.Desc) {
+	//not used by this plugin
+}
+
+//CollectMetrics implements the core.QuotaPlugin interface.
+func (p *prometheusQuotaPlugin) CollectMetrics(ch chan<- prometheus.Metric, clusterID, domainUUID, projectUUID, serializedMetrics string) error {
+	//not used by this plugin
+	return nil
+}
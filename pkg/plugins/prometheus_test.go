@@ -0,0 +1,88 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const prometheusQuotaPluginTestConfig = `
+type: unittest
+plugin: prometheus
+prometheus:
+  area: testing
+  resources:
+    - name: bandwidth
+      unit: GiB
+      usage_query: sum(bandwidth_gigabytes{project_id="{{.ProjectID}}"})
+    - name: gpu_hours
+      usage_query: sum(gpu_hours{domain_id="{{.DomainID}}",project_id="{{.ProjectID}}"})
+      no_quota: true
+`
+
+func TestPrometheusQuotaPlugin(t *testing.T) {
+	srv := httptest.NewServer(fakePrometheus{
+		`sum(bandwidth_gigabytes{project_id="uuid-for-project"})`: {
+			promSample("23.5", nil),
+		},
+		//no data for this project, so usage is zero
+		`sum(gpu_hours{domain_id="uuid-for-domain",project_id="uuid-for-project"})`: {},
+	})
+	defer srv.Close()
+
+	var cfg core.ServiceConfiguration
+	err := yaml.Unmarshal([]byte(prometheusQuotaPluginTestConfig), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Prometheus.APIConfig.URL = srv.URL
+	plugin := &prometheusQuotaPlugin{cfg: cfg}
+	err = plugin.Init(nil, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info := plugin.ServiceInfo(); info.Type != "unittest" || info.Area != "testing" {
+		t.Errorf("unexpected ServiceInfo: %#v", info)
+	}
+	resources := plugin.Resources()
+	if len(resources) != 2 || resources[0].Unit != limes.UnitGibibytes || resources[0].NoQuota || !resources[1].NoQuota {
+		t.Errorf("unexpected resources: %#v", resources)
+	}
+
+	//quota only exists in Limes, so no backend quota is reported, neither
+	//before nor after SetQuota
+	expected := map[string]core.ResourceData{
+		"bandwidth": {QuotaOnlyInLimes: true, Usage: 23},
+		"gpu_hours": {QuotaOnlyInLimes: true, Usage: 0},
+	}
+	expectScrape(t, plugin, nil, gophercloud.EndpointOpts{}, "uuid-for-project", expected)
+	err = plugin.SetQuota(nil, gophercloud.EndpointOpts{}, "uuid-for-domain", "uuid-for-project", map[string]uint64{"bandwidth": 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectScrape(t, plugin, nil, gophercloud.EndpointOpts{}, "uuid-for-project", expected)
+}
@@ -0,0 +1,167 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package reports
+
+import (
+	"github.com/sapcc/limes"
+	"github.com/sapcc/limes/pkg/core"
+	"github.com/sapcc/limes/pkg/db"
+)
+
+//AggregateClusterID is the ID of the report returned by GetAggregateCluster.
+const AggregateClusterID = "aggregate"
+
+//GetAggregateCluster returns a single ClusterReport that combines the reports
+//of all configured clusters. Each resource contains a per-cluster breakdown.
+//
+//Capacity for shared services is stored only once (with cluster_id = 'shared')
+//and reported identically for each cluster sharing the service, so it is only
+//counted once in the aggregate and not included in the breakdown.
+func GetAggregateCluster(config core.Configuration, dbi db.Interface, filter Filter) (*limes.ClusterReport, error) {
+	//usage and quota must be reported per cluster (instead of the global sums
+	//for shared services), otherwise shared usage would be counted repeatedly
+	filter.LocalQuotaUsageOnly = true
+	filter.WithRates = false
+	filter.OnlyRates = false
+	//subcapacities cannot be merged meaningfully
+	filter.WithSubcapacities = false
+
+	clusterReports, err := GetClusters(config, nil, dbi, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &limes.ClusterReport{
+		ID:       AggregateClusterID,
+		Services: make(limes.ClusterServiceReports),
+	}
+	for _, cluster := range clusterReports {
+		mergeScrapedAt(&result.MinScrapedAt, &result.MaxScrapedAt, cluster.MinScrapedAt, cluster.MaxScrapedAt)
+
+		for _, srv := range cluster.Services {
+			aggSrv, exists := result.Services[srv.Type]
+			if !exists {
+				aggSrv = &limes.ClusterServiceReport{
+					ServiceInfo: srv.ServiceInfo,
+					Resources:   make(limes.ClusterResourceReports),
+				}
+				result.Services[srv.Type] = aggSrv
+			}
+			aggSrv.Shared = aggSrv.Shared || srv.Shared
+			mergeScrapedAt(&aggSrv.MinScrapedAt, &aggSrv.MaxScrapedAt, srv.MinScrapedAt, srv.MaxScrapedAt)
+			mergeScrapedAt(&aggSrv.MinRatesScrapedAt, &aggSrv.MaxRatesScrapedAt, srv.MinRatesScrapedAt, srv.MaxRatesScrapedAt)
+
+			for _, res := range srv.Resources {
+				aggRes, exists := aggSrv.Resources[res.Name]
+				if !exists {
+					aggRes = &limes.ClusterResourceReport{
+						ResourceInfo: res.ResourceInfo,
+						PerCluster:   make(limes.ClusterResourceBreakdownReports),
+					}
+					aggSrv.Resources[res.Name] = aggRes
+				}
+				mergeClusterResource(aggRes, res, cluster.ID, srv.Shared)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func mergeClusterResource(aggRes, res *limes.ClusterResourceReport, clusterID string, isShared bool) {
+	breakdown := &limes.ClusterResourceBreakdownReport{
+		ClusterID:     clusterID,
+		DomainsQuota:  res.DomainsQuota,
+		Usage:         res.Usage,
+		BurstUsage:    res.BurstUsage,
+		PhysicalUsage: res.PhysicalUsage,
+	}
+	aggRes.PerCluster[clusterID] = breakdown
+
+	aggRes.DomainsQuota = addOptional(aggRes.DomainsQuota, res.DomainsQuota)
+	aggRes.Usage += res.Usage
+	aggRes.BurstUsage += res.BurstUsage
+	aggRes.PhysicalUsage = addOptional(aggRes.PhysicalUsage, res.PhysicalUsage)
+
+	if isShared {
+		//shared capacity is the same for all clusters sharing this service, so
+		//take it from the first cluster that reports it
+		if aggRes.Capacity == nil && res.Capacity != nil {
+			aggRes.Capacity = res.Capacity
+			aggRes.RawCapacity = res.RawCapacity
+			aggRes.CapacityPerAZ = copyAZReports(res.CapacityPerAZ)
+		}
+		return
+	}
+
+	breakdown.Capacity = res.Capacity
+	breakdown.RawCapacity = res.RawCapacity
+	aggRes.Capacity = addOptional(aggRes.Capacity, res.Capacity)
+	aggRes.RawCapacity = addOptional(aggRes.RawCapacity, res.RawCapacity)
+	if len(res.CapacityPerAZ) > 0 {
+		if aggRes.CapacityPerAZ == nil {
+			aggRes.CapacityPerAZ = make(limes.ClusterAvailabilityZoneReports)
+		}
+		for azName, az := range res.CapacityPerAZ {
+			aggAZ, exists := aggRes.CapacityPerAZ[azName]
+			if !exists {
+				aggAZ = &limes.ClusterAvailabilityZoneReport{Name: az.Name}
+				aggRes.CapacityPerAZ[azName] = aggAZ
+			}
+			aggAZ.Capacity += az.Capacity
+			aggAZ.RawCapacity += az.RawCapacity
+			aggAZ.Usage += az.Usage
+		}
+	}
+}
+
+func addOptional(sum, value *uint64) *uint64 {
+	if value == nil {
+		return sum
+	}
+	result := *value
+	if sum != nil {
+		result += *sum
+	}
+	return &result
+}
+
+func copyAZReports(reports limes.ClusterAvailabilityZoneReports) limes.ClusterAvailabilityZoneReports {
+	if reports == nil {
+		return nil
+	}
+	result := make(limes.ClusterAvailabilityZoneReports, len(reports))
+	for azName, az := range reports {
+		azCopy := *az
+		result[azName] = &azCopy
+	}
+	return result
+}
+
+func mergeScrapedAt(aggMin, aggMax **int64, minScrapedAt, maxScrapedAt *int64) {
+	if minScrapedAt != nil && (*aggMin == nil || **aggMin > *minScrapedAt) {
+		val := *minScrapedAt
+		*aggMin = &val
+	}
+	if maxScrapedAt != nil && (*aggMax == nil || **aggMax < *maxScrapedAt) {
+		val := *maxScrapedAt
+		*aggMax = &val
+	}
+}
@@ -77,6 +77,55 @@ var clusterReportQuery5 = db.SimplifyWhitespaceInSQL(`
 	 WHERE %s GROUP BY ps.type, pr.name
 `)
 
+//These are used instead of clusterReportQuery{1,2,4,5} when
+//Filter.FromAggregateCache is set. They return the same columns, but leave
+//scraping timestamps and physical usage empty since those are not cached.
+//Like SUM(pr.quota), the projects quota is NULL if no project has a quota.
+var clusterReportCachedQuery1 = db.SimplifyWhitespaceInSQL(`
+	SELECT ca.cluster_id, ca.service_type, ca.resource_name,
+	       CASE WHEN ca.projects_with_quota > 0 THEN ca.projects_quota END, ca.usage, ca.burst_usage,
+	       NULL, FALSE, NULL, NULL, NULL, NULL
+	  FROM cluster_aggregates ca
+	 WHERE %s {{AND ca.service_type = $service_type}} {{AND ca.resource_name = $resource_name}}
+`)
+
+var clusterReportCachedQuery2 = db.SimplifyWhitespaceInSQL(`
+	SELECT ca.cluster_id, ca.service_type, ca.resource_name, ca.domains_quota
+	  FROM cluster_aggregates ca
+	 WHERE %s {{AND ca.service_type = $service_type}} {{AND ca.resource_name = $resource_name}}
+`)
+
+var clusterReportCachedQuery4 = db.SimplifyWhitespaceInSQL(`
+	SELECT ca.service_type, ca.resource_name, SUM(ca.domains_quota)
+	  FROM cluster_aggregates ca
+	 WHERE %s GROUP BY ca.service_type, ca.resource_name
+`)
+
+var clusterReportCachedQuery5 = db.SimplifyWhitespaceInSQL(`
+	SELECT ca.service_type, ca.resource_name, SUM(ca.usage), NULL, FALSE
+	  FROM cluster_aggregates ca
+	 WHERE %s GROUP BY ca.service_type, ca.resource_name
+`)
+
+type clusterReportQueries struct {
+	Query1, Query2, Query4, Query5 string
+	//the table alias for the cluster_id column in Query1 and Query2
+	ClusterTable string
+	//the columns for the service type filter in Query4 and Query5
+	DomainServiceTypeColumn, ProjectServiceTypeColumn string
+}
+
+var (
+	liveClusterReportQueries = clusterReportQueries{
+		clusterReportQuery1, clusterReportQuery2, clusterReportQuery4, clusterReportQuery5,
+		"d", "ds.type", "ps.type",
+	}
+	cachedClusterReportQueries = clusterReportQueries{
+		clusterReportCachedQuery1, clusterReportCachedQuery2, clusterReportCachedQuery4, clusterReportCachedQuery5,
+		"ca", "ca.service_type", "ca.service_type",
+	}
+)
+
 //GetClusters returns reports for all clusters or, if clusterID is
 //non-nil, for that cluster only.
 //
@@ -84,12 +133,22 @@ var clusterReportQuery5 = db.SimplifyWhitespaceInSQL(`
 //core.Configuration (instead of just the current core.ClusterConfiguration)
 //to look at the services enabled in other clusters.
 func GetClusters(config core.Configuration, clusterID *string, dbi db.Interface, filter Filter) ([]*limes.ClusterReport, error) {
+	//take one snapshot of the reloadable settings of each cluster for the whole report
+	settingsByClusterID := make(map[string]*core.ClusterSettings, len(config.Clusters))
+	for id, cluster := range config.Clusters {
+		settingsByClusterID[id] = cluster.Settings()
+	}
+
 	//first query: collect project usage data in these clusters
 	clusters := make(clusters)
+	queries := liveClusterReportQueries
+	if filter.FromAggregateCache {
+		queries = cachedClusterReportQueries
+	}
 
 	if !filter.OnlyRates {
-		queryStr, joinArgs := filter.PrepareQuery(clusterReportQuery1)
-		whereStr, whereArgs := db.BuildSimpleWhereClause(makeClusterFilter("d", clusterID), len(joinArgs))
+		queryStr, joinArgs := filter.PrepareQuery(queries.Query1)
+		whereStr, whereArgs := db.BuildSimpleWhereClause(makeClusterFilter(queries.ClusterTable, clusterID), len(joinArgs))
 		err := db.ForeachRow(db.DB, fmt.Sprintf(queryStr, whereStr), append(joinArgs, whereArgs...), func(rows *sql.Rows) error {
 			var (
 				clusterID         string
@@ -167,8 +226,8 @@ func GetClusters(config core.Configuration, clusterID *string, dbi db.Interface,
 		}
 
 		//second query: collect domain quota data in these clusters
-		queryStr, joinArgs = filter.PrepareQuery(clusterReportQuery2)
-		whereStr, whereArgs = db.BuildSimpleWhereClause(makeClusterFilter("d", clusterID), len(joinArgs))
+		queryStr, joinArgs = filter.PrepareQuery(queries.Query2)
+		whereStr, whereArgs = db.BuildSimpleWhereClause(makeClusterFilter(queries.ClusterTable, clusterID), len(joinArgs))
 		err = db.ForeachRow(db.DB, fmt.Sprintf(queryStr, whereStr), append(joinArgs, whereArgs...), func(rows *sql.Rows) error {
 			var (
 				clusterID    string
@@ -218,7 +277,7 @@ func GetClusters(config core.Configuration, clusterID *string, dbi db.Interface,
 			cluster, _, resource := clusters.Find(config, clusterID, &serviceType, resourceName)
 
 			if resource != nil {
-				overcommitFactor := config.Clusters[clusterID].BehaviorForResource(serviceType, *resourceName, "").OvercommitFactor
+				overcommitFactor := settingsByClusterID[clusterID].BehaviorForResource(serviceType, *resourceName, "").OvercommitFactor
 				if overcommitFactor == 0 {
 					resource.Capacity = rawCapacity
 				} else {
@@ -278,8 +337,8 @@ func GetClusters(config core.Configuration, clusterID *string, dbi db.Interface,
 				for serviceType := range isSharedService {
 					sharedServiceTypes = append(sharedServiceTypes, serviceType)
 				}
-				whereStr, queryArgs := db.BuildSimpleWhereClause(map[string]interface{}{"ds.type": sharedServiceTypes}, 0)
-				err = db.ForeachRow(db.DB, fmt.Sprintf(clusterReportQuery4, whereStr), queryArgs, func(rows *sql.Rows) error {
+				whereStr, queryArgs := db.BuildSimpleWhereClause(map[string]interface{}{queries.DomainServiceTypeColumn: sharedServiceTypes}, 0)
+				err = db.ForeachRow(db.DB, fmt.Sprintf(queries.Query4, whereStr), queryArgs, func(rows *sql.Rows) error {
 					var (
 						serviceType  string
 						resourceName string
@@ -301,13 +360,13 @@ func GetClusters(config core.Configuration, clusterID *string, dbi db.Interface,
 				}
 
 				//fifth query: aggregate project quota for shared services
-				whereStr, queryArgs = db.BuildSimpleWhereClause(map[string]interface{}{"ps.type": sharedServiceTypes}, 0)
+				whereStr, queryArgs = db.BuildSimpleWhereClause(map[string]interface{}{queries.ProjectServiceTypeColumn: sharedServiceTypes}, 0)
 				type usageSum struct {
 					Usage         uint64
 					PhysicalUsage *uint64
 				}
 				sharedUsageSums := make(map[string]map[string]usageSum)
-				err = db.ForeachRow(db.DB, fmt.Sprintf(clusterReportQuery5, whereStr), queryArgs, func(rows *sql.Rows) error {
+				err = db.ForeachRow(db.DB, fmt.Sprintf(queries.Query5, whereStr), queryArgs, func(rows *sql.Rows) error {
 					var (
 						serviceType       string
 						resourceName      string
@@ -394,7 +453,7 @@ func GetClusters(config core.Configuration, clusterID *string, dbi db.Interface,
 					_, _, resource := clusters.Find(config, cluster.ID, &serviceType, resourceName)
 
 					if resource != nil {
-						overcommitFactor := config.Clusters[cluster.ID].BehaviorForResource(serviceType, *resourceName, "").OvercommitFactor
+						overcommitFactor := settingsByClusterID[cluster.ID].BehaviorForResource(serviceType, *resourceName, "").OvercommitFactor
 						if overcommitFactor == 0 {
 							resource.Capacity = rawCapacity
 						} else {
@@ -22,7 +22,6 @@ package reports
 import (
 	"database/sql"
 	"fmt"
-	"sort"
 	"time"
 
 	"github.com/sapcc/limes"
@@ -52,16 +51,93 @@ var domainReportQuery2 = db.SimplifyWhitespaceInSQL(`
 	 WHERE %s
 `)
 
-//GetDomains returns reports for all domains in the given cluster or, if
-//domainID is non-nil, for that domain only.
-func GetDomains(cluster *core.Cluster, domainID *int64, dbi db.Interface, filter Filter) ([]*limes.DomainReport, error) {
-	clusterCanBurst := cluster.Config.Bursting.MaxMultiplier > 0
+var domainPageQueries = map[string]listPageQuery{
+	"": {
+		From: `FROM domains d WHERE %s`,
+	},
+	"usage": {
+		From: db.SimplifyWhitespaceInSQL(`
+		  FROM domains d
+		  LEFT OUTER JOIN projects p ON p.domain_id = d.id
+		  LEFT OUTER JOIN project_services ps ON ps.project_id = p.id {{AND ps.type = $service_type}}
+		  LEFT OUTER JOIN project_resources pr ON pr.service_id = ps.id {{AND pr.name = $resource_name}}
+		 WHERE %s`),
+		SortExpr: `COALESCE(SUM(pr.usage), 0)::BIGINT`,
+	},
+	"quota": {
+		From: db.SimplifyWhitespaceInSQL(`
+		  FROM domains d
+		  LEFT OUTER JOIN domain_services ds ON ds.domain_id = d.id {{AND ds.type = $service_type}}
+		  LEFT OUTER JOIN domain_resources dr ON dr.service_id = ds.id {{AND dr.name = $resource_name}}
+		 WHERE %s`),
+		SortExpr: `COALESCE(SUM(dr.quota), 0)::BIGINT`,
+	},
+	"scraped_at": {
+		//domains containing projects that have not been scraped yet sort first
+		From: db.SimplifyWhitespaceInSQL(`
+		  FROM domains d
+		  LEFT OUTER JOIN projects p ON p.domain_id = d.id
+		  LEFT OUTER JOIN project_services ps ON ps.project_id = p.id {{AND ps.type = $service_type}}
+		 WHERE %s`),
+		SortExpr: `MIN(COALESCE(EXTRACT(EPOCH FROM ps.scraped_at), 0))::BIGINT`,
+	},
+}
 
+//GetDomains generates reports for all domains in the given cluster or, if
+//domainID is non-nil, for that domain only. The ListOptions select, sort and
+//paginate the domains.
+//
+//Like GetProjects, reports are given to `submit` as soon as they are complete
+//instead of being collected in memory.
+func GetDomains(cluster *core.Cluster, domainID *int64, dbi db.Interface, filter Filter, opts ListOptions, submit func(report *limes.DomainReport, nextCursor string) error) (string, error) {
 	fields := map[string]interface{}{"d.cluster_id": cluster.ID}
 	if domainID != nil {
 		fields["d.id"] = *domainID
 	}
 
+	//find the domains on the requested page first
+	q := domainPageQueries[opts.SortBy]
+	if q.From == "" {
+		q = domainPageQueries[""]
+	}
+	q.IDColumn, q.UUIDColumn, q.NameColumn = "d.id", "d.uuid", "d.name"
+	q.ProjectScope = "pu.domain_id = d.id"
+	page, err := opts.selectPage(dbi, q, filter, fields)
+	if err != nil {
+		return "", err
+	}
+
+	//then render the reports in chunks to keep the memory usage bounded
+	//
+	//All chunks use the same snapshot of the reloadable settings.
+	settings := cluster.Settings()
+	for offset := 0; offset < len(page.IDs); offset += reportChunkSize {
+		end := offset + reportChunkSize
+		if end > len(page.IDs) {
+			end = len(page.IDs)
+		}
+		domains, err := getDomainsChunk(cluster, settings, page.IDs[offset:end], filter)
+		if err != nil {
+			return "", err
+		}
+		for _, uuid := range page.UUIDs[offset:end] {
+			if domain, exists := domains[uuid]; exists {
+				err := submit(domain, page.NextCursor)
+				if err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+
+	return page.NextCursor, nil
+}
+
+//getDomainsChunk generates the reports for the given domains.
+func getDomainsChunk(cluster *core.Cluster, settings *core.ClusterSettings, domainIDs []interface{}, filter Filter) (domains, error) {
+	clusterCanBurst := cluster.Config.Bursting.MaxMultiplier > 0
+	fields := map[string]interface{}{"d.id": domainIDs}
+
 	//first query: data for projects in this domain
 	domains := make(domains)
 	queryStr, joinArgs := filter.PrepareQuery(domainReportQuery1)
@@ -96,7 +172,7 @@ func GetDomains(cluster *core.Cluster, domainID *int64, dbi db.Interface, filter
 			return err
 		}
 
-		_, service, resource := domains.Find(cluster, domainUUID, domainName, serviceType, resourceName)
+		_, service, resource := domains.Find(cluster, settings, domainUUID, domainName, serviceType, resourceName)
 
 		if service != nil {
 			if maxScrapedAt != nil {
@@ -172,7 +248,7 @@ func GetDomains(cluster *core.Cluster, domainID *int64, dbi db.Interface, filter
 			return err
 		}
 
-		_, _, resource := domains.Find(cluster, domainUUID, domainName, serviceType, resourceName)
+		_, _, resource := domains.Find(cluster, settings, domainUUID, domainName, serviceType, resourceName)
 
 		if resource != nil && quota != nil && !resource.NoQuota {
 			resource.DomainQuota = quota
@@ -196,23 +272,12 @@ func GetDomains(cluster *core.Cluster, domainID *int64, dbi db.Interface, filter
 		}
 	}
 
-	//flatten result (with stable order to keep the tests happy)
-	uuids := make([]string, 0, len(domains))
-	for uuid := range domains {
-		uuids = append(uuids, uuid)
-	}
-	sort.Strings(uuids)
-	result := make([]*limes.DomainReport, len(domains))
-	for idx, uuid := range uuids {
-		result[idx] = domains[uuid]
-	}
-
-	return result, nil
+	return domains, nil
 }
 
 type domains map[string]*limes.DomainReport
 
-func (d domains) Find(cluster *core.Cluster, domainUUID, domainName string, serviceType, resourceName *string) (*limes.DomainReport, *limes.DomainServiceReport, *limes.DomainResourceReport) {
+func (d domains) Find(cluster *core.Cluster, settings *core.ClusterSettings, domainUUID, domainName string, serviceType, resourceName *string) (*limes.DomainReport, *limes.DomainServiceReport, *limes.DomainResourceReport) {
 	domain, exists := d[domainUUID]
 	if !exists {
 		domain = &limes.DomainReport{
@@ -248,7 +313,7 @@ func (d domains) Find(cluster *core.Cluster, domainUUID, domainName string, serv
 		if !cluster.HasResource(*serviceType, *resourceName) {
 			return domain, service, resource
 		}
-		behavior := cluster.BehaviorForResource(*serviceType, *resourceName, domainName)
+		behavior := settings.BehaviorForResource(*serviceType, *resourceName, domainName)
 		resource = &limes.DomainResourceReport{
 			ResourceInfo: cluster.InfoForResource(*serviceType, *resourceName),
 			Scaling:      behavior.ToScalingBehavior(),
@@ -40,12 +40,19 @@ type Filter struct {
 	WithSubresources    bool
 	LocalQuotaUsageOnly bool
 	WithSubcapacities   bool
+	//If set, cluster reports are built from the `cluster_aggregates` table
+	//instead of from the project and domain data. Such reports only contain the
+	//sums of quota and usage (i.e. no scraping timestamps and physical usage).
+	//The caller must ensure that datamodel.FillClusterAggregates() has been
+	//called for all clusters.
+	FromAggregateCache bool
 
 	IsSubcapacityAllowed func(serviceType, resourceName string) bool
 }
 
-//ReadFilter extracts a Filter from the given Request.
-func ReadFilter(r *http.Request) Filter {
+//ReadFilter extracts a Filter from the given Request. The given clusters are
+//used to resolve the "area" query parameter into service types.
+func ReadFilter(r *http.Request, clusters ...*core.Cluster) Filter {
 	var (
 		f  Filter
 		ok bool
@@ -72,7 +79,9 @@ func ReadFilter(r *http.Request) Filter {
 	if areas, ok := queryValues["area"]; ok {
 		var areaServices []string
 		for _, area := range areas {
-			areaServices = append(areaServices, core.GetServiceTypesForArea(area)...)
+			for _, cluster := range clusters {
+				areaServices = append(areaServices, cluster.ServiceTypesForArea(area)...)
+			}
 		}
 
 		if len(f.ServiceTypes) == 0 {
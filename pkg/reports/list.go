@@ -0,0 +1,326 @@
+/*******************************************************************************
+*
+* Copyright 2021 SAP SE
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You should have received a copy of the License along with this
+* program. If not, you may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*
+*******************************************************************************/
+
+package reports
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sapcc/limes/pkg/db"
+)
+
+//ListOptions describes query parameters that can be sent to the list
+//endpoints (GET /v1/domains and GET /v1/domains/:id/projects) to select,
+//sort and paginate the objects that are reported on.
+type ListOptions struct {
+	//NamePattern restricts the list to objects whose name matches this pattern.
+	//The only supported wildcard is "*".
+	NamePattern string
+	//ParentUUID restricts the list to projects with this parent (only for projects).
+	ParentUUID string
+
+	//The following fields restrict the list to projects (or domains containing
+	//projects) where at least one resource (or service, for ScrapedBefore)
+	//matches the respective condition. Only the services and resources
+	//selected by the Filter are considered. If multiple conditions are given,
+	//all of them must match, but not necessarily on the same resource.
+	UsageAboveQuota       bool
+	QuotaUtilizationAbove *float64
+	HasBursted            bool
+	ScrapedBefore         *time.Time
+
+	//SortBy is one of "name", "usage", "quota", "scraped_at", or empty (which
+	//sorts by UUID).
+	SortBy         string
+	SortDescending bool
+
+	//Limit is the maximum number of objects in one page (0 = no limit).
+	Limit uint64
+	//Cursor is the value of `next_cursor` from the previous page, if any.
+	Cursor *listCursor
+}
+
+//listCursor is serialized into the opaque `cursor` query parameter. It
+//contains the sort key and UUID of the last object on the previous page.
+type listCursor struct {
+	SortBy  string `json:"s"`
+	Desc    bool   `json:"d,omitempty"`
+	NameKey string `json:"n,omitempty"`
+	IntKey  int64  `json:"i,omitempty"`
+	UUID    string `json:"u"`
+}
+
+//The list endpoints render reports in chunks of this many objects at once.
+const reportChunkSize = 50
+
+//MaxListLimit is the largest value that is accepted for the `limit` query
+//parameter of the list endpoints.
+const MaxListLimit = 10000
+
+var isValidSortKey = map[string]bool{
+	"name":       true,
+	"usage":      true,
+	"quota":      true,
+	"scraped_at": true,
+}
+
+//ReadListOptions extracts a ListOptions from the given Request. The Filter is
+//used to validate that sorting by usage or quota refers to exactly one
+//resource.
+func ReadListOptions(r *http.Request, filter Filter) (ListOptions, error) {
+	var opts ListOptions
+	queryValues := r.URL.Query()
+
+	opts.NamePattern = queryValues.Get("name")
+	opts.ParentUUID = queryValues.Get("parent_id")
+
+	_, opts.UsageAboveQuota = queryValues["usage_gt_quota"]
+	_, opts.HasBursted = queryValues["has_bursted"]
+	if valueStr := queryValues.Get("quota_utilization_above"); valueStr != "" {
+		value, err := strconv.ParseFloat(valueStr, 64)
+		if err != nil || value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+			return ListOptions{}, fmt.Errorf("invalid value for quota_utilization_above: %q (expected a non-negative number, e.g. 0.9 for 90%%)", valueStr)
+		}
+		opts.QuotaUtilizationAbove = &value
+	}
+	if valueStr := queryValues.Get("scraped_before"); valueStr != "" {
+		value, err := strconv.ParseInt(valueStr, 10, 64)
+		if err != nil {
+			return ListOptions{}, fmt.Errorf("invalid value for scraped_before: %q (expected a UNIX timestamp)", valueStr)
+		}
+		scrapedBefore := time.Unix(value, 0).UTC()
+		opts.ScrapedBefore = &scrapedBefore
+	}
+
+	if sortBy := queryValues.Get("sort"); sortBy != "" {
+		if strings.HasPrefix(sortBy, "-") {
+			opts.SortDescending = true
+			sortBy = strings.TrimPrefix(sortBy, "-")
+		}
+		if !isValidSortKey[sortBy] {
+			return ListOptions{}, fmt.Errorf("invalid value for sort: %q (expected one of name, usage, quota, scraped_at, optionally with \"-\" prefix)", sortBy)
+		}
+		if (sortBy == "usage" || sortBy == "quota") && (len(filter.ServiceTypes) != 1 || len(filter.ResourceNames) != 1) {
+			return ListOptions{}, fmt.Errorf("sort=%s requires exactly one service and one resource to be selected with the service= and resource= query parameters", sortBy)
+		}
+		opts.SortBy = sortBy
+	}
+
+	if limitStr := queryValues.Get("limit"); limitStr != "" {
+		limit, err := strconv.ParseUint(limitStr, 10, 64)
+		if err != nil || limit == 0 || limit > MaxListLimit {
+			return ListOptions{}, fmt.Errorf("invalid value for limit: %q (expected a positive integer up to %d)", limitStr, MaxListLimit)
+		}
+		opts.Limit = limit
+	}
+
+	if cursorStr := queryValues.Get("cursor"); cursorStr != "" {
+		cursor, err := decodeListCursor(cursorStr)
+		if err != nil {
+			return ListOptions{}, errors.New("invalid value for cursor")
+		}
+		//the cursor is only meaningful when the sort order did not change
+		if cursor.SortBy != opts.SortBy || cursor.Desc != opts.SortDescending {
+			return ListOptions{}, errors.New("cursor does not match the requested sort order")
+		}
+		opts.Cursor = &cursor
+	}
+
+	return opts, nil
+}
+
+func decodeListCursor(input string) (listCursor, error) {
+	var cursor listCursor
+	buf, err := base64.RawURLEncoding.DecodeString(input)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(buf, &cursor)
+	if err == nil && cursor.UUID == "" {
+		err = errors.New("missing UUID")
+	}
+	return cursor, err
+}
+
+func (c listCursor) String() string {
+	buf, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+//buildWhereClause is like db.BuildSimpleWhereClause, but adds the conditions
+//for the name pattern and for the usage filters if requested.
+func (o ListOptions) buildWhereClause(fields map[string]interface{}, q listPageQuery, filter Filter, parameterOffset int) (string, []interface{}) {
+	whereStr, whereArgs := db.BuildSimpleWhereClause(fields, parameterOffset)
+	nextPlaceholder := func(arg interface{}) string {
+		whereArgs = append(whereArgs, arg)
+		return fmt.Sprintf("$%d", parameterOffset+len(whereArgs))
+	}
+	if o.NamePattern != "" {
+		whereStr += fmt.Sprintf(" AND %s LIKE %s", q.NameColumn, nextPlaceholder(namePatternToLike(o.NamePattern)))
+	}
+
+	//each usage condition becomes an EXISTS subquery on the projects in scope
+	addUsageCondition := func(withResources bool, condition string) {
+		subqueryFields := map[string]interface{}{}
+		if len(filter.ServiceTypes) > 0 {
+			subqueryFields["psu.type"] = filter.ServiceTypes
+		}
+		join := ""
+		if withResources {
+			join = " JOIN project_resources pru ON pru.service_id = psu.id"
+			if len(filter.ResourceNames) > 0 {
+				subqueryFields["pru.name"] = filter.ResourceNames
+			}
+		}
+		subqueryWhereStr, subqueryArgs := db.BuildSimpleWhereClause(subqueryFields, parameterOffset+len(whereArgs))
+		whereArgs = append(whereArgs, subqueryArgs...)
+		whereStr += fmt.Sprintf(
+			" AND EXISTS (SELECT 1 FROM projects pu JOIN project_services psu ON psu.project_id = pu.id%s WHERE %s AND %s AND (%s))",
+			join, q.ProjectScope, subqueryWhereStr, condition,
+		)
+	}
+	if o.UsageAboveQuota {
+		addUsageCondition(true, "pru.usage > pru.quota")
+	}
+	if o.QuotaUtilizationAbove != nil {
+		addUsageCondition(true, "pru.quota > 0 AND pru.usage > pru.quota * "+nextPlaceholder(*o.QuotaUtilizationAbove)+"::FLOAT8")
+	}
+	if o.HasBursted {
+		addUsageCondition(true, "pu.has_bursting AND pru.usage > pru.quota")
+	}
+	if o.ScrapedBefore != nil {
+		addUsageCondition(false, "psu.scraped_at IS NULL OR psu.scraped_at < "+nextPlaceholder(*o.ScrapedBefore))
+	}
+
+	return whereStr, whereArgs
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `*`, `%`)
+
+func namePatternToLike(pattern string) string {
+	return likeEscaper.Replace(pattern)
+}
+
+//listPageQuery contains the parts of a query that selects one page of objects
+//for a list endpoint.
+type listPageQuery struct {
+	//From is the FROM clause including JOINs (which can contain the
+	//placeholders understood by Filter.PrepareQuery), and must contain "%s" for
+	//the WHERE clause.
+	From string
+	//IDColumn, UUIDColumn and NameColumn refer to the object table.
+	IDColumn   string
+	UUIDColumn string
+	NameColumn string
+	//SortExpr is the aggregate expression for the sort key (only used when
+	//sorting by something other than name).
+	SortExpr string
+	//ProjectScope is the condition that relates the projects in the usage
+	//filter subqueries (table alias "pu") to the listed object.
+	ProjectScope string
+}
+
+//listPage is the result of executing a listPageQuery.
+type listPage struct {
+	IDs        []interface{}
+	UUIDs      []string
+	NextCursor string
+}
+
+//selectPage executes the given query to find the IDs and UUIDs of the
+//objects on the requested page, in the requested order.
+func (o ListOptions) selectPage(dbi db.Interface, q listPageQuery, filter Filter, fields map[string]interface{}) (listPage, error) {
+	//UUIDs are compared bytewise to get the same order as sort.Strings()
+	uuidExpr := q.UUIDColumn + ` COLLATE "C"`
+	sortExpr := q.SortExpr
+	switch o.SortBy {
+	case "":
+		sortExpr = uuidExpr
+	case "name":
+		sortExpr = q.NameColumn
+	}
+
+	queryStr, joinArgs := filter.PrepareQuery(q.From)
+	whereStr, args := o.buildWhereClause(fields, q, filter, len(joinArgs))
+	args = append(joinArgs, args...)
+	query := fmt.Sprintf(`SELECT %s, %s, %s `, q.IDColumn, q.UUIDColumn, sortExpr) +
+		fmt.Sprintf(queryStr, whereStr) +
+		fmt.Sprintf(` GROUP BY %s, %s, %s`, q.IDColumn, q.UUIDColumn, q.NameColumn)
+
+	direction, comparison := "ASC", ">"
+	if o.SortDescending {
+		direction, comparison = "DESC", "<"
+	}
+	if o.Cursor != nil {
+		var sortKey interface{} = o.Cursor.IntKey
+		if o.SortBy == "" || o.SortBy == "name" {
+			sortKey = o.Cursor.NameKey
+		}
+		query += fmt.Sprintf(` HAVING (%s, %s) %s ($%d, $%d)`, sortExpr, uuidExpr, comparison, len(args)+1, len(args)+2)
+		args = append(args, sortKey, o.Cursor.UUID)
+	}
+	query += fmt.Sprintf(` ORDER BY %s %s, %s %s`, sortExpr, direction, uuidExpr, direction)
+	if o.Limit > 0 {
+		//get one more row to find out if there is a next page
+		query += fmt.Sprintf(` LIMIT %d`, o.Limit+1)
+	}
+
+	var (
+		page       listPage
+		lastCursor listCursor
+	)
+	err := db.ForeachRow(dbi, query, args, func(rows *sql.Rows) error {
+		var (
+			id   int64
+			uuid string
+			key  interface{}
+		)
+		err := rows.Scan(&id, &uuid, &key)
+		if err != nil {
+			return err
+		}
+		if o.Limit > 0 && uint64(len(page.IDs)) == o.Limit {
+			page.NextCursor = lastCursor.String()
+			return nil
+		}
+
+		page.IDs = append(page.IDs, id)
+		page.UUIDs = append(page.UUIDs, uuid)
+		lastCursor = listCursor{SortBy: o.SortBy, Desc: o.SortDescending, UUID: uuid}
+		switch key := key.(type) {
+		case int64:
+			lastCursor.IntKey = key
+		case []byte:
+			lastCursor.NameKey = string(key)
+		case string:
+			lastCursor.NameKey = key
+		}
+		return nil
+	})
+	return page, err
+}
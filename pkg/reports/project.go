@@ -22,7 +22,6 @@ package reports
 import (
 	"database/sql"
 	"fmt"
-	"sort"
 	"strings"
 	"time"
 
@@ -46,17 +45,101 @@ var (
 	  JOIN project_rates pra ON pra.service_id = ps.id
 	 WHERE %s
 `)
-)
 
-//GetProjects returns limes.ProjectReport reports for all projects in the given domain or,
-//if projectID is non-nil, for that project only.
-func GetProjects(cluster *core.Cluster, domain db.Domain, projectID *int64, dbi db.Interface, filter Filter) ([]*limes.ProjectReport, error) {
-	clusterCanBurst := cluster.Config.Bursting.MaxMultiplier > 0
+	projectPageQueries = map[string]listPageQuery{
+		"": {
+			From: `FROM projects p WHERE %s`,
+		},
+		"usage": {
+			From: db.SimplifyWhitespaceInSQL(`
+			  FROM projects p
+			  LEFT OUTER JOIN project_services ps ON ps.project_id = p.id {{AND ps.type = $service_type}}
+			  LEFT OUTER JOIN project_resources pr ON pr.service_id = ps.id {{AND pr.name = $resource_name}}
+			 WHERE %s`),
+			SortExpr: `COALESCE(SUM(pr.usage), 0)::BIGINT`,
+		},
+		"quota": {
+			From: db.SimplifyWhitespaceInSQL(`
+			  FROM projects p
+			  LEFT OUTER JOIN project_services ps ON ps.project_id = p.id {{AND ps.type = $service_type}}
+			  LEFT OUTER JOIN project_resources pr ON pr.service_id = ps.id {{AND pr.name = $resource_name}}
+			 WHERE %s`),
+			SortExpr: `COALESCE(SUM(pr.quota), 0)::BIGINT`,
+		},
+		"scraped_at": {
+			//projects that have not been scraped yet sort first
+			From: db.SimplifyWhitespaceInSQL(`
+			  FROM projects p
+			  LEFT OUTER JOIN project_services ps ON ps.project_id = p.id {{AND ps.type = $service_type}}
+			 WHERE %s`),
+			SortExpr: `MIN(COALESCE(EXTRACT(EPOCH FROM ps.scraped_at), 0))::BIGINT`,
+		},
+	}
+)
 
+//GetProjects generates limes.ProjectReport reports for all projects in the
+//given domain or, if projectID is non-nil, for that project only. The
+//ListOptions select, sort and paginate the projects.
+//
+//Reports are not collected in memory. Instead, each report is given to
+//`submit` as soon as it is complete (in the requested order). If there are
+//more projects after the reported page, a cursor for the next page is
+//returned. Since this cursor is known before the first report is submitted,
+//it can also be obtained from the `nextCursor` argument of `submit`.
+func GetProjects(cluster *core.Cluster, domain db.Domain, projectID *int64, dbi db.Interface, filter Filter, opts ListOptions, submit func(report *limes.ProjectReport, nextCursor string) error) (string, error) {
 	fields := map[string]interface{}{"p.domain_id": domain.ID}
 	if projectID != nil {
 		fields["p.id"] = *projectID
 	}
+	if opts.ParentUUID != "" {
+		fields["p.parent_uuid"] = opts.ParentUUID
+	}
+
+	//find the projects on the requested page first
+	q := projectPageQueries[opts.SortBy]
+	if q.From == "" {
+		q = projectPageQueries[""]
+	}
+	q.IDColumn, q.UUIDColumn, q.NameColumn = "p.id", "p.uuid", "p.name"
+	q.ProjectScope = "pu.id = p.id"
+	page, err := opts.selectPage(dbi, q, filter, fields)
+	if err != nil {
+		return "", err
+	}
+
+	//then render the reports in chunks to keep the memory usage bounded
+	//regardless of the domain size (for example, a full project list with all
+	//resources for a domain with 2000 projects runs as large as 160 MiB for the
+	//pure JSON)
+	//
+	//All chunks use the same snapshot of the reloadable settings.
+	settings := cluster.Settings()
+	for offset := 0; offset < len(page.IDs); offset += reportChunkSize {
+		end := offset + reportChunkSize
+		if end > len(page.IDs) {
+			end = len(page.IDs)
+		}
+		projects, err := getProjectsChunk(cluster, settings, domain, page.IDs[offset:end], filter)
+		if err != nil {
+			return "", err
+		}
+		for _, uuid := range page.UUIDs[offset:end] {
+			if project, exists := projects[uuid]; exists {
+				err := submit(project, page.NextCursor)
+				if err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+
+	return page.NextCursor, nil
+}
+
+//getProjectsChunk generates the reports for the given projects.
+func getProjectsChunk(cluster *core.Cluster, settings *core.ClusterSettings, domain db.Domain, projectIDs []interface{}, filter Filter) (projects, error) {
+	clusterCanBurst := cluster.Config.Bursting.MaxMultiplier > 0
+	fields := map[string]interface{}{"p.id": projectIDs}
 
 	projects := make(projects)
 
@@ -117,7 +200,7 @@ func GetProjects(cluster *core.Cluster, domain db.Domain, projectID *int64, dbi
 			resReport.BackendQuota = nil //See below.
 			resReport.Subresources = limes.JSONString(subresourcesValue)
 
-			behavior := cluster.BehaviorForResource(*serviceType, *resourceName, domain.Name+"/"+projectName)
+			behavior := settings.BehaviorForResource(*serviceType, *resourceName, domain.Name+"/"+projectName)
 			resReport.Scaling = behavior.ToScalingBehavior()
 			resReport.Annotations = behavior.Annotations
 
@@ -228,18 +311,7 @@ func GetProjects(cluster *core.Cluster, domain db.Domain, projectID *int64, dbi
 		}
 	}
 
-	//flatten result (with stable order to keep the tests happy)
-	uuids := make([]string, 0, len(projects))
-	for uuid := range projects {
-		uuids = append(uuids, uuid)
-	}
-	sort.Strings(uuids)
-	result := make([]*limes.ProjectReport, len(projects))
-	for idx, uuid := range uuids {
-		result[idx] = projects[uuid]
-	}
-
-	return result, nil
+	return projects, nil
 }
 
 func p2window(val limes.Window) *limes.Window {
@@ -59,6 +59,21 @@ type ClusterResourceReport struct {
 	BurstUsage    uint64                         `json:"burst_usage,omitempty"`
 	PhysicalUsage *uint64                        `json:"physical_usage,omitempty"`
 	Subcapacities JSONString                     `json:"subcapacities,omitempty"`
+	//PerCluster is only filled in aggregated reports that cover multiple clusters.
+	PerCluster ClusterResourceBreakdownReports `json:"per_cluster,omitempty"`
+}
+
+//ClusterResourceBreakdownReport is a substructure of ClusterResourceReport
+//that appears in aggregated reports only. It contains the data for a single
+//resource in one of the aggregated clusters.
+type ClusterResourceBreakdownReport struct {
+	ClusterID     string  `json:"id"`
+	Capacity      *uint64 `json:"capacity,omitempty"`
+	RawCapacity   *uint64 `json:"raw_capacity,omitempty"`
+	DomainsQuota  *uint64 `json:"domains_quota,omitempty"`
+	Usage         uint64  `json:"usage,keepempty"`
+	BurstUsage    uint64  `json:"burst_usage,omitempty"`
+	PhysicalUsage *uint64 `json:"physical_usage,omitempty"`
 }
 
 //ClusterAvailabilityZoneReport is a substructure of ClusterResourceReport containing
@@ -211,3 +226,37 @@ func (r *ClusterRateLimitReports) UnmarshalJSON(b []byte) error {
 	*r = ClusterRateLimitReports(t)
 	return nil
 }
+
+//ClusterResourceBreakdownReports provides fast lookup of per-cluster data
+//using a map, but serializes to JSON as a list.
+type ClusterResourceBreakdownReports map[string]*ClusterResourceBreakdownReport
+
+//MarshalJSON implements the json.Marshaler interface.
+func (r ClusterResourceBreakdownReports) MarshalJSON() ([]byte, error) {
+	//serialize with ordered keys to ensure testcase stability
+	ids := make([]string, 0, len(r))
+	for id := range r {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	list := make([]*ClusterResourceBreakdownReport, len(r))
+	for idx, id := range ids {
+		list[idx] = r[id]
+	}
+	return json.Marshal(list)
+}
+
+//UnmarshalJSON implements the json.Unmarshaler interface.
+func (r *ClusterResourceBreakdownReports) UnmarshalJSON(b []byte) error {
+	tmp := make([]*ClusterResourceBreakdownReport, 0)
+	err := json.Unmarshal(b, &tmp)
+	if err != nil {
+		return err
+	}
+	t := make(ClusterResourceBreakdownReports)
+	for _, br := range tmp {
+		t[br.ClusterID] = br
+	}
+	*r = ClusterResourceBreakdownReports(t)
+	return nil
+}
@@ -91,6 +91,40 @@ var clusterServicesOnlyRatesMockJSON = `
 	]
 `
 
+var clusterResourceBreakdownMockJSON = `
+	[
+		{
+			"id": "east",
+			"capacity": 300,
+			"domains_quota": 120,
+			"usage": 60
+		},
+		{
+			"id": "west",
+			"capacity": 200,
+			"domains_quota": 80,
+			"usage": 40,
+			"burst_usage": 5
+		}
+	]
+`
+
+var clusterMockResourceBreakdown = &ClusterResourceBreakdownReports{
+	"east": {
+		ClusterID:    "east",
+		Capacity:     p2u64(300),
+		DomainsQuota: p2u64(120),
+		Usage:        60,
+	},
+	"west": {
+		ClusterID:    "west",
+		Capacity:     p2u64(200),
+		DomainsQuota: p2u64(80),
+		Usage:        40,
+		BurstUsage:   5,
+	},
+}
+
 var clusterMockResources = &ClusterResourceReports{
 	"cores": &ClusterResourceReport{
 		ResourceInfo: ResourceInfo{
@@ -195,3 +229,14 @@ func TestClusterServicesOnlyRatesUnmarshal(t *testing.T) {
 	th.AssertNoErr(t, err)
 	th.CheckDeepEquals(t, clusterServicesOnlyRates, actual)
 }
+
+func TestClusterResourceBreakdownMarshal(t *testing.T) {
+	th.CheckJSONEquals(t, clusterResourceBreakdownMockJSON, clusterMockResourceBreakdown)
+}
+
+func TestClusterResourceBreakdownUnmarshal(t *testing.T) {
+	actual := &ClusterResourceBreakdownReports{}
+	err := actual.UnmarshalJSON([]byte(clusterResourceBreakdownMockJSON))
+	th.AssertNoErr(t, err)
+	th.CheckDeepEquals(t, clusterMockResourceBreakdown, actual)
+}